@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+
+	"github.com/hexahigh/goava/lib/db"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+}
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage signature databases",
+	Long:  `Manage signature databases`,
+}
+
+// databasePath returns the database folder configured for the command with
+// the config string c, falling back to the last database folder used by scan,
+// which is the one with the highest precedence.
+func databasePath(c string) string {
+	if path := viper.GetString(c + ".database"); path != "" {
+		return path
+	}
+	paths := viper.GetStringSlice("scan.database")
+	if len(paths) == 0 {
+		return ""
+	}
+	return paths[len(paths)-1]
+}
+
+// trustedKey returns the manifest public key configured for the command with
+// the config string c, or nil if none is configured.
+func trustedKey(c string) (ed25519.PublicKey, error) {
+	path := viper.GetString(c + ".manifest-key")
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read manifest key: %w", err)
+	}
+	return db.ParsePublicKey(data)
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/hexahigh/goava/lib/db"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	bundleCreateCmd.Flags().StringP("database", "d", "", "Path to folder containing database files. Defaults to the last scan database")
+	bundleCreateCmd.Flags().Int64("version", 0, "Version of the bundle. Defaults to the version in the database manifest, or the current unix time")
+
+	bundleImportCmd.Flags().StringP("database", "d", "", "Path to folder the bundle is installed to. Defaults to the last scan database")
+	bundleImportCmd.Flags().BoolP("force", "f", false, "Import the bundle even if it is older than the installed database")
+	bundleImportCmd.Flags().Int("generations", 3, "Number of database generations to keep. 0 keeps every generation")
+	bundleImportCmd.Flags().Bool("migrate", false, "Convert a plain database folder with files in it to keep generations, moving its files into generations/1. Other programs writing to the folder, such as freshclam, will no longer be loaded from it")
+	bundleImportCmd.Flags().String("manifest-key", "", "Path to the ed25519 public key the bundle manifest must be signed with")
+
+	bundleCmd.AddCommand(bundleCreateCmd)
+	bundleCmd.AddCommand(bundleImportCmd)
+	dbCmd.AddCommand(bundleCmd)
+
+	configBindFlags(*bundleCreateCmd)
+	configBindFlags(*bundleImportCmd)
+}
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Create and import offline signature bundles",
+	Long: `Create and import offline signature bundles.
+
+A bundle is a single file containing a database folder, its manifest and the checksums of every file.
+It can be used to update hosts without network access.`,
+}
+
+var bundleCreateCmd = &cobra.Command{
+	Use:   "create file",
+	Short: "Pack the database into a bundle",
+	Long:  `Pack the database into a bundle`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c := commandToConfigString(*cmd)
+		log := logger.With().Str("component", c).Logger()
+
+		path := databasePath(c)
+		if path == "" {
+			log.Fatal().Msg("No database folder configured")
+		}
+
+		file, err := os.Create(args[0])
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error creating bundle file")
+		}
+
+		manifest, err := db.CreateBundle(path, file, viper.GetInt64(c+".version"))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(args[0])
+			log.Fatal().Err(err).Msg("Error creating bundle")
+		}
+
+		log.Info().Msgf("Created bundle %s with %d files, version %d", args[0], len(manifest.Files), manifest.Version)
+	},
+}
+
+var bundleImportCmd = &cobra.Command{
+	Use:   "import file",
+	Short: "Verify a bundle and install it as the database",
+	Long: `Verify a bundle and install it as the database.
+
+The bundle is installed as a new numbered generation in <database>/generations/<n>, and <database>/current links to it. A database folder that already contains files, such as one kept up to date by freshclam, is only converted to this layout with --migrate, which moves its files into generations/1.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c := commandToConfigString(*cmd)
+		log := logger.With().Str("component", c).Logger()
+
+		path := databasePath(c)
+		if path == "" {
+			log.Fatal().Msg("No database folder configured")
+		}
+
+		key, err := trustedKey(c)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error loading manifest key")
+		}
+
+		file, err := os.Open(args[0])
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error opening bundle file")
+		}
+		defer file.Close()
+
+		manifest, gen, err := db.ImportBundle(file, path, db.ImportOptions{
+			Force:      viper.GetBool(c + ".force"),
+			Keep:       viper.GetInt(c + ".generations"),
+			TrustedKey: key,
+			Migrate:    viper.GetBool(c + ".migrate"),
+		})
+		if errors.Is(err, db.ErrPlainFolder) {
+			log.Fatal().Err(err).Msg("Error importing bundle, pass --migrate to convert the database folder")
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error importing bundle")
+		}
+
+		log.Info().Msgf("Imported bundle %s with %d files, version %d, as generation %d", args[0], len(manifest.Files), manifest.Version, gen)
+	},
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/hexahigh/goava/lib/db"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	diffCmd.Flags().String("format", "text", "Output format. Supported values are: text, json")
+
+	dbCmd.AddCommand(diffCmd)
+
+	configBindFlags(*diffCmd)
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff old new",
+	Short: "Show the signatures that changed between two databases",
+	Long: `Show the signatures that changed between two databases.
+
+Both databases can be a database folder or a bundle. Signatures are reported as added, removed, or renamed if their hash is in both databases under a different name, along with the number of changes per family and category.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		c := commandToConfigString(*cmd)
+		log := logger.With().Str("component", c).Logger()
+
+		format := viper.GetString(c + ".format")
+		if format != "text" && format != "json" {
+			log.Fatal().Msgf("Unsupported format: %s", format)
+		}
+
+		oldItems, err := db.LoadItems(args[0])
+		if err != nil {
+			log.Fatal().Err(err).Msgf("Error loading %s", args[0])
+		}
+		newItems, err := db.LoadItems(args[1])
+		if err != nil {
+			log.Fatal().Err(err).Msgf("Error loading %s", args[1])
+		}
+		diff := db.DiffItems(oldItems, newItems)
+
+		out := cmd.OutOrStdout()
+		if format == "json" {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(diff); err != nil {
+				log.Fatal().Err(err).Msg("Error writing diff")
+			}
+			return
+		}
+		printDiff(out, diff)
+	},
+}
+
+// printDiff writes diff in a human readable form to w.
+func printDiff(w io.Writer, diff *db.Diff) {
+	fmt.Fprintf(w, "Signatures: %d -> %d\n", diff.OldCount, diff.NewCount)
+	fmt.Fprintf(w, "Added: %d, removed: %d, renamed: %d\n", len(diff.Added), len(diff.Removed), len(diff.Renamed))
+
+	printDiffCounts(w, "Family", diff.ByFamily)
+	printDiffCounts(w, "Category", diff.ByCategory)
+
+	if len(diff.Added) > 0 {
+		fmt.Fprintln(w, "\nAdded:")
+		for _, item := range diff.Added {
+			fmt.Fprintf(w, "  + %s %s\n", item.Hash, item.MalwareName)
+		}
+	}
+	if len(diff.Removed) > 0 {
+		fmt.Fprintln(w, "\nRemoved:")
+		for _, item := range diff.Removed {
+			fmt.Fprintf(w, "  - %s %s\n", item.Hash, item.MalwareName)
+		}
+	}
+	if len(diff.Renamed) > 0 {
+		fmt.Fprintln(w, "\nRenamed:")
+		for _, r := range diff.Renamed {
+			fmt.Fprintf(w, "  ~ %s %s -> %s\n", r.Hash, r.OldName, r.NewName)
+		}
+	}
+}
+
+// printDiffCounts writes a table of counts, sorted by the total number of
+// changes.
+func printDiffCounts(w io.Writer, title string, counts map[string]db.DiffCounts) {
+	if len(counts) == 0 {
+		return
+	}
+	total := func(c db.DiffCounts) int { return c.Added + c.Removed + c.Renamed }
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if total(counts[keys[i]]) != total(counts[keys[j]]) {
+			return total(counts[keys[i]]) > total(counts[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	fmt.Fprintf(w, "\n%-40s %8s %8s %8s\n", title, "Added", "Removed", "Renamed")
+	for _, k := range keys {
+		c := counts[k]
+		fmt.Fprintf(w, "%-40s %8d %8d %8d\n", k, c.Added, c.Removed, c.Renamed)
+	}
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/hexahigh/goava/lib/db"
+	"github.com/hexahigh/goava/lib/fuzzy"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	fuzzyCmd.Flags().String("name", "", "Malware name of the signatures. Defaults to the name of each sample")
+	fuzzyCmd.Flags().String("comment", "", "Comment of the signatures")
+
+	dbCmd.AddCommand(fuzzyCmd)
+
+	configBindFlags(*fuzzyCmd)
+}
+
+var fuzzyCmd = &cobra.Command{
+	Use:   "fuzzy file...",
+	Short: "Print fuzzy signatures of samples",
+	Long: `Print fuzzy signatures of samples.
+
+Computes the ssdeep and TLSH hashes of each sample and prints them as lines of a Goava fuzzy signature file. Save the output in a .fuzzy file in a database folder to report files similar to the samples. TLSH hashes are left out for samples too short or too uniform to be hashed.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c := commandToConfigString(*cmd)
+		log := logger.With().Str("component", c).Logger()
+
+		for _, path := range args {
+			file, err := os.Open(path)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Error opening sample")
+			}
+			h := fuzzy.New()
+			_, err = io.Copy(h, file)
+			file.Close()
+			if err != nil {
+				log.Fatal().Err(err).Msg("Error hashing sample")
+			}
+
+			name := viper.GetString(c + ".name")
+			if name == "" {
+				name = filepath.Base(path)
+			}
+			comment := viper.GetString(c + ".comment")
+
+			hashes := map[string]string{db.HashTypeSSDeep: h.SSDeep(), db.HashTypeTLSH: h.TLSH()}
+			for _, hashType := range []string{db.HashTypeSSDeep, db.HashTypeTLSH} {
+				if hashes[hashType] == "" {
+					continue
+				}
+				line, err := db.FuzzyLine(hashType, hashes[hashType], name, comment)
+				if err != nil {
+					log.Fatal().Err(err).Msg("Error formatting signature")
+				}
+				fmt.Println(line)
+			}
+		}
+	},
+}
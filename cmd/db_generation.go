@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"strconv"
+
+	"github.com/hexahigh/goava/lib/db"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	historyCmd.Flags().StringP("database", "d", "", "Path to folder containing database files. Defaults to the last scan database")
+	rollbackCmd.Flags().StringP("database", "d", "", "Path to folder containing database files. Defaults to the last scan database")
+
+	dbCmd.AddCommand(historyCmd)
+	dbCmd.AddCommand(rollbackCmd)
+
+	configBindFlags(*historyCmd)
+	configBindFlags(*rollbackCmd)
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List installed database generations",
+	Long:  `List installed database generations`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := commandToConfigString(*cmd)
+		log := logger.With().Str("component", c).Logger()
+
+		path := databasePath(c)
+		if path == "" {
+			log.Fatal().Msg("No database folder configured")
+		}
+
+		gens, err := db.Generations(path)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error listing generations")
+		}
+		if len(gens) == 0 {
+			log.Info().Msgf("%s does not have any generations", path)
+			return
+		}
+
+		for _, gen := range gens {
+			event := log.Info().
+				Int("generation", gen.Number).
+				Bool("active", gen.Active).
+				Time("installed", gen.Installed)
+			if gen.Manifest != nil {
+				event = event.Int64("version", gen.Manifest.Version).Int("files", len(gen.Manifest.Files))
+			}
+			marker := ""
+			if gen.Active {
+				marker = " (active)"
+			}
+			event.Msgf("Generation %d%s", gen.Number, marker)
+		}
+	},
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [generation]",
+	Short: "Activate an older database generation",
+	Long: `Activate an older database generation.
+
+If no generation is given, the generation installed before the active one is activated.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c := commandToConfigString(*cmd)
+		log := logger.With().Str("component", c).Logger()
+
+		path := databasePath(c)
+		if path == "" {
+			log.Fatal().Msg("No database folder configured")
+		}
+
+		var target int
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n <= 0 {
+				log.Fatal().Msgf("Invalid generation %q", args[0])
+			}
+			target = n
+		}
+
+		gen, err := db.Rollback(path, target)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error rolling back")
+		}
+		log.Info().Msgf("Activated database generation %d", gen)
+	},
+}
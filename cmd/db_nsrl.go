@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/hexahigh/goava/lib/db"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	nsrlCmd.Flags().StringP("database", "d", "", "Path to folder the known-good index is written to. Defaults to the last scan database")
+	nsrlCmd.Flags().String("name", "nsrl", "Name of the known-good index. Importing again under the same name replaces it")
+
+	dbCmd.AddCommand(nsrlCmd)
+
+	configBindFlags(*nsrlCmd)
+}
+
+var nsrlCmd = &cobra.Command{
+	Use:   "nsrl file...",
+	Short: "Import NSRL known-good hashes",
+	Long: `Import NSRL known-good hashes.
+
+Builds a known-good index from NIST NSRL Reference Data Sets, either legacy NSRLFile.txt files or RDSv3 SQLite databases. Files whose hash is in a known-good index are reported as known clean by scan.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c := commandToConfigString(*cmd)
+		log := logger.With().Str("component", c).Logger()
+
+		path := databasePath(c)
+		if path == "" {
+			log.Fatal().Msg("No database folder configured")
+		}
+
+		dst := filepath.Join(path, viper.GetString(c+".name")+db.KnownSuffix)
+		log.Info().Msgf("Importing %d files into %s, this may take a while", len(args), dst)
+		count, err := db.ImportNSRL(dst, args...)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error importing NSRL data")
+		}
+		log.Info().Msgf("Imported %d known-good hashes into %s", count, dst)
+	},
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/hexahigh/goava/lib/db"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	signCmd.Flags().StringP("database", "d", "", "Path to folder containing database files. Defaults to the last scan database")
+	signCmd.Flags().StringP("key", "k", "", "Path to the PEM encoded ed25519 private key")
+	signCmd.Flags().Int64("version", 0, "Version of the manifest. Defaults to the version in the existing manifest, or the current unix time")
+
+	dbCmd.AddCommand(keygenCmd)
+	dbCmd.AddCommand(signCmd)
+
+	configBindFlags(*signCmd)
+}
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen name",
+	Short: "Generate a key pair for signing database manifests",
+	Long: `Generate a key pair for signing database manifests.
+
+The private key is written to name.key and the public key to name.pub.
+The public key is used as the manifest-key on hosts that verify the database.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c := commandToConfigString(*cmd)
+		log := logger.With().Str("component", c).Logger()
+
+		public, private, err := db.GenerateKey()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error generating key")
+		}
+		if err := os.WriteFile(args[0]+".key", private, 0600); err != nil {
+			log.Fatal().Err(err).Msg("Error writing private key")
+		}
+		if err := os.WriteFile(args[0]+".pub", public, 0644); err != nil {
+			log.Fatal().Err(err).Msg("Error writing public key")
+		}
+		log.Info().Msgf("Wrote %s.key and %s.pub", args[0], args[0])
+	},
+}
+
+var signCmd = &cobra.Command{
+	Use:   "sign",
+	Short: "Write and sign the manifest of the database",
+	Long: `Write and sign the manifest of the database.
+
+The manifest records the SHA-256 checksum of every signature file and known-good index and is checked before signatures are loaded. If the database uses generations, the manifest of the active generation is signed, as well as one at the top of the database folder for the known-good indexes.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := commandToConfigString(*cmd)
+		log := logger.With().Str("component", c).Logger()
+
+		root := databasePath(c)
+		if root == "" {
+			log.Fatal().Msg("No database folder configured")
+		}
+		// If the database uses generations, its known-good indexes are kept
+		// outside of them and covered by a manifest of their own
+		paths := []string{db.ActivePath(root)}
+		if paths[0] != root {
+			paths = append(paths, root)
+		}
+
+		keyData, err := os.ReadFile(viper.GetString(c + ".key"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error reading private key")
+		}
+		key, err := db.ParsePrivateKey(keyData)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error parsing private key")
+		}
+
+		for _, path := range paths {
+			version := viper.GetInt64(c + ".version")
+			if version == 0 {
+				if existing, err := db.ReadManifest(path); err == nil {
+					version = existing.Version
+				} else {
+					version = time.Now().Unix()
+				}
+			}
+
+			manifest, err := db.BuildManifest(path, version)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Error building manifest")
+			}
+			if err := manifest.Write(path); err != nil {
+				log.Fatal().Err(err).Msg("Error writing manifest")
+			}
+			if err := db.SignManifest(path, key); err != nil {
+				log.Fatal().Err(err).Msg("Error signing manifest")
+			}
+			log.Info().Msgf("Signed manifest of %s with %d files, version %d", path, len(manifest.Files), version)
+		}
+	},
+}
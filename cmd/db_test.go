@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// Commands working on a single database folder default to the last folder
+// given to scan, which has the highest precedence.
+func TestDatabasePath(t *testing.T) {
+	defer func(old []string) { viper.Set("scan.database", old) }(viper.GetStringSlice("scan.database"))
+	tests := []struct {
+		name     string
+		database string
+		scan     []string
+		want     string
+	}{
+		{"none", "", nil, ""},
+		{"own flag", "/db/own", []string{"/db/vendor"}, "/db/own"},
+		{"scan folder", "", []string{"/db/vendor"}, "/db/vendor"},
+		{"last scan folder", "", []string{"/db/vendor", "/db/local"}, "/db/local"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("test.database", tt.database)
+			viper.Set("scan.database", tt.scan)
+			if got := databasePath("test"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
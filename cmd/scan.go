@@ -1,16 +1,21 @@
 package cmd
 
 import (
-	"crypto/md5"
-	"encoding/hex"
-	"io"
-	"io/fs"
+	"context"
+	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/hexahigh/goava/lib/db"
+	"github.com/hexahigh/goava/lib/scanner"
+	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
@@ -18,242 +23,448 @@ import (
 )
 
 func init() {
-	scanCmd.Flags().StringP("database", "d", "", "Path to folder containing database files")
+	scanCmd.Flags().StringSliceP("database", "d", []string{}, "Paths to folders containing database files. Can be given several times, if a hash is in more than one folder the last one wins. Defaults to the folders found in /var/lib/clamav and config-dir/db")
 	scanCmd.Flags().BoolP("recursive", "r", false, "Scan recursively")
+	scanCmd.Flags().IntP("jobs", "j", runtime.NumCPU(), "Number of files scanned concurrently")
+	scanCmd.Flags().Bool("archives", true, "Scan the members of archives, compressed files, .deb, .rpm, .apk and .jar packages and ISO and FAT disk images, detected by their content")
+	scanCmd.Flags().Bool("image", false, "Scan the paths as container images: archives written by docker save, or OCI image layouts")
+	scanCmd.Flags().Bool("image-layers", false, "Scan every layer of container images separately, including files deleted or replaced by later layers, rather than their final filesystem")
+	scanCmd.Flags().Bool("tar", false, "Scan the paths as tar archives, which may be compressed, streaming their members rather than spooling them. - reads the archive from standard input")
+	scanCmd.Flags().Bool("git", false, "Scan the paths as Git repositories: every file in the history reachable from their refs, read from the object store")
+	scanCmd.Flags().Int("max-depth", 16, "Maximum nesting depth of archives. 0 disables the limit")
+	scanCmd.Flags().String("max-scan-size", "400MiB", "Maximum number of bytes extracted from a single file, counting nested archives. 0 disables the limit")
+	scanCmd.Flags().String("max-filesize", "100MiB", "Maximum size of a single archive member. 0 disables the limit")
+	scanCmd.Flags().Int("max-files", 10000, "Maximum number of members extracted from a single file, counting nested archives. 0 disables the limit")
+	scanCmd.Flags().Int("max-ratio", 250, "Maximum compression ratio of archive members larger than 1 MiB. 0 disables the limit")
+	scanCmd.Flags().String("limits-exceeded", "report", "What to do with archives that exceed the limits. report reports them, infected reports them and counts them as infected")
 	scanCmd.Flags().Bool("skip-size", false, "Skip size check, can increase speed at the cost of having to read every file")
 	scanCmd.Flags().Bool("no-summary", false, "Don't print summary")
 	scanCmd.Flags().Bool("full-path", false, "Print full path of scanned files")
 	scanCmd.Flags().BoolP("use-bloom", "b", true, "Use a bloom filter to speed up scanning")
-	scanCmd.Flags().Float64("bloom-fpr", 0.001, "False positive rate for bloom filter. Lower values increase accuracy and ram usage")
+	scanCmd.Flags().Float64("bloom-fpr", 0.001, "False positive rate for the pre-filter. Lower values increase accuracy and ram usage")
+	scanCmd.Flags().String("filter", "bloom", "Type of pre-filter used to speed up hash lookups. Supported values are: bloom, fuse (binary fuse filter, smaller and faster but with fixed false positive rates of 1/256 or 1/65536), none")
+	scanCmd.Flags().String("max-filter-memory", "", "Maximum memory of the pre-filter, e.g. 64MB. If the configured false positive rate needs more, a less accurate filter is built. Unlimited if empty")
 	scanCmd.Flags().BoolP("indexes", "i", false, "Create indexes on database")
 	scanCmd.Flags().BoolP("infected", "I", false, "Only print infected files, will still print summary")
 	scanCmd.Flags().BoolP("symlinks", "s", false, "Resolve symbolic links")
 	scanCmd.Flags().BoolP("db-log", "L", true, "Enable logs from the database handler")
+	scanCmd.Flags().Int("load-workers", 0, "Number of signature files loaded concurrently. 0 uses one per CPU")
+	scanCmd.Flags().Bool("watch-db", false, "Reload signatures while scanning when the database folder changes. Signatures can always be reloaded by sending SIGHUP")
+	scanCmd.Flags().StringSlice("include-name", []string{}, "Only load signatures whose name matches one of these patterns. Patterns are globs, or regular expressions if prefixed with re:")
+	scanCmd.Flags().StringSlice("exclude-name", []string{}, "Don't load signatures whose name matches one of these patterns")
+	scanCmd.Flags().StringSlice("include-source", []string{}, "Only load signatures from database files matching one of these patterns, relative to the database folder")
+	scanCmd.Flags().StringSlice("exclude-source", []string{}, "Don't load signatures from database files matching one of these patterns")
+	scanCmd.Flags().StringSlice("include-category", []string{}, "Only load signatures of these categories, e.g. Trojan")
+	scanCmd.Flags().StringSlice("exclude-category", []string{}, "Don't load signatures of these categories")
+	scanCmd.Flags().String("unknown-size", "intel", "What to do with signatures of unknown size, such as most threat-intel hashes. ignore skips them, load loads them but disables the size check, intel loads the ones of threat-intel feeds and skips the others")
+	scanCmd.Flags().Int("ssdeep-threshold", 80, "Minimum ssdeep score, from 0 to 100, for a file to be reported as similar to a fuzzy signature")
+	scanCmd.Flags().Int("tlsh-threshold", 50, "Maximum TLSH distance for a file to be reported as similar to a fuzzy signature")
+	scanCmd.Flags().String("manifest", "off", "Verify the database manifest before loading. Supported values are: off, warn, enforce")
+	scanCmd.Flags().String("manifest-key", "", "Path to the ed25519 public key the database manifest must be signed with")
 
 	rootCmd.AddCommand(scanCmd)
 
 	configBindFlags(*scanCmd)
 }
 
+// unknownSizeActions maps the values of the unknown-size flag to
+// db.DB.UnknownSizeAction
+var unknownSizeActions = map[string]int{
+	"ignore": 0,
+	"load":   1,
+	"intel":  2,
+}
+
+// preFilters maps the values of the filter flag, other than none, to
+// db.DB.PreFilter
+var preFilters = map[string]int{
+	"bloom": db.PreFilterBloom,
+	"fuse":  db.PreFilterFuse,
+}
+
+// limitsExceededInfected maps the values of the limits-exceeded flag to
+// scanner.Options.LimitsExceededInfected
+var limitsExceededInfected = map[string]bool{
+	"report":   false,
+	"infected": true,
+}
+
+// manifestActions maps the values of the manifest flag to db.DB.ManifestAction
+var manifestActions = map[string]int{
+	"off":     0,
+	"warn":    1,
+	"enforce": 2,
+}
+
+// Exit codes of the scan command, like those of clamscan
+const (
+	exitClean    = 0
+	exitInfected = 1
+	exitError    = 2
+)
+
 var scanCmd = &cobra.Command{
 	Use:   "scan path...",
 	Short: "Scan for viruses",
-	Long:  `Scan for viruses`,
-	Args:  cobra.MinimumNArgs(1),
+	Long: `Scan for viruses. A path of - scans standard input.
+
+The exit code is 0 if no virus was found, 1 if one was, and 2 if some files
+couldn't be scanned.`,
+	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		c := commandToConfigString(*cmd)
 		log := logger.With().Str("component", c).Logger()
 
 		startTime := time.Now()
 
-		var stats struct {
-			ScannedFiles   int
-			ScannedFolders int
-			InfectedFiles  int
-			DataScanned    uint64
-			DataRead       uint64
+		// Deferred first so it runs last, once everything is cleaned up
+		exitCode := exitClean
+		defer func() {
+			if exitCode != exitClean {
+				os.Exit(exitCode)
+			}
+		}()
+
+		// fatal logs a configuration or startup error and exits. Unlike
+		// log.Fatal, which exits with 1, it exits with exitError, as 1 means a
+		// virus was found
+		fatal := func(err error, format string, args ...any) {
+			log.WithLevel(zerolog.FatalLevel).Err(err).Msgf(format, args...)
+			os.Exit(exitError)
 		}
 
-		var database = &db.DB{
-			Path:                   viper.GetString(c + ".database"),
-			UseBloom:               viper.GetBool(c + ".use-bloom"),
-			BloomFalsePositiveRate: viper.GetFloat64(c + ".bloom-fpr"),
-			CreateIndexes:          viper.GetBool(c + ".indexes"),
-			Log:                    viper.GetBool(c + ".db-log"),
-			Logger:                 *stdlog.New(log, "", 0),
+		manifestAction, ok := manifestActions[viper.GetString(c+".manifest")]
+		if !ok {
+			fatal(nil, "Unsupported manifest mode: %s", viper.GetString(c+".manifest"))
 		}
-
-		//* Functions
-
-		scanFile := func(path string) {
-			var err error
-			if viper.GetBool(c + ".full-path") {
-				path, _ = filepath.Abs(path)
-			}
-			//* Resolve symlinks if enabled
-			if viper.GetBool(c + ".symlinks") {
-				path, err = filepath.EvalSymlinks(path)
-				if err != nil {
-					log.Error().Err(err).Msg("Error resolving symlink")
-					return
-				}
+		unknownSizeAction, ok := unknownSizeActions[viper.GetString(c+".unknown-size")]
+		if !ok {
+			fatal(nil, "Unsupported unknown-size mode: %s", viper.GetString(c+".unknown-size"))
+		}
+		countsInfected, ok := limitsExceededInfected[viper.GetString(c+".limits-exceeded")]
+		if !ok {
+			fatal(nil, "Unsupported limits-exceeded mode: %s", viper.GetString(c+".limits-exceeded"))
+		}
+		// Limits of 0 are disabled rather than defaulted, as the defaults are
+		// those of the flags
+		limit := func(n int64) int64 {
+			if n == 0 {
+				return -1
 			}
-
-			file, err := os.OpenFile(path, os.O_RDONLY, 0644)
+			return n
+		}
+		sizeLimit := func(key string) int64 {
+			n, err := humanize.ParseBytes(viper.GetString(c + "." + key))
 			if err != nil {
-				log.Error().Err(err).Msg("Error opening file")
-				return
+				fatal(err, "Invalid %s", key)
 			}
-			defer file.Close()
+			return limit(int64(n))
+		}
+		limits := scanner.Limits{
+			MaxDepth:      int(limit(viper.GetInt64(c + ".max-depth"))),
+			MaxTotalSize:  sizeLimit("max-scan-size"),
+			MaxMemberSize: sizeLimit("max-filesize"),
+			MaxMembers:    int(limit(viper.GetInt64(c + ".max-files"))),
+			MaxRatio:      int(limit(viper.GetInt64(c + ".max-ratio"))),
+		}
 
-			stat, err := file.Stat()
+		filterType := viper.GetString(c + ".filter")
+		preFilter, ok := preFilters[filterType]
+		if !ok && filterType != "none" {
+			fatal(nil, "Unsupported filter type: %s", filterType)
+		}
+		var maxFilterMemory uint64
+		if s := viper.GetString(c + ".max-filter-memory"); s != "" {
+			var err error
+			maxFilterMemory, err = humanize.ParseBytes(s)
 			if err != nil {
-				log.Error().Err(err).Msg("Error getting file stat")
-				return
-			}
-			filesize := stat.Size()
-
-			if stat.IsDir() {
-				log.Error().Msgf("%s is a directory, this shouldn't happen, skipping", path)
-				return
-			}
-
-			stats.ScannedFiles++
-			stats.DataScanned += uint64(filesize)
-
-			//* Symlink was not resolved so we skip it
-			if stat.Mode()&os.ModeSymlink != 0 {
-				log.Info().Msgf("%s is a symlink, skipping", path)
-				return
-			}
-
-			if stat.Mode()&os.ModeDevice != 0 {
-				log.Info().Msgf("%s is a device, skipping", path)
-				return
+				fatal(err, "Invalid maximum filter memory")
 			}
+		}
+		key, err := trustedKey(c)
+		if err != nil {
+			fatal(err, "Error loading manifest key")
+		}
 
-			if stat.Mode()&os.ModeNamedPipe != 0 {
-				log.Info().Msgf("%s is a pipe, skipping", path)
-				return
+		paths := viper.GetStringSlice(c + ".database")
+		if len(paths) == 0 {
+			paths = db.Discover(filepath.Join(viper.GetString("config-dir"), "db"))
+			if len(paths) == 0 {
+				fatal(nil, "No database folder configured or found")
 			}
+			log.Info().Msgf("Using database folders: %s", strings.Join(paths, ", "))
+		}
 
-			if stat.Mode()&os.ModeSocket != 0 {
-				log.Info().Msgf("%s is a socket, skipping", path)
-				return
-			}
+		var database = &db.DB{
+			Paths:                  paths,
+			UseBloom:               viper.GetBool(c+".use-bloom") && filterType != "none",
+			PreFilter:              preFilter,
+			MaxFilterMemory:        maxFilterMemory,
+			BloomFalsePositiveRate: viper.GetFloat64(c + ".bloom-fpr"),
+			CreateIndexes:          viper.GetBool(c + ".indexes"),
+			LoadWorkers:            viper.GetInt(c + ".load-workers"),
+			Log:                    viper.GetBool(c + ".db-log"),
+			Logger:                 *stdlog.New(log, "", 0),
+			UnknownSizeAction:      unknownSizeAction,
+			ManifestAction:         manifestAction,
+			TrustedKey:             key,
+			Filter: db.Filter{
+				IncludeNames:      viper.GetStringSlice(c + ".include-name"),
+				ExcludeNames:      viper.GetStringSlice(c + ".exclude-name"),
+				IncludeSources:    viper.GetStringSlice(c + ".include-source"),
+				ExcludeSources:    viper.GetStringSlice(c + ".exclude-source"),
+				IncludeCategories: viper.GetStringSlice(c + ".include-category"),
+				ExcludeCategories: viper.GetStringSlice(c + ".exclude-category"),
+			},
+		}
 
-			if filesize == 0 {
-				if !viper.GetBool(c + ".infected") {
-					log.Info().Msgf("No viruses found in %s", path)
-				}
-				return
-			}
+		if err := database.Init(); err != nil {
+			fatal(err, "Error initializing database")
+		}
+		if err := database.LoadAll(); err != nil {
+			fatal(err, "Error loading signatures")
+		}
 
-			if !viper.GetBool(c + ".skip-size") {
-				// Check if size matches
-				sizeExists, err := database.HasSigWithSize(int(filesize))
-				if err != nil {
-					log.Error().Err(err).Msg("Error checking if size exists")
+		//* Reload signatures on SIGHUP, and when the database changes if enabled
+		ctx, stop := context.WithCancel(context.Background())
+		defer stop()
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
 					return
-				}
-				if !sizeExists {
-					if !viper.GetBool(c + ".infected") {
-						log.Info().Msgf("No viruses found in %s", path)
+				case <-hup:
+					if err := database.Reload(); err != nil {
+						log.Error().Err(err).Msg("Error reloading signatures")
 					}
-					return
 				}
 			}
-
-			// Hash file
-			md5 := md5.New()
-			written, err := io.Copy(md5, file)
-			if err != nil {
-				log.Error().Err(err).Msg("Error hashing file")
-				return
-			}
-
-			stats.DataRead += uint64(written)
-
-			hash := hex.EncodeToString(md5.Sum(nil))
-
-			hashExists, err := database.HasSigWithHash(hash)
-			if err != nil {
-				logger.Error().Err(err).Msg("Error checking if hash exists")
-				// log.Errorf("Error checking if hash exists: %v", err)
-				return
-			}
-
-			if !hashExists {
-				if !viper.GetBool(c + ".infected") {
-					log.Info().Msgf("No viruses found in %s", path)
+		}()
+		if viper.GetBool(c + ".watch-db") {
+			go func() {
+				if err := database.Watch(ctx); err != nil {
+					log.Error().Err(err).Msg("Error watching database")
 				}
-				return
-			} else {
-				stats.InfectedFiles++
-				log.Warn().Msgf("Virus found in %s", path)
-			}
+			}()
 		}
 
-		scanDir := func(path string) error {
-			return filepath.Walk(path, func(path string, info fs.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-
-				// Check if the path is a symlink
-				if info.Mode()&os.ModeSymlink != 0 {
-					if !viper.GetBool(c + ".symlinks") {
-						if !viper.GetBool(c + ".infected") {
-							log.Info().Msgf("%s is a symlink, skipping", path)
-						}
-						return nil
-					}
-					// If it's a symlink, resolve it
-					realPath, err := filepath.EvalSymlinks(path)
-					if err != nil {
-						log.Warn().Msgf("Failed to resolve symlink %s: %v", path, err)
-						return nil
-					}
-
-					// Get the actual file info of the resolved path
-					stat, err := os.Stat(realPath)
-					if err != nil {
-						log.Warn().Msgf("Failed to stat resolved path %s: %v", realPath, err)
-						return nil
-					}
+		fileScanner, err := scanner.New(scanner.Options{
+			DB:                     database,
+			SkipSize:               viper.GetBool(c + ".skip-size"),
+			FollowSymlinks:         viper.GetBool(c + ".symlinks"),
+			Recursive:              viper.GetBool(c + ".recursive"),
+			ScanArchives:           viper.GetBool(c + ".archives"),
+			ScanImageLayers:        viper.GetBool(c + ".image-layers"),
+			Limits:                 limits,
+			LimitsExceededInfected: countsInfected,
+			Jobs:                   viper.GetInt(c + ".jobs"),
+			SSDeepThreshold:        viper.GetInt(c + ".ssdeep-threshold"),
+			TLSHThreshold:          viper.GetInt(c + ".tlsh-threshold"),
+		})
+		if err != nil {
+			fatal(err, "Error creating scanner")
+		}
 
-					// Update the info variable with the resolved path's information
-					info = stat
-				}
+		//* Functions
 
-				if !info.IsDir() {
-					scanFile(path)
-				} else {
-					stats.ScannedFolders++
+		// report logs the result of scanning a file, and those of its
+		// members if it is an archive, and updates the exit code
+		report := func(res scanner.ScanResult) {
+			res.Walk(func(res *scanner.ScanResult) {
+				reportResult(log, res, viper.GetBool(c+".infected"))
+				switch {
+				case fileScanner.Infected(res):
+					exitCode = exitInfected
+				case res.Verdict == scanner.Error && exitCode == exitClean:
+					exitCode = exitError
 				}
-
-				return nil
 			})
 		}
 
 		//* End functions
 
-		if err := database.Init(); err != nil {
-			log.Panic().Err(err).Msg("Error initializing database")
-		}
-		if err := database.LoadAll(); err != nil {
-			log.Panic().Err(err).Msg("Error loading signatures")
-		}
-
 		for _, path := range args {
-			// Check if path is a directory
-			if info, err := os.Stat(path); err == nil && info.IsDir() {
-				if viper.GetBool(c + ".recursive") {
-					err := scanDir(path)
-					if err != nil {
-						log.Error().Err(err).Msg("Error walking path")
-					}
+			if path == "-" {
+				if viper.GetBool(c + ".tar") {
+					report(fileScanner.ScanTar(ctx, os.Stdin, "stdin"))
 				} else {
-					log.Info().Msgf("%s is a directory, ignoring", path)
+					report(fileScanner.ScanReader(ctx, os.Stdin, "stdin"))
 				}
-			} else {
-				scanFile(path)
+				continue
 			}
+			if viper.GetBool(c + ".full-path") {
+				path, _ = filepath.Abs(path)
+			}
+			switch {
+			case viper.GetBool(c + ".tar"):
+				report(scanTarFile(ctx, fileScanner, path))
+				continue
+			case viper.GetBool(c + ".image"):
+				report(fileScanner.ScanImage(ctx, path))
+				continue
+			case viper.GetBool(c + ".git"):
+				report(fileScanner.ScanGit(ctx, path))
+				continue
+			}
+			fileScanner.ScanPath(ctx, path, report)
 		}
 
 		endTime := time.Now()
 
 		HDBStats := database.GetHDBStats()
+		summary := fileScanner.Stats()
 
 		if !viper.GetBool(c + ".no-summary") {
+			if viper.GetString("output") == "json" {
+				log.Info().
+					Interface("database", HDBStats).
+					Interface("scan", summary).
+					Dur("duration", endTime.Sub(startTime)).
+					Msg("Scan summary")
+				return
+			}
 			log.Info().Msg("----------- SCAN SUMMARY -----------")
 			log.Info().Msgf("Known viruses: %d", HDBStats.Count)
-			log.Info().Msgf("Scanned files: %d", stats.ScannedFiles)
-			log.Info().Msgf("Scanned folders: %d", stats.ScannedFolders)
-			log.Info().Msgf("Infected files: %d", stats.InfectedFiles)
-			log.Info().Msgf("Data scanned: %s", humanize.Bytes(stats.DataScanned))
-			log.Info().Msgf("Data read: %s", humanize.Bytes(stats.DataRead))
+			if HDBStats.FuzzyCount > 0 {
+				log.Info().Msgf("Fuzzy signatures: %d", HDBStats.FuzzyCount)
+			}
+			for _, root := range database.Roots() {
+				if gen := HDBStats.Generations[root]; gen > 0 {
+					log.Info().Msgf("Database generation of %s: %d", root, gen)
+				}
+			}
+			log.Info().Msgf("Signatures by hash type: %s", formatCounts(HDBStats.ByHashType))
+			if len(database.Roots()) > 1 {
+				log.Info().Msgf("Signatures by database folder: %s", formatCounts(HDBStats.ByRoot))
+			}
+			log.Info().Msgf("Signatures by source: %s", formatCounts(HDBStats.BySource))
+			log.Info().Msgf("Signatures by category: %s", formatCounts(HDBStats.ByCategory))
+			log.Info().Msgf("Signatures with unknown size: %d", HDBStats.WildcardSizes)
+			log.Info().Msgf("Duplicate signatures: %d", HDBStats.Duplicates)
+			log.Info().Msgf("Ignored signatures: %d", HDBStats.Ignored)
+			if !database.Filter.IsZero() {
+				log.Info().Msgf("Filtered signatures: %d, filtered files: %d", HDBStats.Filtered, HDBStats.FilteredFiles)
+			}
+			if HDBStats.KnownGood > 0 {
+				log.Info().Msgf("Known-good hashes: %d", HDBStats.KnownGood)
+			}
+			if HDBStats.FilterType != "" {
+				log.Info().Msgf("Pre-filter: %s, %s, false positive rate %g configured, %.3g estimated", HDBStats.FilterType, humanize.Bytes(HDBStats.FilterSize), HDBStats.FilterFalsePositiveRate, HDBStats.FilterEstimatedFalsePositiveRate)
+			} else {
+				log.Info().Msg("Pre-filter: none")
+			}
+			log.Info().Msgf("Database load time: %s, sort time: %s", HDBStats.LoadTime, HDBStats.SortTime)
+			log.Info().Msgf("Database memory: ~%s", humanize.Bytes(HDBStats.Memory))
+			log.Info().Msgf("Scanned files: %d", summary.ScannedFiles)
+			log.Info().Msgf("Scanned folders: %d", summary.ScannedFolders)
+			log.Info().Msgf("Infected files: %d", summary.InfectedFiles)
+			log.Info().Msgf("Known clean files: %d", summary.KnownClean)
+			if summary.Archives > 0 {
+				log.Info().Msgf("Archives: %d, archive members: %d", summary.Archives, summary.ArchiveMembers)
+			}
+			if summary.LimitsExceeded > 0 {
+				log.Info().Msgf("Files exceeding scan limits: %d", summary.LimitsExceeded)
+			}
+			if HDBStats.FuzzyCount > 0 {
+				log.Info().Msgf("Similar files: %d", summary.SimilarFiles)
+			}
+			log.Info().Msgf("Data scanned: %s", humanize.Bytes(summary.DataScanned))
+			log.Info().Msgf("Data read: %s", humanize.Bytes(summary.DataRead))
 			log.Info().Msgf("Time: %s", endTime.Sub(startTime).String())
 		}
 	},
 }
+
+// scanTarFile scans the tar archive at path with ScanTar.
+func scanTarFile(ctx context.Context, s *scanner.Scanner, path string) scanner.ScanResult {
+	f, err := os.Open(path)
+	if err != nil {
+		return scanner.ScanResult{Path: path, Size: -1, Verdict: scanner.Error, Reason: "Error opening file", Err: err}
+	}
+	defer f.Close()
+	return s.ScanTar(ctx, f, path)
+}
+
+// reportResult logs the result of scanning a single file. If infectedOnly is
+// set, only infected and similar files and errors are logged.
+func reportResult(log zerolog.Logger, res *scanner.ScanResult, infectedOnly bool) {
+	switch res.Verdict {
+	case scanner.Infected:
+		event := log.Warn()
+		if len(res.Matches) > 0 {
+			event = event.Str("signature", res.Matches[0].MalwareName)
+		}
+		withOrigin(event, res).Msgf("Virus found in %s", res.Path)
+	case scanner.Similar:
+		match := res.Matches[0]
+		measure := "ssdeep score"
+		if match.HashType == db.HashTypeTLSH {
+			measure = "TLSH distance"
+		}
+		withOrigin(log.Warn(), res).
+			Str("signature", match.MalwareName).
+			Str("hash_type", match.HashType).
+			Int("score", res.Score).
+			Msgf("%s is similar to %s (%s %d)", res.Path, match.MalwareName, measure, res.Score)
+	case scanner.LimitsExceeded:
+		withOrigin(log.Warn(), res).Str("limit", res.Reason).Msgf("%s exceeds the scan limits: %s", res.Path, res.Reason)
+	case scanner.Error:
+		log.Error().Err(res.Err).Str("path", res.Path).Msg(res.Reason)
+	case scanner.KnownClean:
+		if !infectedOnly {
+			log.Info().Msgf("%s is known clean", res.Path)
+		}
+	case scanner.Skipped:
+		if !infectedOnly {
+			log.Info().Msgf("%s is a %s, skipping", res.Path, res.Reason)
+		}
+	default:
+		if !infectedOnly {
+			log.Info().Msgf("No viruses found in %s", res.Path)
+		}
+	}
+}
+
+// withOrigin adds the package, the image layer and the Git commits res
+// belongs to, if any, to event.
+func withOrigin(event *zerolog.Event, res *scanner.ScanResult) *zerolog.Event {
+	if res.Package != nil {
+		event = event.Str("package", res.Package.String()).Str("package_format", res.Package.Format)
+	}
+	if res.Layer != "" {
+		event = event.Str("layer", res.Layer)
+	}
+	if len(res.Git) > 0 {
+		commits := make([]string, len(res.Git))
+		for i, o := range res.Git {
+			commits[i] = o.String()
+		}
+		event = event.Strs("commits", commits)
+	}
+	return event
+}
+
+// formatCounts formats counts as "key: count" pairs, largest count first.
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s: %d", k, counts[k])
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
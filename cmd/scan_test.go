@@ -0,0 +1,23 @@
+package cmd
+
+import "testing"
+
+func TestFormatCounts(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts map[string]int
+		want   string
+	}{
+		{"empty", nil, "none"},
+		{"single", map[string]int{"md5": 3}, "md5: 3"},
+		{"largest first", map[string]int{"md5": 1, "sha256": 5, "sha1": 2}, "sha256: 5, sha1: 2, md5: 1"},
+		{"ties by name", map[string]int{"Trojan": 2, "Exploit": 2, "Unknown": 7}, "Unknown: 7, Exploit: 2, Trojan: 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatCounts(tt.counts); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
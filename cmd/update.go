@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/hexahigh/goava/lib/db"
+	"github.com/hexahigh/goava/lib/update"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	stdlog "log"
+)
+
+func init() {
+	updateCmd.Flags().StringP("database", "d", "", "Path to folder the database files are written to. Defaults to the last scan database")
+	updateCmd.Flags().StringSlice("mirrors", []string{}, "Base URLs of the mirrors to download from, in order of preference")
+	updateCmd.Flags().StringSlice("files", []string{"main.hdb", "daily.hdb"}, "Names of the database files to download")
+	updateCmd.Flags().Int("retries", 3, "How many times a failed download is retried before trying the next mirror")
+	updateCmd.Flags().Duration("backoff", 2*time.Second, "Delay before the first retry, doubled after every attempt")
+	updateCmd.Flags().Duration("timeout", 30*time.Second, "Timeout for a single HTTP request")
+	updateCmd.Flags().Int("generations", 3, "Number of database generations to keep. 0 keeps every generation")
+	updateCmd.Flags().Bool("migrate", false, "Convert a plain database folder with files in it to keep generations, moving its files into generations/1. Other programs writing to the folder, such as freshclam, will no longer be loaded from it")
+	updateCmd.Flags().String("manifest-key", "", "Path to the ed25519 public key the mirror manifest must be signed with")
+	updateCmd.Flags().BoolP("db-log", "L", true, "Enable logs from the updater")
+
+	rootCmd.AddCommand(updateCmd)
+
+	configBindFlags(*updateCmd)
+}
+
+var updateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Download signature databases from a mirror",
+	Long: `Download signature databases from one or more HTTP(S) mirrors into the database folder.
+
+Unchanged files are not downloaded again. Every file is verified before it replaces the old one.
+
+Updates are installed as numbered generations: the files of each update are kept in <database>/generations/<n>, and <database>/current links to the active one, which "goava db rollback" can change. A database folder that already contains files, such as one kept up to date by freshclam, is only converted to this layout with --migrate, which moves its files into generations/1.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := commandToConfigString(*cmd)
+		log := logger.With().Str("component", c).Logger()
+
+		key, err := trustedKey(c)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error loading manifest key")
+		}
+
+		updater := &update.Updater{
+			Mirrors:     viper.GetStringSlice(c + ".mirrors"),
+			Files:       viper.GetStringSlice(c + ".files"),
+			Path:        databasePath(c),
+			Generations: viper.GetInt(c + ".generations"),
+			Migrate:     viper.GetBool(c + ".migrate"),
+			TrustedKey:  key,
+			Client:      &http.Client{Timeout: viper.GetDuration(c + ".timeout")},
+			Retries:     viper.GetInt(c + ".retries"),
+			Backoff:     viper.GetDuration(c + ".backoff"),
+			Log:         viper.GetBool(c + ".db-log"),
+			Logger:      *stdlog.New(log, "", 0),
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		results, gen, err := updater.Update(ctx)
+		for _, res := range results {
+			switch {
+			case res.Err != nil:
+				log.Error().Err(res.Err).Msgf("Could not update %s", res.File)
+			case res.Updated:
+				log.Info().Msgf("Updated %s from %s (%s)", res.File, res.Mirror, humanize.Bytes(uint64(res.Size)))
+			default:
+				log.Info().Msgf("%s is up to date", res.File)
+			}
+		}
+		if gen > 0 {
+			log.Info().Msgf("Installed database generation %d", gen)
+		}
+		if errors.Is(err, db.ErrPlainFolder) {
+			log.Fatal().Err(err).Msg("Update failed, pass --migrate to convert the database folder")
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("Update failed")
+		}
+	},
+}
@@ -0,0 +1,333 @@
+package db
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// ErrOlderBundle is returned by ImportBundle if the bundle is older than the
+// installed database.
+var ErrOlderBundle = errors.New("bundle is older than the installed database")
+
+// ImportOptions configures ImportBundle.
+type ImportOptions struct {
+	// Import the bundle even if it is older than the active database
+	Force bool
+
+	// Number of database generations to keep. If 0, every generation is kept
+	Keep int
+
+	// If not nil, the bundle manifest must be signed with the matching
+	// private key
+	TrustedKey ed25519.PublicKey
+
+	// Convert a plain database directory with files in it to use
+	// generations, see MigrateToGenerations. Otherwise ErrPlainFolder is
+	// returned for such a directory
+	Migrate bool
+}
+
+// CreateBundle packs every signature file and top-level known-good index in
+// the active database of the database directory dir, together with a manifest containing their
+// checksums, into a gzip compressed tar archive written to w.
+//
+// If the existing manifest of the database matches its files, it is
+// included unchanged along with its signature. Otherwise a new, unsigned
+// manifest is created. If version is 0, the version of the existing manifest
+// is used, or the current unix time if dir has no manifest.
+func CreateBundle(dir string, w io.Writer, version int64) (*Manifest, error) {
+	dir = ActivePath(dir)
+	manifest, data, sig, err := bundleManifest(dir, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Files) == 0 {
+		return nil, fmt.Errorf("%s contains no signature files", dir)
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	// The manifest goes first so importers can read it before the files
+	if err := addBundleData(tw, ManifestFile, data, manifest.Created); err != nil {
+		return nil, err
+	}
+	if sig != nil {
+		if err := addBundleData(tw, ManifestSigFile, sig, manifest.Created); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(manifest.Files))
+	for name := range manifest.Files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err := addBundleFile(tw, dir, name); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// bundleManifest returns the manifest to include in a bundle of dir, its
+// encoding, and its signature if it has one.
+func bundleManifest(dir string, version int64) (*Manifest, []byte, []byte, error) {
+	existing, err := ReadManifest(dir)
+	if err != nil && !isNotExist(err) {
+		return nil, nil, nil, err
+	}
+	if existing != nil && (version == 0 || version == existing.Version) && existing.Verify(dir) == nil {
+		data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		sig, err := os.ReadFile(filepath.Join(dir, ManifestSigFile))
+		if err != nil && !isNotExist(err) {
+			return nil, nil, nil, err
+		}
+		return existing, data, sig, nil
+	}
+
+	if version == 0 {
+		if existing != nil {
+			version = existing.Version
+		} else {
+			version = time.Now().Unix()
+		}
+	}
+	manifest, err := BuildManifest(dir, version)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	data, err := manifest.Marshal()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return manifest, data, nil, nil
+}
+
+func addBundleData(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func addBundleFile(tw *tar.Writer, dir, name string) error {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// ImportBundle verifies the bundle read from r and installs it as a new
+// generation of the database directory dir. The number of the new generation
+// is returned.
+//
+// The bundle is extracted into a staging folder and every file is checked
+// against the checksums in the manifest, and with VerifySigFile or
+// OpenKnownIndex. Known-good indexes stay at the top of the new generation,
+// where they are covered by its manifest. Only then is
+// the new generation activated, so a failed import leaves the installed
+// database untouched.
+//
+// ErrOlderBundle is returned if the active database has a newer version than
+// the bundle, unless opts.Force is true.
+func ImportBundle(r io.Reader, dir string, opts ImportOptions) (*Manifest, int, error) {
+	if err := PrepareGenerations(dir, opts.Migrate); err != nil {
+		return nil, 0, err
+	}
+	staging, err := os.MkdirTemp(dir, ".staging-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.RemoveAll(staging)
+
+	manifest, data, sig, err := extractBundle(r, staging)
+	if err != nil {
+		return nil, 0, err
+	}
+	if opts.TrustedKey != nil {
+		if sig == nil {
+			return nil, 0, fmt.Errorf("invalid bundle: %w: %s is missing", ErrBadSignature, ManifestSigFile)
+		}
+		raw, err := decodeSignature(sig)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid bundle: %w", err)
+		}
+		if !ed25519.Verify(opts.TrustedKey, data, raw) {
+			return nil, 0, fmt.Errorf("invalid bundle: %w", ErrBadSignature)
+		}
+	}
+
+	if installed, err := ReadManifest(ActivePath(dir)); err == nil {
+		if manifest.Version < installed.Version && !opts.Force {
+			return nil, 0, fmt.Errorf("%w (bundle version %d, installed version %d)", ErrOlderBundle, manifest.Version, installed.Version)
+		}
+	} else if !isNotExist(err) {
+		return nil, 0, err
+	}
+
+	// The manifest is written exactly as it was signed
+	if err := writeFileAtomic(filepath.Join(staging, ManifestFile), data); err != nil {
+		return nil, 0, err
+	}
+	if sig != nil {
+		if err := writeFileAtomic(filepath.Join(staging, ManifestSigFile), sig); err != nil {
+			return nil, 0, err
+		}
+	}
+	gen, err := InstallGeneration(dir, staging, opts.Keep)
+	if err != nil {
+		return nil, 0, err
+	}
+	return manifest, gen, nil
+}
+
+// extractBundle extracts the bundle read from r into dir and verifies it.
+// The manifest, its encoding, and its signature if the bundle has one are
+// returned.
+func extractBundle(r io.Reader, dir string) (*Manifest, []byte, []byte, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+
+	var manifest *Manifest
+	var data, sig []byte
+	sums := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid bundle: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, nil, nil, fmt.Errorf("invalid bundle: %s is not a regular file", hdr.Name)
+		}
+
+		switch hdr.Name {
+		case ManifestFile:
+			if data, err = io.ReadAll(tr); err != nil {
+				return nil, nil, nil, err
+			}
+			if manifest, err = parseManifest(data); err != nil {
+				return nil, nil, nil, err
+			}
+			continue
+		case ManifestSigFile:
+			if sig, err = io.ReadAll(io.LimitReader(tr, 1024)); err != nil {
+				return nil, nil, nil, err
+			}
+			continue
+		}
+
+		// Known-good indexes are only read at the top of the database
+		name := path.Clean(hdr.Name)
+		if !filepath.IsLocal(name) || !IsSigFile(name) && !(isKnownFile(name) && path.Dir(name) == ".") {
+			return nil, nil, nil, fmt.Errorf("invalid bundle: unexpected entry %s", hdr.Name)
+		}
+		if _, ok := sums[name]; ok {
+			return nil, nil, nil, fmt.Errorf("invalid bundle: duplicate entry %s", name)
+		}
+		sum, err := extractBundleFile(tr, filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		sums[name] = sum
+	}
+
+	if manifest == nil {
+		return nil, nil, nil, fmt.Errorf("invalid bundle: missing %s", ManifestFile)
+	}
+	if err := manifest.compare(sums); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	for name := range sums {
+		if err := verifyBundleFile(filepath.Join(dir, filepath.FromSlash(name)), name); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid bundle: %w", err)
+		}
+	}
+	return manifest, data, sig, nil
+}
+
+// verifyBundleFile checks the extracted bundle file at path, named name in
+// the bundle, with OpenKnownIndex if it is a known-good index and with
+// VerifySigFile otherwise.
+func verifyBundleFile(path, name string) error {
+	if !isKnownFile(name) {
+		return VerifySigFile(path, name)
+	}
+	k, err := OpenKnownIndex(path)
+	if err != nil {
+		return err
+	}
+	return k.Close()
+}
+
+func extractBundleFile(r io.Reader, dst string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,220 @@
+package db
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// bundleOf returns a bundle of a database with a single signature file,
+// with a manifest of the given version signed with key if it is not nil.
+func bundleOf(t *testing.T, version int64, key ed25519.PrivateKey) []byte {
+	t.Helper()
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "main.hdb"), teamHash+":68:Test.Team\n")
+	m, err := BuildManifest(src, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Write(src); err != nil {
+		t.Fatal(err)
+	}
+	if key != nil {
+		if err := SignManifest(src, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var bundle bytes.Buffer
+	if _, err := CreateBundle(src, &bundle, version); err != nil {
+		t.Fatal(err)
+	}
+	return bundle.Bytes()
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// rawBundle returns a bundle with the given entries in order, bypassing the
+// checks of CreateBundle.
+func rawBundle(t *testing.T, entries ...[2]string) []byte {
+	t.Helper()
+	var bundle bytes.Buffer
+	gw := gzip.NewWriter(&bundle)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: e[0], Mode: 0644, Size: int64(len(e[1])), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(e[1]))
+	}
+	tw.Close()
+	gw.Close()
+	return bundle.Bytes()
+}
+
+func TestImportBundle(t *testing.T) {
+	pub, priv := newKey(t)
+	other, _ := newKey(t)
+	tests := []struct {
+		name      string
+		installed int64 // version of the installed database, 0 for none
+		version   int64
+		sign      bool
+		opts      ImportOptions
+		wantErr   error
+		wantGen   int
+	}{
+		{"empty database", 0, 5, false, ImportOptions{}, nil, 1},
+		{"newer", 5, 6, false, ImportOptions{}, nil, 2},
+		{"same version", 5, 5, false, ImportOptions{}, nil, 2},
+		{"older", 5, 4, false, ImportOptions{}, ErrOlderBundle, 0},
+		{"older forced", 5, 4, false, ImportOptions{Force: true}, nil, 2},
+		{"signed", 0, 5, true, ImportOptions{TrustedKey: pub}, nil, 1},
+		{"signed without key", 0, 5, true, ImportOptions{}, nil, 1},
+		{"unsigned", 0, 5, false, ImportOptions{TrustedKey: pub}, ErrBadSignature, 0},
+		{"other key", 0, 5, true, ImportOptions{TrustedKey: other}, ErrBadSignature, 0},
+		{"older signed forced", 5, 4, true, ImportOptions{Force: true, TrustedKey: pub}, nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			if tt.installed > 0 {
+				if _, _, err := ImportBundle(bytes.NewReader(bundleOf(t, tt.installed, nil)), dst, ImportOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var key ed25519.PrivateKey
+			if tt.sign {
+				key = priv
+			}
+			m, gen, err := ImportBundle(bytes.NewReader(bundleOf(t, tt.version, key)), dst, tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			want := tt.version
+			if err != nil {
+				want = tt.installed
+			} else {
+				if gen != tt.wantGen {
+					t.Errorf("imported generation %d, want %d", gen, tt.wantGen)
+				}
+				if m.Version != tt.version {
+					t.Errorf("imported version %d, want %d", m.Version, tt.version)
+				}
+			}
+			installed, err := ReadManifest(ActivePath(dst))
+			switch {
+			case want == 0 && !isNotExist(err):
+				t.Errorf("failed import installed a database: %v", err)
+			case want != 0 && err != nil:
+				t.Fatal(err)
+			case want != 0 && installed.Version != want:
+				t.Errorf("active database has version %d, want %d", installed.Version, want)
+			}
+		})
+	}
+}
+
+// Bundles with entries that do not match their manifest or that are not
+// signature files are rejected without changing the database.
+func TestImportBundleInvalid(t *testing.T) {
+	sig := teamHash + ":68:Test.Team\n"
+	manifest := func(files map[string]string) string {
+		m := &Manifest{Version: 1, Files: files}
+		data, err := m.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	sum := sha256Hex(sig)
+	tests := []struct {
+		name   string
+		bundle []byte
+	}{
+		{"not gzip", []byte("not a bundle")},
+		{"no manifest", rawBundle(t, [2]string{"main.hdb", sig})},
+		{"missing file", rawBundle(t, [2]string{ManifestFile, manifest(map[string]string{"main.hdb": sum, "daily.hdb": sum})}, [2]string{"main.hdb", sig})},
+		{"extra file", rawBundle(t, [2]string{ManifestFile, manifest(map[string]string{})}, [2]string{"main.hdb", sig})},
+		{"checksum mismatch", rawBundle(t, [2]string{ManifestFile, manifest(map[string]string{"main.hdb": sum})}, [2]string{"main.hdb", sig + sig})},
+		{"duplicate entry", rawBundle(t, [2]string{ManifestFile, manifest(map[string]string{"main.hdb": sum})}, [2]string{"main.hdb", sig}, [2]string{"./main.hdb", sig})},
+		{"path escape", rawBundle(t, [2]string{ManifestFile, manifest(map[string]string{"../main.hdb": sum})}, [2]string{"../main.hdb", sig})},
+		{"not a signature file", rawBundle(t, [2]string{ManifestFile, manifest(map[string]string{"run.sh": sum})}, [2]string{"run.sh", sig})},
+		{"nested known-good index", rawBundle(t, [2]string{ManifestFile, manifest(map[string]string{"sub/nsrl" + KnownSuffix: sum})}, [2]string{"sub/nsrl" + KnownSuffix, sig})},
+		{"invalid signature file", rawBundle(t, [2]string{ManifestFile, manifest(map[string]string{"main.hdb": sha256Hex("not a signature\n")})}, [2]string{"main.hdb", "not a signature\n"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			if _, _, err := ImportBundle(bytes.NewReader(tt.bundle), dst, ImportOptions{}); err == nil {
+				t.Fatal("invalid bundle imported")
+			}
+			if _, err := ReadManifest(ActivePath(dst)); !isNotExist(err) {
+				t.Errorf("failed import installed a database: %v", err)
+			}
+		})
+	}
+}
+
+// Known-good indexes are carried by bundles and loaded from the imported
+// generation.
+func TestBundleKnownIndexes(t *testing.T) {
+	tests := []struct {
+		name    string
+		write   func(t *testing.T, path string)
+		wantErr bool
+	}{
+		{"known-good index", func(t *testing.T, path string) { writeKnown(t, path, topHash) }, false},
+		{"invalid known-good index", func(t *testing.T, path string) { writeFile(t, path, "not an index\n") }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := t.TempDir()
+			writeFile(t, filepath.Join(src, "main.hdb"), teamHash+":68:Test.Team\n")
+			tt.write(t, filepath.Join(src, "nsrl"+KnownSuffix))
+			var bundle bytes.Buffer
+			m, err := CreateBundle(src, &bundle, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := m.Files["nsrl"+KnownSuffix]; !ok {
+				t.Fatalf("bundle manifest has %v, want the known-good index", m.Files)
+			}
+
+			dst := t.TempDir()
+			_, gen, err := ImportBundle(&bundle, dst, ImportOptions{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if gen != 1 {
+				t.Errorf("imported generation %d, want 1", gen)
+			}
+
+			database := &DB{Path: dst, ManifestAction: 2}
+			if err := database.Init(); err != nil {
+				t.Fatal(err)
+			}
+			if err := database.LoadSigs(); err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := database.HasSigWithHash(teamHash); err != nil || !ok {
+				t.Errorf("HasSigWithHash: %v, %v, want true", ok, err)
+			}
+			if ok, err := database.IsKnownGood(topHash); err != nil || !ok {
+				t.Errorf("IsKnownGood: %v, %v, want true", ok, err)
+			}
+		})
+	}
+}
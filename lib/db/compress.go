@@ -0,0 +1,126 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression formats signature files may be stored in.
+const (
+	compressionNone = ""
+	compressionGzip = "gzip"
+	compressionBzip = "bzip2"
+	compressionZstd = "zstd"
+)
+
+// compressionSuffixes maps file suffixes to the compression they imply.
+var compressionSuffixes = map[string]string{
+	".gz":   compressionGzip,
+	".bz2":  compressionBzip,
+	".zst":  compressionZstd,
+	".zstd": compressionZstd,
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	bzipMagic = []byte("BZh")
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// splitCompression returns path without its compression suffix, and the
+// compression implied by the suffix.
+func splitCompression(path string) (string, string) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if c, ok := compressionSuffixes[ext]; ok {
+		return path[:len(path)-len(ext)], c
+	}
+	return path, compressionNone
+}
+
+// detectCompression returns the compression format indicated by the magic
+// bytes at the start of a file.
+func detectCompression(magic []byte) string {
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return compressionGzip
+	case bytes.HasPrefix(magic, zstdMagic):
+		return compressionZstd
+	case bytes.HasPrefix(magic, bzipMagic):
+		return compressionBzip
+	}
+	return compressionNone
+}
+
+// openSigFile opens the signature file at path, transparently decoding it if
+// it is compressed. Compression is detected from the magic bytes of the file,
+// so compressed files are decoded even without a compression suffix. A file
+// with a compression suffix that is not actually compressed is an error.
+//
+// The returned compressed flag reports whether the file is being decoded.
+func openSigFile(path string) (rc io.ReadCloser, compressed bool, err error) {
+	osfile, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() {
+		if err != nil {
+			osfile.Close()
+		}
+	}()
+
+	br := bufio.NewReaderSize(osfile, 64*1024)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+
+	_, bySuffix := splitCompression(path)
+	byMagic := detectCompression(magic)
+	if bySuffix != compressionNone && bySuffix != byMagic {
+		return nil, false, fmt.Errorf("%s is not %s compressed", path, bySuffix)
+	}
+
+	var r io.Reader
+	var closeDecoder func()
+	switch byMagic {
+	case compressionNone:
+		return readCloser{br, osfile.Close}, false, nil
+	case compressionGzip:
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		r, closeDecoder = gr, func() { gr.Close() }
+	case compressionBzip:
+		r, closeDecoder = bzip2.NewReader(br), func() {}
+	case compressionZstd:
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		r, closeDecoder = zr, zr.Close
+	}
+
+	return readCloser{r, func() error {
+		closeDecoder()
+		return osfile.Close()
+	}}, true, nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc readCloser) Close() error {
+	return rc.close()
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// teamLine compressed with bzip2, which the standard library cannot write.
+const teamLineBzip2 = "425a683931415926535949af4f0a0000055b80001000017dd0040037020c00200022213237a6a69a9e414c269a034c48e3c1cb0b486c3ef6b7933153885cf8957f7409594174f873a2ee48a70a120935e9e140"
+
+const teamLine = teamHash + ":68:Test.Team\n"
+
+func compress(t *testing.T, format string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	switch format {
+	case compressionNone:
+		return data
+	case compressionGzip:
+		gw := gzip.NewWriter(&buf)
+		gw.Write(data)
+		gw.Close()
+	case compressionZstd:
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw.Write(data)
+		zw.Close()
+	case compressionBzip:
+		if string(data) != teamLine {
+			t.Fatal("only teamLine can be bzip2 compressed")
+		}
+		b, err := hex.DecodeString(teamLineBzip2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	return buf.Bytes()
+}
+
+// Compressed signature files are detected by their magic bytes, with or
+// without a compression suffix, and a suffix that does not match the contents
+// is an error.
+func TestCompressedSigFiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression string
+		// Replaces the contents of the file if not nil
+		data       []byte
+		wantErr    bool
+		wantLoaded bool
+	}{
+		{"main.hdb", compressionNone, nil, false, true},
+		{"main.hdb.gz", compressionGzip, nil, false, true},
+		{"main.hdb.bz2", compressionBzip, nil, false, true},
+		{"main.hdb.zst", compressionZstd, nil, false, true},
+		{"main.hdb.zstd", compressionZstd, nil, false, true},
+		{"main.hdb.GZ", compressionGzip, nil, false, true},
+		{"main.hdb", compressionGzip, nil, false, true},
+		{"main.hdb", compressionBzip, nil, false, true},
+		{"main.hdb", compressionZstd, nil, false, true},
+		{"main.txt.gz", compressionGzip, nil, false, false},
+		{"main.hdb.gz", compressionNone, nil, true, false},
+		{"main.hdb.gz", compressionZstd, nil, true, false},
+		{"main.hdb.bz2", compressionGzip, nil, true, false},
+		{"main.hdb.zst", compressionBzip, nil, true, false},
+		{"main.hdb.gz", compressionGzip, []byte{}, true, false},
+		{"main.hdb.gz", compressionGzip, []byte{0x1f, 0x8b, 0x08}, true, false},
+	}
+	for _, tt := range tests {
+		name := tt.name + " " + tt.compression
+		if tt.data != nil {
+			name += " corrupt"
+		}
+		t.Run(name, func(t *testing.T) {
+			data := tt.data
+			if data == nil {
+				data = compress(t, tt.compression, []byte(teamLine))
+			}
+			root := t.TempDir()
+			if err := os.WriteFile(filepath.Join(root, tt.name), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			database := &DB{Path: root}
+			if err := database.Init(); err != nil {
+				t.Fatal(err)
+			}
+			err := database.LoadSigs()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if ok, _ := database.HasSigWithHash(teamHash); ok != tt.wantLoaded {
+				t.Errorf("signature loaded: %v, want %v", ok, tt.wantLoaded)
+			}
+		})
+	}
+}
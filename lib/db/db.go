@@ -1,7 +1,7 @@
 package db
 
 import (
-	"bufio"
+	"crypto/ed25519"
 	"database/sql"
 	"fmt"
 	"log"
@@ -9,26 +9,56 @@ import (
 	"path/filepath"
 	"slices"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/bits-and-blooms/bloom/v3"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// A DB is a set of signatures loaded from database folders. The loaded
+// signatures are looked up with its methods and counted by GetHDBStats, which
+// also reports the generations in use, see ActiveGeneration.
 type DB struct {
 	// Path to folder containing database files
 	Path string
 
-	// If enabled, will use a bloom filter to speed up signature lookups
+	// Additional folders containing database files. Path, if set, is loaded
+	// first, followed by Paths in order. If the same hash is found in more
+	// than one folder, the signature from the folder loaded last wins, so
+	// e.g. a local set listed after a vendor set can override it
+	Paths []string
+
+	// If enabled, will use a pre-filter to speed up signature lookups. Despite
+	// the name, the type of filter is selected by PreFilter
 	UseBloom bool
 
+	// The type of pre-filter built if UseBloom is enabled
+	//
+	// PreFilterBloom: A bloom filter, sized for BloomFalsePositiveRate
+	// PreFilterFuse: A binary fuse filter, which needs about 20% less memory
+	// than a bloom filter with the same false positive rate and is faster to
+	// query, but only supports rates of 1/256 and 1/65536. The highest of
+	// the two that is at most BloomFalsePositiveRate is used
+	PreFilter int
+
+	// The maximum memory the pre-filter may use, in bytes. If the filter
+	// would need more for BloomFalsePositiveRate, a less accurate filter that
+	// fits is built instead, or none at all if even that does not fit.
+	// Unlimited if 0
+	MaxFilterMemory uint64
+
 	CreateIndexes bool
 
-	// The false positive rate for the bloom filter.
+	// The false positive rate for the pre-filter.
 	// Should be between 0 and 1
 	BloomFalsePositiveRate float64
 
+	// Number of signature files parsed concurrently by LoadSigs.
+	// Defaults to GOMAXPROCS if 0 or less
+	LoadWorkers int
+
 	// If enabled, will print log messages
 	Log bool
 
@@ -39,33 +69,96 @@ type DB struct {
 	//
 	// 0: Ignore the signature
 	// 1: Make the size check always return true, effectively disabling it
+	// 2: Do as 1 for signatures of threat-intel feeds, which rarely have
+	// sizes, and as 0 for the others
 	UnknownSizeAction int
 
-	sizeAlwaysTrue bool
+	// Selects which signatures are loaded. Signatures excluded by the filter
+	// never reach the index or the pre-filter
+	Filter Filter
+
+	// What should be done if the manifest of the database is missing, does
+	// not match the database files, or is not signed with TrustedKey
+	//
+	// 0: Don't check the manifest
+	// 1: Print a warning and load the signatures anyway
+	// 2: Refuse to load the signatures
+	ManifestAction int
+
+	// The public key the manifest must be signed with. If nil, only the
+	// checksums in the manifest are checked
+	TrustedKey ed25519.PublicKey
+
+	// How long Watch waits for the database folder to settle after a change
+	// before reloading. Defaults to one second if 0
+	WatchDelay time.Duration
+
+	// If set, called by Watch after every automatic reload with its result
+	OnReload func(err error)
 
 	// The sql database connection.
 	sqlC *sql.DB
 
-	bloomFilter *bloom.BloomFilter
+	// The index all lookups are served from. It is never modified once
+	// published, only replaced as a whole
+	idx atomic.Pointer[index]
 
-	Hashes *[]string
-	Sizes  *[]int
+	// Serializes LoadSigs, LoadBloom and Reload
+	loadMu sync.Mutex
+}
 
-	hashes     []string
-	sizes      []int
-	hashToItem map[string]*HDBItem
+// An index is a complete, immutable set of loaded signatures.
+type index struct {
+	hashes         []string
+	sizes          []int
+	hashToItem     map[string]*HDBItem
+	sizeAlwaysTrue bool
+	filter         preFilter
+
+	// The types of the hashes, md5, sha1 or sha256, that signatures exist
+	// for
+	hashTypes map[string]bool
+
+	// The loaded database folders and their active generations
+	roots       []string
+	generations []int
+
+	// The loaded fuzzy signatures, and their positions by hash type and hash
+	ssdeep     []ssdeepSig
+	tlsh       []tlshSig
+	fuzzyIndex map[string]int
+
+	// The known-good indexes of the database folders and of their active
+	// generations. Their files are closed once the index is replaced, see
+	// publish
+	known *knownSet
+
+	// Counters collected while loading, reported by GetHDBStats
+	wildcardSizes int
+	duplicates    int
+	ignored       int
+	filtered      int
+	filteredFiles int
+	loadTime      time.Duration
+	sortTime      time.Duration
 }
 
+// emptyIndex is used for lookups before anything has been loaded.
+var emptyIndex = &index{hashToItem: map[string]*HDBItem{}}
+
 type HDBItem struct {
 	Hash        string
 	HashType    string
 	Filesize    int
 	MalwareName string
 	Comment     string
-}
 
-type HDBStats struct {
-	Count int
+	// The database folder the signature was loaded from
+	Root string
+
+	// Path of the file the signature was loaded from, relative to the
+	// database folder
+	Source string
 }
 
 // It's recommended to instantiate your own DB instance
@@ -73,14 +166,10 @@ func New() *DB {
 	return &DB{}
 }
 
-// Init initializes the DB by setting up the hashToItem map.
+// Init initializes the DB with an empty set of signatures.
 func (db *DB) Init() error {
-	// Initialize hashToItem as an empty map
-	db.hashToItem = make(map[string]*HDBItem)
-
-	db.Hashes = &db.hashes
-	db.Sizes = &db.sizes
-
+	idx := &index{hashToItem: make(map[string]*HDBItem)}
+	db.publish(idx)
 	return nil
 }
 
@@ -96,8 +185,17 @@ func (db *DB) LoadAll() error {
 
 // LoadSigs loads Clamav hash-based signature files, as well as Goava CSV files.
 //
-// The function will walk the directory specified in Path and load all files
-// with the following extensions: .hdb, .hsb, .hdu, .hsu, and .csv.
+// The function will walk the directories specified in Path and Paths and load
+// all files with the following extensions: .hdb, .hsb, .hdu, .hsu, and .csv.
+// Known-good indexes at the top level of each directory are opened as well,
+// see IsKnownGood.
+// Each of these may also be compressed with gzip, bzip2 or zstd, e.g.
+// main.hdb.gz, in which case the file is decoded while it is loaded.
+// If a directory uses generations, only its active generation is loaded.
+// Hidden directories are skipped.
+//
+// Before anything is loaded, the manifest of each directory is verified
+// according to ManifestAction.
 //
 // For .hdb, .hsb, .hdu, .hsu files, the function will parse the file and
 // extract the hashes, sizes, and malware names. Hashes that have unknown
@@ -107,139 +205,249 @@ func (db *DB) LoadAll() error {
 // For .csv files, the function will parse the file and extract the hashes,
 // sizes, malware names, and comments.
 //
-// The loaded signatures will be stored in the hashToItem map, with the hash
-// as the key and the HDBItem as the value.
+// For .fuzzy files, the function will load ssdeep and TLSH signatures, which
+// are matched by similarity rather than by hash. See MatchSSDeep and
+// MatchTLSH.
+//
+// Threat-intel feeds are loaded from .stix and .stix.json STIX 2.1 bundles,
+// .misp and .misp.json MISP events, and .ioc plain lists of hashes. See
+// docParserForPath for how they are mapped to signatures.
+//
+// Signatures not selected by Filter are dropped while loading, and files
+// whose source is excluded are not read at all.
+//
+// Files are parsed concurrently by LoadWorkers goroutines and merged in the
+// order they were found, so the result does not depend on the number of
+// workers.
 //
 // The function will also sort the hashes and sizes for use with the
 // HasSigWithHash and HasSigWithSize methods.
 //
+// The loaded signatures replace any previously loaded ones, without a
+// pre-filter. Use Reload to replace them including the pre-filter.
+//
 // The function will return an error if there is a problem loading the
-// signatures.
+// signatures, in which case the previously loaded signatures are kept.
 //
 // Should be called after Init
 func (db *DB) LoadSigs() error {
+	db.loadMu.Lock()
+	defer db.loadMu.Unlock()
+
+	idx, err := db.buildIndex()
+	if err != nil {
+		return err
+	}
+	db.publish(idx)
+	return nil
+}
+
+// LoadBloom initializes the pre-filter selected by PreFilter if the UseBloom
+// flag is set to true.
+// Should be called after Init and LoadSigs
+func (db *DB) LoadBloom() {
+	db.loadMu.Lock()
+	defer db.loadMu.Unlock()
+
+	if !db.UseBloom {
+		return
+	}
+	idx := *db.current()
+	db.buildPreFilter(&idx)
+	db.publish(&idx)
+}
+
+// Reload loads the signatures and pre-filter into a new index and then
+// swaps it in atomically. Lookups running concurrently see either the old or
+// the new signatures, never a mix. If loading fails, the old signatures stay
+// in place and the error is returned.
+//
+// Reload can be called at any time after Init, including while lookups are
+// running. For that reason it does not update the deprecated Hashes, Sizes
+// and Generation fields, so callers of Reload must use the lookup methods
+// and GetHDBStats instead.
+func (db *DB) Reload() error {
+	db.loadMu.Lock()
+	defer db.loadMu.Unlock()
+
+	db.nl(func() { db.Logger.Print("Reloading signatures...") })
+	idx, err := db.buildIndex()
+	if err != nil {
+		return err
+	}
+	if db.UseBloom {
+		db.buildPreFilter(idx)
+	}
+	db.publish(idx)
+	db.nl(func() { db.Logger.Printf("Reloaded %d signatures", len(idx.hashes)) })
+	return nil
+}
+
+// buildIndex loads the signatures of the active databases into a new index.
+func (db *DB) buildIndex() (*index, error) {
 	db.nl(func() { db.Logger.Print("Loading signatures...") })
-	err := filepath.Walk(db.Path, func(path string, info os.FileInfo, err error) error {
+	loadStart := time.Now()
+	roots := db.Roots()
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no database folders configured")
+	}
+	filter, err := db.Filter.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &index{}
+	var files []sigFile
+	var knownDirs []string
+	for _, root := range roots {
+		gen, err := ActiveGeneration(root)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if info.IsDir() {
-			return nil
+		idx.roots = append(idx.roots, root)
+		idx.generations = append(idx.generations, gen)
+		if gen > 0 {
+			db.nl(func() { db.Logger.Printf("Using generation %d of %s", gen, root) })
+		}
+
+		active := ActivePath(root)
+		if err := db.verifyManifest(root, active); err != nil {
+			return nil, err
+		}
+		// Known-good indexes are shared by the generations, or imported
+		// with a bundle into one
+		knownDirs = append(knownDirs, root)
+		if active != root {
+			knownDirs = append(knownDirs, active)
 		}
-		switch filepath.Ext(path) {
-		// Decode Clamav hash-based signature files
-		case ".hdb", ".hsb", ".hdu", ".hsu":
-			db.nl(func() { db.Logger.Printf("Loading %s", path) })
-			osfile, err := os.OpenFile(path, os.O_RDONLY, 0)
+		err = filepath.Walk(active, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
-			defer osfile.Close()
-			scanner := bufio.NewScanner(osfile)
-			for scanner.Scan() {
-				line := scanner.Text()
-				if len(line) > 0 {
-					values := strings.Split(line, ":")
-					var fileSize int64
-					if values[1] == "*" {
-						if db.UnknownSizeAction == 1 {
-							db.nl(func() {
-								db.Logger.Printf("%s contains a signature with unknown size, disabling size checks", path)
-							})
-							db.sizeAlwaysTrue = true
-						} else if db.UnknownSizeAction == 0 {
-							db.nl(func() {
-								db.Logger.Printf("%s contains a signature with unknown size, skipping signature", path)
-							})
-							continue
-						}
-						fileSize = -1
-					} else {
-						fileSize, err = strconv.ParseInt(values[1], 10, 64)
-						if err != nil {
-							return err
-						}
-					}
-
-					var hashType string
-					switch len(values[0]) {
-					case 32:
-						hashType = "md5"
-					case 40:
-						hashType = "sha1"
-					case 64:
-						hashType = "sha256"
-					}
-					db.hashes = append(db.hashes, values[0])
-					db.sizes = append(db.sizes, int(fileSize))
-
-					db.hashToItem[values[0]] = &HDBItem{
-						Hash:        values[0],
-						HashType:    hashType,
-						Filesize:    int(fileSize),
-						MalwareName: values[2],
-					}
+			if info.IsDir() {
+				if isHiddenDir(active, path) {
+					return filepath.SkipDir
 				}
+				return nil
 			}
-			if err := scanner.Err(); err != nil {
-				return err
+			parse, decode := parserForPath(path), docParserForPath(path)
+			if parse == nil && decode == nil {
+				return nil
 			}
-		case ".csv":
-			db.nl(func() { db.Logger.Printf("Loading %s", path) })
-			osfile, err := os.OpenFile(path, os.O_RDONLY, 0)
+			source, err := filepath.Rel(active, path)
 			if err != nil {
 				return err
 			}
-			defer osfile.Close()
-			scanner := bufio.NewScanner(osfile)
-			for scanner.Scan() {
-				line := scanner.Text()
-				if len(line) > 0 {
-					values := strings.Split(line, ",")
-					fileSize, err := strconv.ParseInt(values[2], 10, 64)
-					if err != nil {
-						return err
-					}
-					db.hashes = append(db.hashes, values[0])
-					db.sizes = append(db.sizes, int(fileSize))
-					db.hashToItem[values[0]] = &HDBItem{
-						Hash:        values[0],
-						HashType:    values[1],
-						Filesize:    int(fileSize),
-						MalwareName: values[3],
-						Comment:     values[4],
-					}
-				}
-			}
-			if err := scanner.Err(); err != nil {
-				return err
+			source = filepath.ToSlash(source)
+			if !filter.allowsSource(source) {
+				db.nl(func() { db.Logger.Printf("Skipping %s, excluded by filter", path) })
+				idx.filteredFiles++
+				return nil
 			}
+			files = append(files, sigFile{path: path, root: root, source: source, parse: parse, decode: decode})
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
-		return nil
-	})
-	if err != nil {
-		return err
 	}
+	if err := db.loadFiles(idx, files, filter); err != nil {
+		return nil, err
+	}
+	// Opened last, so that no files are left open if loading fails
+	if idx.known, err = openKnownIndexes(knownDirs); err != nil {
+		return nil, err
+	}
+	idx.loadTime = time.Since(loadStart)
 
 	// Sort hashes and sizes
 	db.nl(func() { db.Logger.Print("Sorting hashes and sizes...") })
-	slices.Sort(db.sizes)
-	slices.Sort(db.hashes)
+	sortStart := time.Now()
+	slices.Sort(idx.sizes)
+	slices.Sort(idx.hashes)
+	idx.sortTime = time.Since(sortStart)
 
-	return nil
+	return idx, nil
 }
 
-// LoadBloom initializes the bloom filter if the UseBloom flag is set to true.
-// Should be called after Init and LoadSigs
-func (db *DB) LoadBloom() {
-	if db.UseBloom {
-		db.nl(func() { db.Logger.Print("Creating bloom filter...") })
-		// Load hashes into bloom filter
-		db.bloomFilter = bloom.NewWithEstimates(uint(len(db.hashes)), db.BloomFalsePositiveRate)
-		for _, hash := range db.hashes {
-			db.bloomFilter.AddString(hash)
+// publish makes idx the index all lookups are served from. The known-good
+// indexes of the index it replaces are closed, unless idx shares them,
+// once the lookups still using them are done.
+func (db *DB) publish(idx *index) {
+	old := db.idx.Swap(idx)
+	if old != nil && old.known != idx.known {
+		old.known.close()
+	}
+}
+
+// generation returns the active generation of the last loaded folder.
+func (idx *index) generation() int {
+	if len(idx.generations) == 0 {
+		return 0
+	}
+	return idx.generations[len(idx.generations)-1]
+}
+
+// Roots returns the database folders that are loaded, in order of increasing
+// precedence.
+func (db *DB) Roots() []string {
+	var roots []string
+	if db.Path != "" {
+		roots = append(roots, db.Path)
+	}
+	for _, path := range db.Paths {
+		if path != "" {
+			roots = append(roots, path)
+		}
+	}
+	return roots
+}
+
+// DefaultSearchPaths are the folders Discover looks for databases in, in
+// order of increasing precedence.
+var DefaultSearchPaths = []string{"/var/lib/clamav"}
+
+// Discover returns the folders of DefaultSearchPaths followed by extra that
+// exist, for use as the database folders when none are configured.
+func Discover(extra ...string) []string {
+	var found []string
+	for _, path := range append(append([]string{}, DefaultSearchPaths...), extra...) {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			found = append(found, path)
 		}
 	}
+	return found
+}
+
+// current returns the index lookups are currently served from.
+func (db *DB) current() *index {
+	if idx := db.idx.Load(); idx != nil {
+		return idx
+	}
+	return emptyIndex
+}
+
+// verifyManifest verifies the manifest of active, the active generation of
+// the database folder root, according to ManifestAction. If root uses
+// generations, its known-good indexes are verified against the manifest of
+// root itself, as they are kept outside of the generations.
+func (db *DB) verifyManifest(root, active string) error {
+	if db.ManifestAction == 0 {
+		return nil
+	}
+	db.nl(func() { db.Logger.Print("Verifying manifest...") })
+	_, err := VerifyManifest(active, db.TrustedKey)
+	if err == nil && active != root {
+		err = verifyKnownManifest(root, db.TrustedKey)
+	}
+	if err == nil {
+		return nil
+	}
+	if db.ManifestAction == 2 {
+		return fmt.Errorf("manifest verification failed: %w", err)
+	}
+	db.nl(func() { db.Logger.Printf("WARNING: manifest verification failed, loading signatures anyway: %v", err) })
+	return nil
 }
 
 // Close releases any resources used by the database, such as closing the
@@ -257,17 +465,129 @@ func (db *DB) Ping() error {
 	return nil
 }
 
+// A knownSet holds the known-good indexes of an index. Lookups hold its read
+// lock, so that closing it waits for them to finish.
+type knownSet struct {
+	mu      sync.RWMutex
+	closed  bool
+	indexes []*KnownIndex
+}
+
+// knownPaths returns the paths of the known-good indexes at the top level of
+// the database folder root.
+func knownPaths(root string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(root, "*"+KnownSuffix))
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(paths, isHiddenName), nil
+}
+
+// openKnownIndexes opens the known-good indexes at the top level of the
+// folders dirs.
+func openKnownIndexes(dirs []string) (*knownSet, error) {
+	known := &knownSet{}
+	for _, dir := range dirs {
+		paths, err := knownPaths(dir)
+		if err != nil {
+			known.close()
+			return nil, err
+		}
+		for _, path := range paths {
+			k, err := OpenKnownIndex(path)
+			if err != nil {
+				known.close()
+				return nil, err
+			}
+			known.indexes = append(known.indexes, k)
+		}
+	}
+	return known, nil
+}
+
+// contains reports whether hash is in one of the indexes of the set. If the
+// set has been closed, closed is true and the lookup must be retried with the
+// current index.
+func (s *knownSet) contains(hash string) (found, closed bool, err error) {
+	if s == nil {
+		return false, false, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return false, true, nil
+	}
+	for _, k := range s.indexes {
+		if ok, err := k.Contains(hash); ok || err != nil {
+			return ok, false, err
+		}
+	}
+	return false, false, nil
+}
+
+// len returns the number of hashes in the indexes of the set.
+func (s *knownSet) len() int {
+	if s == nil {
+		return 0
+	}
+	n := 0
+	for _, k := range s.indexes {
+		n += k.Len()
+	}
+	return n
+}
+
+// close closes the files of the indexes, after waiting for the lookups
+// using them.
+func (s *knownSet) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for _, k := range s.indexes {
+		k.Close()
+	}
+}
+
+// IsKnownGood returns true if the file with the given md5 hash is in one of
+// the known-good indexes of the database, meaning it is known to be clean.
+// See ImportNSRL.
+func (db *DB) IsKnownGood(hash string) (bool, error) {
+	for {
+		// A set is only closed once its index has been replaced, so the
+		// retry uses the new one
+		found, closed, err := db.current().known.contains(hash)
+		if !closed {
+			return found, err
+		}
+	}
+}
+
+// HasHashSigs reports whether any signatures with hashes of hashType, md5,
+// sha1 or sha256, are loaded. If not, there is no need to compute hashes of
+// that type of scanned files.
+func (db *DB) HasHashSigs(hashType string) bool {
+	return db.current().hashTypes[hashType]
+}
+
 // HasSigWithHash returns true if a signature with the given hash exists in the database.
 // The search is done using a binary search.
-// If the bloom filter is enabled, it will be used to speed up the search further.
+// If the pre-filter is enabled, hashes it rules out skip the binary search.
+// Hashes it lets through are still searched for, as it has false positives.
 func (db *DB) HasSigWithHash(hash string) (bool, error) {
+	idx := db.current()
 
-	if db.UseBloom {
-		return db.bloomFilter.TestString(hash), nil
+	if db.UseBloom && idx.filter != nil && !idx.filter.test(hash) {
+		return false, nil
 	}
 	// Check if hash exists using binary search
-	index := sort.SearchStrings(db.hashes, hash)
-	return index < len(db.hashes) && db.hashes[index] == hash, nil
+	i := sort.SearchStrings(idx.hashes, hash)
+	return i < len(idx.hashes) && idx.hashes[i] == hash, nil
 
 }
 
@@ -275,23 +595,25 @@ func (db *DB) HasSigWithHash(hash string) (bool, error) {
 // Uses a binary search.
 // Will always return true if sizeAlwaysTrue is true, this value is set if a signature has an unknown size
 func (db *DB) HasSigWithSize(size int) (bool, error) {
+	idx := db.current()
 
-	if db.sizeAlwaysTrue {
+	if idx.sizeAlwaysTrue {
 		return true, nil
 	}
 
-	index := sort.SearchInts(db.sizes, size)
-	return index < len(db.sizes) && db.sizes[index] == size, nil
+	i := sort.SearchInts(idx.sizes, size)
+	return i < len(idx.sizes) && idx.sizes[i] == size, nil
 }
 
 // GetItemByHash returns the HDBItem associated with the given hash, or an error
 // if the hash is not found.
 func (db *DB) GetItemByHash(hash string) (*HDBItem, error) {
-	index := sort.SearchStrings(db.hashes, hash)
-	if index >= len(db.hashes) || db.hashes[index] != hash {
+	idx := db.current()
+	i := sort.SearchStrings(idx.hashes, hash)
+	if i >= len(idx.hashes) || idx.hashes[i] != hash {
 		return nil, fmt.Errorf("hash %s not found", hash)
 	}
-	return db.hashToItem[hash], nil
+	return idx.hashToItem[hash], nil
 }
 
 // GetItemBySize returns the HDBItem associated with the given size, or an error
@@ -299,7 +621,7 @@ func (db *DB) GetItemByHash(hash string) (*HDBItem, error) {
 // and is therefore MUCH slower than GetItemByHash.
 func (db *DB) GetItemBySize(size int) (*HDBItem, error) {
 	// Brute force search
-	for _, item := range db.hashToItem {
+	for _, item := range db.current().hashToItem {
 		if item.Filesize == size {
 			return item, nil
 		}
@@ -307,10 +629,10 @@ func (db *DB) GetItemBySize(size int) (*HDBItem, error) {
 	return nil, fmt.Errorf("item with size %d not found", size)
 }
 
-func (db *DB) GetHDBStats() HDBStats {
-	return HDBStats{
-		Count: len(db.hashes),
-	}
+// isHiddenDir reports whether the directory at path, below root, is hidden.
+// Hidden directories hold staging areas and are never part of a database.
+func isHiddenDir(root, path string) bool {
+	return path != root && strings.HasPrefix(filepath.Base(path), ".")
 }
 
 // Runs the specified function if Log is true
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// Reload runs while lookups are served. Run with -race.
+func TestReloadConcurrent(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.hdb"), teamHash+":68:Test.Team\n")
+	database := &DB{Path: root, UseBloom: true, BloomFalsePositiveRate: 0.01}
+	if err := database.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 20 {
+			if err := database.Reload(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for range 200 {
+		if ok, err := database.HasSigWithHash(teamHash); err != nil || !ok {
+			t.Fatalf("lookup during reload: %v, %v", ok, err)
+		}
+		if stats := database.GetHDBStats(); stats.Count != 1 || stats.Generation != 0 {
+			t.Fatalf("stats during reload: %d signatures of generation %d", stats.Count, stats.Generation)
+		}
+	}
+	wg.Wait()
+
+	if stats := database.GetHDBStats(); stats.Count != 1 {
+		t.Errorf("got %d signatures after reloading, want 1", stats.Count)
+	}
+}
+
+// A hash found in more than one database folder is loaded from the folder
+// loaded last, and signatures remember the folder they were loaded from.
+func TestRootsPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		// Name of the signature for teamHash in each folder, empty for none.
+		// The first folder is DB.Path unless noPath is set
+		folders []string
+		noPath  bool
+		// Index of the folder the signature is loaded from
+		want int
+	}{
+		{"single folder", []string{"Test.Vendor"}, false, 0},
+		{"override", []string{"Test.Vendor", "Test.Local"}, false, 1},
+		{"not overridden", []string{"Test.Vendor", ""}, false, 0},
+		{"last of three", []string{"Test.Vendor", "Test.Local", "Test.Site"}, false, 2},
+		{"middle of three", []string{"Test.Vendor", "Test.Local", ""}, false, 1},
+		{"only paths", []string{"Test.Vendor", "Test.Local"}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var folders []string
+			for i, name := range tt.folders {
+				dir := t.TempDir()
+				folders = append(folders, dir)
+				// Named in reverse, so folder order and not file order decides
+				content := topHash + ":2:Test.Top\n"
+				if name != "" {
+					content += teamHash + ":68:" + name + "\n"
+				}
+				writeFile(t, filepath.Join(dir, "sigs"+string(rune('z'-i))+".hdb"), content)
+			}
+			database := &DB{Paths: folders}
+			if !tt.noPath {
+				database = &DB{Path: folders[0], Paths: folders[1:]}
+			}
+			if got := database.Roots(); !slices.Equal(got, folders) {
+				t.Fatalf("got roots %v, want %v", got, folders)
+			}
+			if err := database.Init(); err != nil {
+				t.Fatal(err)
+			}
+			if err := database.LoadSigs(); err != nil {
+				t.Fatal(err)
+			}
+
+			item, err := database.GetItemByHash(teamHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if item.MalwareName != tt.folders[tt.want] || item.Root != folders[tt.want] {
+				t.Errorf("got %s from %s, want %s from %s", item.MalwareName, item.Root, tt.folders[tt.want], folders[tt.want])
+			}
+			if stats := database.GetHDBStats(); stats.Count != 2 || stats.ByRoot[folders[tt.want]] < 1 {
+				t.Errorf("got %d signatures by folder %v, want 2", stats.Count, stats.ByRoot)
+			}
+		})
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+	vendor, local := filepath.Join(dir, "vendor"), filepath.Join(dir, "local")
+	for _, d := range []string{vendor, local} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "file")
+	writeFile(t, file, "")
+	missing := filepath.Join(dir, "missing")
+
+	defer func(paths []string) { DefaultSearchPaths = paths }(DefaultSearchPaths)
+	tests := []struct {
+		name     string
+		defaults []string
+		extra    []string
+		want     []string
+	}{
+		{"none", nil, nil, nil},
+		{"defaults", []string{missing, vendor, file}, nil, []string{vendor}},
+		{"extra after defaults", []string{vendor}, []string{missing, local}, []string{vendor, local}},
+		{"only extra", []string{missing}, []string{local, vendor}, []string{local, vendor}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DefaultSearchPaths = tt.defaults
+			if got := Discover(tt.extra...); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
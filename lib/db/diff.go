@@ -0,0 +1,178 @@
+package db
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// A Diff describes the changes between two sets of signatures.
+type Diff struct {
+	// Signatures whose hash is only in the new set
+	Added []HDBItem
+
+	// Signatures whose hash is only in the old set
+	Removed []HDBItem
+
+	// Signatures whose hash is in both sets under a different name
+	Renamed []Rename
+
+	// Number of changes per family and per category, see HDBItem.Family and
+	// HDBItem.Category. Renamed signatures are counted under their new name
+	ByFamily   map[string]DiffCounts
+	ByCategory map[string]DiffCounts
+
+	// Number of signatures in the old and the new set
+	OldCount int
+	NewCount int
+}
+
+// A Rename is a signature whose name changed between two sets of signatures.
+type Rename struct {
+	Hash    string
+	OldName string
+	NewName string
+}
+
+// DiffCounts counts the changes of a group of signatures.
+type DiffCounts struct {
+	Added   int
+	Removed int
+	Renamed int
+}
+
+// LoadItems loads every signature of the database at path, which is either a
+// database folder or a bundle created by CreateBundle, and returns them by
+// hash. Signatures with an unknown size and fuzzy signatures are included.
+func LoadItems(path string) (map[string]*HDBItem, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := path
+	if !info.IsDir() {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if dir, err = os.MkdirTemp("", "goava-diff-*"); err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		if _, _, _, err := extractBundle(f, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	database := &DB{Path: dir, UnknownSizeAction: 1}
+	idx, err := database.buildIndex()
+	if err != nil {
+		return nil, err
+	}
+	// Only the signatures are needed
+	idx.known.close()
+	items := idx.hashToItem
+	for _, sig := range idx.ssdeep {
+		items[sig.item.Hash] = sig.item
+	}
+	for _, sig := range idx.tlsh {
+		items[sig.item.Hash] = sig.item
+	}
+	if dir != path {
+		// Refer to the bundle rather than the temporary folder
+		for _, item := range items {
+			item.Root = path
+		}
+	}
+	return items, nil
+}
+
+// DiffItems compares the signatures in old with those in new. The signatures
+// of the result are sorted by name.
+func DiffItems(old, new map[string]*HDBItem) *Diff {
+	diff := &Diff{
+		ByFamily:   make(map[string]DiffCounts),
+		ByCategory: make(map[string]DiffCounts),
+		OldCount:   len(old),
+		NewCount:   len(new),
+	}
+	count := func(item *HDBItem, update func(*DiffCounts)) {
+		c := diff.ByFamily[item.Family()]
+		update(&c)
+		diff.ByFamily[item.Family()] = c
+		c = diff.ByCategory[item.Category()]
+		update(&c)
+		diff.ByCategory[item.Category()] = c
+	}
+
+	for hash, item := range new {
+		prev, ok := old[hash]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, *item)
+			count(item, func(c *DiffCounts) { c.Added++ })
+		case prev.MalwareName != item.MalwareName:
+			diff.Renamed = append(diff.Renamed, Rename{Hash: hash, OldName: prev.MalwareName, NewName: item.MalwareName})
+			count(item, func(c *DiffCounts) { c.Renamed++ })
+		}
+	}
+	for hash, item := range old {
+		if _, ok := new[hash]; !ok {
+			diff.Removed = append(diff.Removed, *item)
+			count(item, func(c *DiffCounts) { c.Removed++ })
+		}
+	}
+
+	sortItems(diff.Added)
+	sortItems(diff.Removed)
+	sort.Slice(diff.Renamed, func(i, j int) bool {
+		if diff.Renamed[i].NewName != diff.Renamed[j].NewName {
+			return diff.Renamed[i].NewName < diff.Renamed[j].NewName
+		}
+		return diff.Renamed[i].Hash < diff.Renamed[j].Hash
+	})
+	return diff
+}
+
+func sortItems(items []HDBItem) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].MalwareName != items[j].MalwareName {
+			return items[i].MalwareName < items[j].MalwareName
+		}
+		return items[i].Hash < items[j].Hash
+	})
+}
+
+// Family returns the family of the signature, which is its name without the
+// signature ID and revision.
+//
+// Clamav names signatures Platform.Category.Name-SigID-Revision, e.g.
+// Win.Trojan.Agent-12345-0, in which case the family is Win.Trojan.Agent.
+// Names without a signature ID are their own family.
+func (item *HDBItem) Family() string {
+	name := item.MalwareName
+	for range 2 {
+		i := strings.LastIndexByte(name, '-')
+		if i < 0 || !isDigits(name[i+1:]) {
+			break
+		}
+		name = name[:i]
+	}
+	if name == "" {
+		return "Unknown"
+	}
+	return name
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
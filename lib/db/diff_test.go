@@ -0,0 +1,36 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openFiles returns the number of files open in the process.
+func openFiles(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("cannot count open files:", err)
+	}
+	return len(entries)
+}
+
+// LoadItems does not leave the known-good indexes of the database open.
+func TestLoadItemsKnownIndexes(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.hdb"), teamHash+":68:Test.Team\n")
+	writeKnown(t, filepath.Join(root, "nsrl"+KnownSuffix), topHash)
+
+	before := openFiles(t)
+	items, err := LoadItems(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := openFiles(t); after != before {
+		t.Errorf("%d files open after LoadItems, want %d", after, before)
+	}
+	if len(items) != 1 || items[teamHash] == nil {
+		t.Errorf("got %v, want the signature of main.hdb", items)
+	}
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// A Filter selects which signatures are loaded by LoadSigs.
+//
+// Signatures can be filtered by name, by the source file they were loaded
+// from and by their category, see HDBItem.Category. Patterns are globs as
+// understood by path.Match, e.g. Unix.* or daily/*.hdb, unless they start
+// with "re:", in which case the rest of the pattern is a regular expression
+// that must match the whole value, e.g. re:(Win|Unix)\.Trojan\..*
+//
+// A signature is loaded if, for each of name, source and category, it matches
+// at least one include pattern, or there are none, and it matches no exclude
+// pattern. The zero value loads every signature.
+type Filter struct {
+	IncludeNames      []string
+	ExcludeNames      []string
+	IncludeSources    []string
+	ExcludeSources    []string
+	IncludeCategories []string
+	ExcludeCategories []string
+}
+
+// IsZero reports whether f loads every signature.
+func (f *Filter) IsZero() bool {
+	return len(f.IncludeNames)+len(f.ExcludeNames)+len(f.IncludeSources)+
+		len(f.ExcludeSources)+len(f.IncludeCategories)+len(f.ExcludeCategories) == 0
+}
+
+// A pattern matches a single value, see Filter for the syntax.
+type pattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+func compilePattern(p string) (pattern, error) {
+	if expr, ok := strings.CutPrefix(p, "re:"); ok {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return pattern{}, fmt.Errorf("invalid filter pattern %q: %w", p, err)
+		}
+		return pattern{re: re}, nil
+	}
+	if _, err := path.Match(p, ""); err != nil {
+		return pattern{}, fmt.Errorf("invalid filter pattern %q: %w", p, err)
+	}
+	return pattern{glob: p}, nil
+}
+
+func (p pattern) match(s string) bool {
+	if p.re != nil {
+		return p.re.MatchString(s)
+	}
+	ok, _ := path.Match(p.glob, s)
+	return ok
+}
+
+// A patternSet is the compiled include and exclude patterns of one field.
+type patternSet struct {
+	include []pattern
+	exclude []pattern
+}
+
+func compilePatternSet(include, exclude []string) (patternSet, error) {
+	var set patternSet
+	for _, p := range include {
+		c, err := compilePattern(p)
+		if err != nil {
+			return set, err
+		}
+		set.include = append(set.include, c)
+	}
+	for _, p := range exclude {
+		c, err := compilePattern(p)
+		if err != nil {
+			return set, err
+		}
+		set.exclude = append(set.exclude, c)
+	}
+	return set, nil
+}
+
+func (set *patternSet) allows(s string) bool {
+	for _, p := range set.exclude {
+		if p.match(s) {
+			return false
+		}
+	}
+	if len(set.include) == 0 {
+		return true
+	}
+	for _, p := range set.include {
+		if p.match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// A sigFilter is a compiled Filter. A nil sigFilter allows everything.
+type sigFilter struct {
+	names      patternSet
+	sources    patternSet
+	categories patternSet
+}
+
+// compile checks the patterns of f and compiles them, returning nil if f
+// loads every signature.
+func (f *Filter) compile() (*sigFilter, error) {
+	if f.IsZero() {
+		return nil, nil
+	}
+	var sf sigFilter
+	var err error
+	if sf.names, err = compilePatternSet(f.IncludeNames, f.ExcludeNames); err != nil {
+		return nil, err
+	}
+	if sf.sources, err = compilePatternSet(f.IncludeSources, f.ExcludeSources); err != nil {
+		return nil, err
+	}
+	if sf.categories, err = compilePatternSet(f.IncludeCategories, f.ExcludeCategories); err != nil {
+		return nil, err
+	}
+	return &sf, nil
+}
+
+// allowsSource reports whether signatures from the file source may be
+// loaded at all.
+func (sf *sigFilter) allowsSource(source string) bool {
+	return sf == nil || sf.sources.allows(source)
+}
+
+// allows reports whether item is loaded, given that its source is allowed.
+func (sf *sigFilter) allows(item *HDBItem) bool {
+	return sf == nil || (sf.names.allows(item.MalwareName) && sf.categories.allows(item.Category()))
+}
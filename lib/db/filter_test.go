@@ -0,0 +1,85 @@
+package db
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	sigs := map[string]string{
+		"11111111111111111111111111111111": "Win.Trojan.Agent-1-0",
+		"22222222222222222222222222222222": "Unix.Trojan.Mirai-2-0",
+		"33333333333333333333333333333333": "Doc.Exploit.Macro-3-0",
+		"44444444444444444444444444444444": "Eicar-Test-Signature",
+	}
+	// Source file of each signature
+	sources := map[string]string{
+		"11111111111111111111111111111111": "main.hdb",
+		"22222222222222222222222222222222": "main.hdb",
+		"33333333333333333333333333333333": "daily/office.hdb",
+		"44444444444444444444444444444444": "local.hdb",
+	}
+	tests := []struct {
+		name    string
+		filter  Filter
+		want    []string
+		wantErr bool
+	}{
+		{"zero", Filter{}, []string{"Win.Trojan.Agent-1-0", "Unix.Trojan.Mirai-2-0", "Doc.Exploit.Macro-3-0", "Eicar-Test-Signature"}, false},
+		{"include name glob", Filter{IncludeNames: []string{"Win.*"}}, []string{"Win.Trojan.Agent-1-0"}, false},
+		{"include names", Filter{IncludeNames: []string{"Win.*", "Eicar-*"}}, []string{"Win.Trojan.Agent-1-0", "Eicar-Test-Signature"}, false},
+		{"exclude name glob", Filter{ExcludeNames: []string{"*.Trojan.*"}}, []string{"Doc.Exploit.Macro-3-0", "Eicar-Test-Signature"}, false},
+		{"include name regexp", Filter{IncludeNames: []string{`re:(Win|Unix)\.Trojan\..*`}}, []string{"Win.Trojan.Agent-1-0", "Unix.Trojan.Mirai-2-0"}, false},
+		{"regexp matches whole name", Filter{IncludeNames: []string{"re:Trojan"}}, nil, false},
+		{"exclude wins", Filter{IncludeNames: []string{"*"}, ExcludeNames: []string{"Unix.*"}}, []string{"Win.Trojan.Agent-1-0", "Doc.Exploit.Macro-3-0", "Eicar-Test-Signature"}, false},
+		{"include source", Filter{IncludeSources: []string{"daily/*"}}, []string{"Doc.Exploit.Macro-3-0"}, false},
+		{"exclude source", Filter{ExcludeSources: []string{"main.hdb", "local.hdb"}}, []string{"Doc.Exploit.Macro-3-0"}, false},
+		{"include category", Filter{IncludeCategories: []string{"Trojan"}}, []string{"Win.Trojan.Agent-1-0", "Unix.Trojan.Mirai-2-0"}, false},
+		{"exclude unknown category", Filter{ExcludeCategories: []string{"Unknown"}}, []string{"Win.Trojan.Agent-1-0", "Unix.Trojan.Mirai-2-0", "Doc.Exploit.Macro-3-0"}, false},
+		{"all fields", Filter{IncludeNames: []string{"Win.*", "Doc.*"}, IncludeSources: []string{"main.hdb"}, IncludeCategories: []string{"Trojan"}}, []string{"Win.Trojan.Agent-1-0"}, false},
+		{"invalid glob", Filter{IncludeNames: []string{"Win.["}}, nil, true},
+		{"invalid regexp", Filter{ExcludeCategories: []string{"re:("}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			files := make(map[string]string)
+			for hash, name := range sigs {
+				files[sources[hash]] += hash + ":10:" + name + "\n"
+			}
+			for source, content := range files {
+				writeFile(t, filepath.Join(root, filepath.FromSlash(source)), content)
+			}
+
+			database := &DB{Path: root, Filter: tt.filter}
+			if err := database.Init(); err != nil {
+				t.Fatal(err)
+			}
+			err := database.LoadSigs()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			var got []string
+			for hash, name := range sigs {
+				if ok, _ := database.HasSigWithHash(hash); ok {
+					got = append(got, name)
+				}
+			}
+			want := slices.Clone(tt.want)
+			slices.Sort(got)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			// Signatures of files that are not read are not counted
+			if stats := database.GetHDBStats(); stats.FilteredFiles == 0 && stats.Count+stats.Filtered != len(sigs) {
+				t.Errorf("%d loaded and %d filtered signatures, want %d", stats.Count, stats.Filtered, len(sigs))
+			}
+		})
+	}
+}
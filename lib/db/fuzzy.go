@@ -0,0 +1,159 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hexahigh/goava/lib/fuzzy"
+)
+
+// Hash types of fuzzy signatures.
+const (
+	HashTypeSSDeep = "ssdeep"
+	HashTypeTLSH   = "tlsh"
+)
+
+// isFuzzyType reports whether hashType is the type of a fuzzy signature.
+func isFuzzyType(hashType string) bool {
+	return hashType == HashTypeSSDeep || hashType == HashTypeTLSH
+}
+
+// parseFuzzyLine decodes a line of a Goava fuzzy signature file.
+//
+// The format is HashType,Hash,MalwareName,Comment, where HashType is ssdeep
+// or tlsh. The comment is optional. Fuzzy signatures have no size.
+func parseFuzzyLine(line string, item *HDBItem) error {
+	hashType, rest, ok := strings.Cut(line, ",")
+	if !ok {
+		return fmt.Errorf("expected at least 3 fields, got 1")
+	}
+	hash, rest, ok := strings.Cut(rest, ",")
+	if !ok {
+		return fmt.Errorf("expected at least 3 fields, got 2")
+	}
+	name, comment, _ := strings.Cut(rest, ",")
+
+	switch hashType {
+	case HashTypeSSDeep:
+		if _, err := fuzzy.ParseSSDeep(hash); err != nil {
+			return err
+		}
+	case HashTypeTLSH:
+		if _, err := fuzzy.ParseTLSH(hash); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported fuzzy hash type %q", hashType)
+	}
+
+	*item = HDBItem{
+		Hash:        hash,
+		HashType:    hashType,
+		Filesize:    -1,
+		MalwareName: name,
+		Comment:     comment,
+	}
+	return nil
+}
+
+// FuzzyLine formats a fuzzy signature as a line of a Goava fuzzy signature
+// file. The fields are not escaped: the name may not contain a comma, and
+// neither the name nor the comment may contain a line break.
+func FuzzyLine(hashType, hash, name, comment string) (string, error) {
+	if strings.Contains(name, ",") {
+		return "", fmt.Errorf("malware name %q contains a comma", name)
+	}
+	if strings.ContainsAny(name+comment, "\r\n") {
+		return "", fmt.Errorf("malware name or comment contains a line break")
+	}
+	return strings.Join([]string{hashType, hash, name, comment}, ","), nil
+}
+
+// ssdeepSig and tlshSig are loaded fuzzy signatures along with their parsed
+// hashes.
+type ssdeepSig struct {
+	item *HDBItem
+	hash fuzzy.SSDeep
+}
+
+type tlshSig struct {
+	item *HDBItem
+	hash fuzzy.TLSH
+}
+
+// addFuzzy adds the fuzzy signature item to idx. If the same hash is loaded
+// more than once, the last signature wins.
+func (idx *index) addFuzzy(item *HDBItem) {
+	key := item.HashType + ":" + item.Hash
+	if i, ok := idx.fuzzyIndex[key]; ok {
+		idx.duplicates++
+		if item.HashType == HashTypeSSDeep {
+			idx.ssdeep[i].item = item
+		} else {
+			idx.tlsh[i].item = item
+		}
+		return
+	}
+
+	// The hash was validated by parseFuzzyLine
+	switch item.HashType {
+	case HashTypeSSDeep:
+		hash, _ := fuzzy.ParseSSDeep(item.Hash)
+		idx.fuzzyIndex[key] = len(idx.ssdeep)
+		idx.ssdeep = append(idx.ssdeep, ssdeepSig{item, hash})
+	case HashTypeTLSH:
+		hash, _ := fuzzy.ParseTLSH(item.Hash)
+		idx.fuzzyIndex[key] = len(idx.tlsh)
+		idx.tlsh = append(idx.tlsh, tlshSig{item, hash})
+	}
+}
+
+// A FuzzyMatch is a fuzzy signature similar to a scanned file.
+type FuzzyMatch struct {
+	Item *HDBItem
+
+	// The ssdeep similarity score from 0 to 100, higher meaning more similar,
+	// or the TLSH distance, lower meaning more similar
+	Score int
+}
+
+// HasFuzzySigs reports whether any fuzzy signatures are loaded. If not,
+// there is no need to compute fuzzy hashes of scanned files.
+func (db *DB) HasFuzzySigs() bool {
+	idx := db.current()
+	return len(idx.ssdeep)+len(idx.tlsh) > 0
+}
+
+// MatchSSDeep returns the ssdeep signature most similar to the ssdeep hash,
+// or nil if no signature has a score of at least minScore.
+func (db *DB) MatchSSDeep(hash string, minScore int) (*FuzzyMatch, error) {
+	h, err := fuzzy.ParseSSDeep(hash)
+	if err != nil {
+		return nil, err
+	}
+	var best *FuzzyMatch
+	for _, sig := range db.current().ssdeep {
+		score := fuzzy.CompareSSDeep(h, sig.hash)
+		if score >= minScore && score > 0 && (best == nil || score > best.Score) {
+			best = &FuzzyMatch{Item: sig.item, Score: score}
+		}
+	}
+	return best, nil
+}
+
+// MatchTLSH returns the TLSH signature nearest to the TLSH hash, or nil if
+// no signature is within maxDistance.
+func (db *DB) MatchTLSH(hash string, maxDistance int) (*FuzzyMatch, error) {
+	h, err := fuzzy.ParseTLSH(hash)
+	if err != nil {
+		return nil, err
+	}
+	var best *FuzzyMatch
+	for _, sig := range db.current().tlsh {
+		distance := fuzzy.DiffTLSH(h, sig.hash)
+		if distance <= maxDistance && (best == nil || distance < best.Score) {
+			best = &FuzzyMatch{Item: sig.item, Score: distance}
+		}
+	}
+	return best, nil
+}
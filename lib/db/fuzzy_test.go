@@ -0,0 +1,102 @@
+package db
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Hashes of the README of python-ssdeep, whose ssdeep score is 22 and whose
+// TLSH distance is 48.
+const (
+	ssdeepCtph = "3:AXGBicFlgVNhBGcL6wCrFQEv:AXGHsNhxLsr2C"
+	ssdeepCTPH = "3:AXGBicFlIHBGcL6wCrFQEv:AXGH6xLsr2C"
+	tlshCtph   = "T1A2A022A3CC0FB00C8C0222228B82082A8E02E0F2C28002A8CC0CAC0E022023E00C30F0"
+	tlshCTPH   = "T1FFA022E38E0BA80A8C0032238382002A8E3AC0BAC28022A8CA0C2E0F020023F00C38F0"
+)
+
+func TestFuzzyLine(t *testing.T) {
+	tests := []struct {
+		name, comment string
+		wantErr       bool
+	}{
+		{"Test.Fuzzy", "", false},
+		{"Test.Fuzzy", "comment, with commas", false},
+		{"Test,Fuzzy", "", true},
+		{"Test.Fuzzy\n", "", true},
+		{"Test.Fuzzy", "comment\r\nssdeep," + ssdeepCTPH + ",Injected", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+tt.comment, func(t *testing.T) {
+			line, err := FuzzyLine(HashTypeSSDeep, ssdeepCtph, tt.name, tt.comment)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var item HDBItem
+			if err := parseFuzzyLine(line, &item); err != nil {
+				t.Fatal(err)
+			}
+			if item.Hash != ssdeepCtph || item.MalwareName != tt.name || item.Comment != tt.comment {
+				t.Errorf("%q parsed as %+v", line, item)
+			}
+		})
+	}
+}
+
+// A threshold of 0 matches every ssdeep signature with a score above 0, and
+// only identical TLSH hashes.
+func TestMatchFuzzy(t *testing.T) {
+	var lines []string
+	for _, sig := range [][2]string{{HashTypeSSDeep, ssdeepCtph}, {HashTypeTLSH, tlshCtph}} {
+		line, err := FuzzyLine(sig[0], sig[1], "Test.Fuzzy", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "test.fuzzy"), strings.Join(lines, "\n")+"\n")
+	database := &DB{Path: root}
+	if err := database.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.LoadSigs(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		match     func(hash string, threshold int) (*FuzzyMatch, error)
+		hash      string
+		threshold int
+		// Expected score, or -1 for no match
+		want int
+	}{
+		{"ssdeep at threshold", database.MatchSSDeep, ssdeepCTPH, 22, 22},
+		{"ssdeep above threshold", database.MatchSSDeep, ssdeepCTPH, 23, -1},
+		{"ssdeep threshold 0", database.MatchSSDeep, ssdeepCTPH, 0, 22},
+		{"ssdeep unrelated", database.MatchSSDeep, "3:abc:def", 0, -1},
+		{"TLSH at threshold", database.MatchTLSH, tlshCTPH, 48, 48},
+		{"TLSH below threshold", database.MatchTLSH, tlshCTPH, 47, -1},
+		{"TLSH threshold 0", database.MatchTLSH, tlshCTPH, 0, -1},
+		{"TLSH identical", database.MatchTLSH, tlshCtph, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := tt.match(tt.hash, tt.threshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := -1
+			if match != nil {
+				got = match.Score
+			}
+			if got != tt.want {
+				t.Errorf("got score %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
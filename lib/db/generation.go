@@ -0,0 +1,379 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Installed databases are kept as numbered generations below the database
+// folder, with a symbolic link pointing at the active one:
+//
+//	<root>/generations/1/...
+//	<root>/generations/2/...
+//	<root>/current -> generations/2
+//
+// A database folder without the link is a plain folder of signature files,
+// possibly shared with other tools such as freshclam. It is only converted to
+// use generations by MigrateToGenerations.
+const (
+	generationsDir = "generations"
+	currentLink    = "current"
+)
+
+// ErrNoGeneration is returned when a requested generation does not exist.
+var ErrNoGeneration = errors.New("no such generation")
+
+// ErrPlainFolder is returned when a generation is installed into a plain
+// database folder that contains files, which must be migrated first.
+var ErrPlainFolder = errors.New("database folder does not use generations")
+
+// A Generation is an installed version of a database.
+type Generation struct {
+	Number int
+
+	// Path to the folder containing the database files of the generation
+	Path string
+
+	// True if this is the generation that is loaded by LoadSigs
+	Active bool
+
+	// When the generation was installed
+	Installed time.Time
+
+	// The manifest of the generation, or nil if it has none
+	Manifest *Manifest
+}
+
+// ActivePath returns the folder containing the active database files of the
+// database folder root. This is root itself if it does not use generations.
+func ActivePath(root string) string {
+	if n, err := ActiveGeneration(root); err == nil && n > 0 {
+		return generationPath(root, n)
+	}
+	return root
+}
+
+// ActiveGeneration returns the number of the active generation of the
+// database folder root, or 0 if it does not use generations.
+func ActiveGeneration(root string) (int, error) {
+	target, err := os.Readlink(filepath.Join(root, currentLink))
+	if isNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(filepath.Base(target))
+	if err != nil {
+		return 0, fmt.Errorf("%s points at %s, which is not a generation", currentLink, target)
+	}
+	return n, nil
+}
+
+// Generations returns every installed generation of the database folder
+// root, oldest first.
+func Generations(root string) ([]Generation, error) {
+	entries, err := os.ReadDir(filepath.Join(root, generationsDir))
+	if isNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	active, err := ActiveGeneration(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var gens []Generation
+	for _, entry := range entries {
+		n, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		gen := Generation{
+			Number:    n,
+			Path:      generationPath(root, n),
+			Active:    n == active,
+			Installed: info.ModTime(),
+		}
+		if m, err := ReadManifest(gen.Path); err == nil {
+			gen.Manifest = m
+		}
+		gens = append(gens, gen)
+	}
+	slices.SortFunc(gens, func(a, b Generation) int { return a.Number - b.Number })
+	return gens, nil
+}
+
+// StageGeneration creates a staging folder inside the database folder root
+// that is pre-populated with the files of the active database. Files in the
+// staging folder must be replaced by renaming rather than modified in place,
+// as they may be hard links to the active files.
+//
+// The staging folder is turned into a new generation with InstallGeneration.
+// It should be removed by the caller if it is not installed.
+func StageGeneration(root string) (string, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", err
+	}
+	staging, err := os.MkdirTemp(root, ".staging-*")
+	if err != nil {
+		return "", err
+	}
+
+	active := ActivePath(root)
+	err = filepath.Walk(active, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(active, path)
+		if err != nil {
+			return err
+		}
+		// A plain database folder also contains the staging folder, which
+		// doesn't belong to the active database
+		if active == root && path != root && filepath.Dir(rel) == "." && isFolderEntry(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if isHiddenDir(active, path) {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(staging, rel), 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return linkOrCopy(path, filepath.Join(staging, rel))
+	})
+	if err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+	return staging, nil
+}
+
+// InstallGeneration moves the staging folder into the database folder root
+// as a new generation and makes it the active one. Only the newest keep
+// generations, and always the active one, are retained. If keep is 0 or less,
+// every generation is retained.
+//
+// A plain database folder that contains files is left untouched and
+// ErrPlainFolder is returned, unless it was converted with
+// MigrateToGenerations, see PrepareGenerations.
+func InstallGeneration(root, staging string, keep int) (int, error) {
+	plain, err := plainFiles(root)
+	if err != nil {
+		return 0, err
+	}
+	if len(plain) > 0 {
+		return 0, fmt.Errorf("%w: %s", ErrPlainFolder, root)
+	}
+	gens, err := Generations(root)
+	if err != nil {
+		return 0, err
+	}
+
+	next := 1
+	if len(gens) > 0 {
+		next = gens[len(gens)-1].Number + 1
+	}
+	if err := os.MkdirAll(filepath.Join(root, generationsDir), 0755); err != nil {
+		return 0, err
+	}
+	if err := os.Chmod(staging, 0755); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(staging, generationPath(root, next)); err != nil {
+		return 0, err
+	}
+	if err := Activate(root, next); err != nil {
+		return 0, err
+	}
+	return next, pruneGenerations(root, keep)
+}
+
+// Activate atomically points the database folder root at the generation n.
+func Activate(root string, n int) error {
+	info, err := os.Stat(generationPath(root, n))
+	if isNotExist(err) || (err == nil && !info.IsDir()) {
+		return fmt.Errorf("%w: %d", ErrNoGeneration, n)
+	}
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(root, fmt.Sprintf(".%s-%d", currentLink, time.Now().UnixNano()))
+	if err := os.Symlink(filepath.Join(generationsDir, strconv.Itoa(n)), tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(root, currentLink)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Rollback activates the generation n of the database folder root. If n is
+// 0, the newest generation older than the active one is activated. The number
+// of the activated generation is returned.
+func Rollback(root string, n int) (int, error) {
+	if n == 0 {
+		active, err := ActiveGeneration(root)
+		if err != nil {
+			return 0, err
+		}
+		gens, err := Generations(root)
+		if err != nil {
+			return 0, err
+		}
+		for _, gen := range gens {
+			if gen.Number < active {
+				n = gen.Number
+			}
+		}
+		if n == 0 {
+			return 0, fmt.Errorf("%w: there is no generation older than %d", ErrNoGeneration, active)
+		}
+	}
+	return n, Activate(root, n)
+}
+
+func generationPath(root string, n int) string {
+	return filepath.Join(root, generationsDir, strconv.Itoa(n))
+}
+
+// pruneGenerations removes all but the newest keep generations of root. The
+// active generation is never removed.
+func pruneGenerations(root string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	gens, err := Generations(root)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(gens)-keep; i++ {
+		if gens[i].Active {
+			continue
+		}
+		if err := os.RemoveAll(gens[i].Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isFolderEntry reports whether the entry name at the top of the database
+// folder root belongs to the folder rather than to the database in it: the
+// generations, the link to the active one, hidden entries such as staging
+// folders, and known-good indexes, which are shared by every generation.
+func isFolderEntry(name string) bool {
+	return name == generationsDir || name == currentLink || strings.HasPrefix(name, ".") || isKnownFile(name)
+}
+
+// MigrateToGenerations moves the files and folders of a plain database folder,
+// including its manifest, into its first generation. Known-good indexes stay
+// in root. It does nothing if root already uses generations or contains no
+// files.
+//
+// Other programs writing to root, such as freshclam, keep writing to it
+// rather than to the active generation, and their files are no longer
+// loaded. Only migrate folders that are managed by goava alone.
+func MigrateToGenerations(root string) error {
+	files, err := plainFiles(root)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	first := generationPath(root, 1)
+	if err := os.MkdirAll(first, 0755); err != nil {
+		return err
+	}
+	for _, name := range files {
+		if err := os.Rename(filepath.Join(root, name), filepath.Join(first, name)); err != nil {
+			return err
+		}
+	}
+	return Activate(root, 1)
+}
+
+// PrepareGenerations creates the database folder root if needed, and
+// migrates it with MigrateToGenerations if migrate is set. Otherwise
+// ErrPlainFolder is returned if root needs migrating, so that callers fail
+// before staging a new generation.
+func PrepareGenerations(root string, migrate bool) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	if migrate {
+		return MigrateToGenerations(root)
+	}
+	if plain, err := plainFiles(root); err != nil || len(plain) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w: %s", ErrPlainFolder, root)
+}
+
+// plainFiles returns the names of the files and folders of root that
+// MigrateToGenerations moves, which are none if root uses generations or
+// does not exist.
+func plainFiles(root string) ([]string, error) {
+	if _, err := os.Lstat(filepath.Join(root, currentLink)); err == nil {
+		return nil, nil
+	} else if !isNotExist(err) {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(root)
+	if isNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if (entry.Type().IsRegular() || entry.IsDir()) && !isFolderEntry(entry.Name()) {
+			files = append(files, entry.Name())
+		}
+	}
+	return files, nil
+}
+
+// linkOrCopy hard links src to dst, falling back to copying if the file
+// system does not support hard links.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	teamHash = "44d88612fea8a8f36de82e1278abb02f"
+	topHash  = "b026324c6904b2a9cb4b88d6d61c81d1"
+	newHash  = "26ab0db90d72e28ad0ba1e22ee510510"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// hasHashes loads the database folder root and reports which of hashes it
+// has signatures for.
+func hasHashes(t *testing.T, root string, hashes ...string) map[string]bool {
+	t.Helper()
+	database := &DB{Path: root}
+	if err := database.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.LoadSigs(); err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for _, h := range hashes {
+		ok, err := database.HasSigWithHash(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found[h] = ok
+	}
+	return found
+}
+
+// Signature files in folders of a migrated plain database folder are carried
+// over into the generations, like the ones at its top.
+func TestInstallGenerationNested(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "top.hdb"), topHash+":2:Test.Top\n")
+	writeFile(t, filepath.Join(root, "mdb", "team", "team.hdb"), teamHash+":68:Test.Team\n")
+	// Left over by an interrupted update, not part of the database
+	writeFile(t, filepath.Join(root, ".staging-old", "stale.hdb"), newHash+":2:Test.Stale\n")
+
+	before := hasHashes(t, root, teamHash, topHash)
+	if !before[teamHash] || !before[topHash] {
+		t.Fatalf("plain folder: got %v, want both signatures", before)
+	}
+
+	if err := MigrateToGenerations(root); err != nil {
+		t.Fatal(err)
+	}
+	staging, err := StageGeneration(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(staging, "mdb", "team", "team.hdb")); err != nil {
+		t.Errorf("nested signature file not staged: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(staging, ".staging-old")); err == nil {
+		t.Error("staging folder staged into the next generation")
+	}
+	writeFile(t, filepath.Join(staging, "new.hdb"), newHash+":2:Test.New\n")
+	gen, err := InstallGeneration(root, staging, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gen != 2 {
+		t.Fatalf("installed generation %d, want 2", gen)
+	}
+
+	after := hasHashes(t, root, teamHash, topHash, newHash)
+	for _, h := range []string{teamHash, topHash, newHash} {
+		if !after[h] {
+			t.Errorf("signature %s lost by the update", h)
+		}
+	}
+	// The first generation holds the whole former database
+	if _, err := os.Stat(filepath.Join(generationPath(root, 1), "mdb", "team", "team.hdb")); err != nil {
+		t.Errorf("nested signature file not migrated: %v", err)
+	}
+	for _, orphan := range []string{"mdb", "top.hdb"} {
+		if _, err := os.Stat(filepath.Join(root, orphan)); err == nil {
+			t.Errorf("%s left at the top of the database folder", orphan)
+		}
+	}
+}
+
+// A plain database folder with files in it, which other tools may be writing
+// to, is only converted to use generations on request.
+func TestInstallGenerationPlainFolder(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		migrate bool
+		wantErr error
+		// Expected location of the files afterwards
+		wantGen int
+	}{
+		{name: "plain", files: []string{"main.cvd", "freshclam.dat"}, wantErr: ErrPlainFolder},
+		{name: "migrate", files: []string{"main.cvd", "freshclam.dat"}, migrate: true, wantGen: 1},
+		{name: "empty"},
+		{name: "known-good index only", files: []string{"nsrl" + KnownSuffix}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, name := range tt.files {
+				writeFile(t, filepath.Join(root, name), "data\n")
+			}
+
+			err := PrepareGenerations(root, tt.migrate)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PrepareGenerations: got error %v, want %v", err, tt.wantErr)
+			}
+			staging := t.TempDir()
+			writeFile(t, filepath.Join(staging, "new.hdb"), newHash+":2:Test.New\n")
+			gen, err := InstallGeneration(root, staging, 0)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("InstallGeneration: got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && gen != tt.wantGen+1 {
+				t.Errorf("installed generation %d, want %d", gen, tt.wantGen+1)
+			}
+
+			for _, name := range tt.files {
+				dir := root
+				if tt.wantGen > 0 {
+					dir = generationPath(root, tt.wantGen)
+				}
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Errorf("%s moved: %v", name, err)
+				}
+			}
+			if tt.wantErr != nil {
+				if _, err := os.Lstat(filepath.Join(root, generationsDir)); err == nil {
+					t.Error("generations created in a plain folder")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// docParserForPath returns the docParser for the threat-intel feed at path,
+// or nil if the file is not a supported feed. A compression suffix is
+// ignored.
+//
+//   - .stix and .stix.json files are STIX 2.1 bundles, see parseSTIX
+//   - .misp and .misp.json files are MISP events, see parseMISP
+//   - .ioc files are plain lists of hashes, see parseIOCList
+func docParserForPath(path string) docParser {
+	path, _ = splitCompression(path)
+	name := strings.ToLower(filepath.Base(path))
+	switch {
+	case strings.HasSuffix(name, ".stix"), strings.HasSuffix(name, ".stix.json"):
+		return parseSTIX
+	case strings.HasSuffix(name, ".misp"), strings.HasSuffix(name, ".misp.json"):
+		return parseMISP
+	case strings.HasSuffix(name, ".ioc"):
+		return parseIOCList
+	}
+	return nil
+}
+
+// intelHashType maps the hash algorithm names used by threat-intel formats
+// to the hash types of signatures. Algorithms that can't be scanned for are
+// missing.
+func intelHashType(algorithm string) string {
+	switch strings.ToLower(strings.ReplaceAll(algorithm, "-", "")) {
+	case "md5":
+		return "md5"
+	case "sha1":
+		return "sha1"
+	case "sha256":
+		return "sha256"
+	}
+	return ""
+}
+
+// newIntelItem returns a signature for a hash from a threat-intel feed, or
+// false if the hash does not match its hash type.
+func newIntelItem(hashType, hash string, size int, name, comment string) (HDBItem, bool) {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if hashTypeFromLen(len(hash)) != hashType || !isHex(hash) {
+		return HDBItem{}, false
+	}
+	return HDBItem{
+		Hash:        hash,
+		HashType:    hashType,
+		Filesize:    size,
+		MalwareName: name,
+		Comment:     comment,
+	}, true
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// A stixObject holds the fields of the STIX 2.1 objects used by parseSTIX.
+type stixObject struct {
+	Type               string `json:"type"`
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	Pattern            string `json:"pattern"`
+	PatternType        string `json:"pattern_type"`
+	RelationshipType   string `json:"relationship_type"`
+	SourceRef          string `json:"source_ref"`
+	TargetRef          string `json:"target_ref"`
+	ExternalReferences []struct {
+		SourceName string `json:"source_name"`
+		ExternalID string `json:"external_id"`
+	} `json:"external_references"`
+}
+
+var (
+	stixHashRe = regexp.MustCompile(`file:hashes\.(?:'([^']+)'|"([^"]+)"|([A-Za-z0-9-]+))\s*=\s*'([^']*)'`)
+	stixSizeRe = regexp.MustCompile(`file:size\s*=\s*(\d+)`)
+)
+
+// parseSTIX decodes the file hash indicators of a STIX 2.1 bundle.
+//
+// Every MD5, SHA-1 and SHA-256 hash compared against in the pattern of an
+// indicator becomes a signature. If the same observation expression also
+// compares file:size, the size is used, otherwise it is unknown.
+//
+// The signature is named after the indicator, or the malware it indicates if
+// the indicator has no name. The comment holds the ID of the indicator and
+// the IDs of its external references.
+func parseSTIX(r io.Reader, _ string) ([]HDBItem, error) {
+	var bundle struct {
+		Type    string       `json:"type"`
+		Objects []stixObject `json:"objects"`
+	}
+	if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+		return nil, err
+	}
+	if bundle.Type != "bundle" {
+		return nil, fmt.Errorf("not a STIX bundle")
+	}
+
+	// Names of the malware indicated by each indicator
+	names := make(map[string]string)
+	for _, obj := range bundle.Objects {
+		if obj.Type == "malware" {
+			names[obj.ID] = obj.Name
+		}
+	}
+	indicates := make(map[string]string)
+	for _, obj := range bundle.Objects {
+		if obj.Type == "relationship" && obj.RelationshipType == "indicates" && names[obj.TargetRef] != "" {
+			indicates[obj.SourceRef] = names[obj.TargetRef]
+		}
+	}
+
+	var items []HDBItem
+	for _, obj := range bundle.Objects {
+		if obj.Type != "indicator" || (obj.PatternType != "" && obj.PatternType != "stix") {
+			continue
+		}
+		name := obj.Name
+		if name == "" {
+			name = indicates[obj.ID]
+		}
+		if name == "" {
+			name = obj.ID
+		}
+		refs := []string{obj.ID}
+		for _, ref := range obj.ExternalReferences {
+			if ref.ExternalID != "" {
+				refs = append(refs, ref.SourceName+":"+ref.ExternalID)
+			}
+		}
+		comment := strings.Join(refs, " ")
+
+		// Observation expressions are delimited by brackets
+		for _, expr := range strings.Split(obj.Pattern, "[") {
+			size := -1
+			if m := stixSizeRe.FindStringSubmatch(expr); m != nil {
+				if n, err := strconv.Atoi(m[1]); err == nil {
+					size = n
+				}
+			}
+			for _, m := range stixHashRe.FindAllStringSubmatch(expr, -1) {
+				algorithm := m[1] + m[2] + m[3]
+				hashType := intelHashType(algorithm)
+				if hashType == "" {
+					continue
+				}
+				if item, ok := newIntelItem(hashType, m[4], size, name, comment); ok {
+					items = append(items, item)
+				}
+			}
+		}
+	}
+	return items, nil
+}
+
+// A mispEvent holds the fields of a MISP event used by parseMISP.
+type mispEvent struct {
+	ID        string          `json:"id"`
+	UUID      string          `json:"uuid"`
+	Info      string          `json:"info"`
+	Attribute []mispAttribute `json:"Attribute"`
+	Object    []struct {
+		Name      string          `json:"name"`
+		UUID      string          `json:"uuid"`
+		Attribute []mispAttribute `json:"Attribute"`
+	} `json:"Object"`
+}
+
+type mispAttribute struct {
+	UUID           string `json:"uuid"`
+	Type           string `json:"type"`
+	ObjectRelation string `json:"object_relation"`
+	Value          string `json:"value"`
+	ToIDS          *bool  `json:"to_ids"`
+}
+
+// hash returns the hash type and hash of a, or an empty hash type if a is
+// not a supported hash. Composite attributes such as filename|md5 are
+// supported.
+func (a *mispAttribute) hash() (string, string) {
+	typ, value := a.Type, a.Value
+	if prefix, rest, ok := strings.Cut(typ, "|"); ok && prefix == "filename" {
+		typ = rest
+		if _, v, ok := strings.Cut(value, "|"); ok {
+			value = v
+		}
+	}
+	return intelHashType(typ), value
+}
+
+// parseMISP decodes the file hash attributes of MISP events. A single event,
+// a list of events and the response of the MISP search API are supported.
+//
+// Attributes of type md5, sha1 and sha256, or filename|md5 and so on, become
+// signatures, unless they are explicitly not meant for detection (to_ids is
+// false). Hashes in a file object take their size from its size-in-bytes
+// attribute.
+//
+// The signature is named after the info of the event. The comment holds the
+// UUIDs of the event and the attribute.
+func parseMISP(r io.Reader, _ string) ([]HDBItem, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+	type wrapper struct {
+		Event *mispEvent `json:"Event"`
+	}
+	var events []*mispEvent
+	var single wrapper
+	var list []wrapper
+	var response struct {
+		Response []wrapper `json:"response"`
+	}
+	switch {
+	case json.Unmarshal(raw, &single) == nil && single.Event != nil:
+		events = append(events, single.Event)
+	case json.Unmarshal(raw, &list) == nil:
+		for _, w := range list {
+			if w.Event != nil {
+				events = append(events, w.Event)
+			}
+		}
+	case json.Unmarshal(raw, &response) == nil && response.Response != nil:
+		for _, w := range response.Response {
+			if w.Event != nil {
+				events = append(events, w.Event)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("not a MISP event")
+	}
+
+	var items []HDBItem
+	add := func(event *mispEvent, attrs []mispAttribute, size int) {
+		for _, a := range attrs {
+			if a.ToIDS != nil && !*a.ToIDS {
+				continue
+			}
+			hashType, hash := a.hash()
+			if hashType == "" {
+				continue
+			}
+			name := event.Info
+			if name == "" {
+				name = "MISP.Event." + event.ID
+			}
+			comment := strings.TrimSpace("misp:" + event.UUID + " " + a.UUID)
+			if item, ok := newIntelItem(hashType, hash, size, name, comment); ok {
+				items = append(items, item)
+			}
+		}
+	}
+	for _, event := range events {
+		add(event, event.Attribute, -1)
+		for _, obj := range event.Object {
+			size := -1
+			for _, a := range obj.Attribute {
+				if a.ObjectRelation == "size-in-bytes" {
+					if n, err := strconv.Atoi(a.Value); err == nil {
+						size = n
+					}
+				}
+			}
+			add(event, obj.Attribute, size)
+		}
+	}
+	return items, nil
+}
+
+// parseIOCList decodes a plain list of hashes, one per line. Empty lines and
+// lines starting with # are skipped.
+//
+// A line may hold a name and a comment after the hash, separated by commas
+// or tabs. Hashes without a name are named IOC.<file name>, e.g. IOC.apt42
+// for apt42.ioc. The size of the signatures is unknown.
+func parseIOCList(r io.Reader, path string) ([]HDBItem, error) {
+	base, _ := splitCompression(filepath.Base(path))
+	defaultName := "IOC." + strings.TrimSuffix(base, filepath.Ext(base))
+
+	var items []HDBItem
+	scanner := newLineScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(strings.ReplaceAll(line, "\t", ","), ",", 3)
+		hash := strings.TrimSpace(fields[0])
+		name, comment := defaultName, ""
+		if len(fields) > 1 && strings.TrimSpace(fields[1]) != "" {
+			name = strings.TrimSpace(fields[1])
+		}
+		if len(fields) > 2 {
+			comment = strings.TrimSpace(fields[2])
+		}
+		hashType := hashTypeFromLen(len(hash))
+		item, ok := newIntelItem(hashType, hash, -1, name, comment)
+		if hashType == "" || !ok {
+			return nil, fmt.Errorf("line %d: invalid hash %q", lineNum, hash)
+		}
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The threat-intel feeds of testdata/intel load end to end. Most of their
+// hashes have no size, and are only loaded if UnknownSizeAction allows it.
+func TestLoadIntel(t *testing.T) {
+	type sig struct {
+		hash  string
+		name  string
+		sized bool
+	}
+	tests := []struct {
+		file string
+		sigs []sig
+		// Hashes that must not be loaded
+		skipped []string
+	}{
+		{
+			file: "feed.stix.json",
+			sigs: []sig{
+				{"11111111111111111111111111111111", "Stix.Family", false},
+				{"2222222222222222222222222222222222222222222222222222222222222222", "Stix.Sized", true},
+			},
+		},
+		{
+			file: "event.misp.json",
+			sigs: []sig{
+				{"3333333333333333333333333333333333333333", "Misp.Event", false},
+				{"55555555555555555555555555555555", "Misp.Event", true},
+			},
+			// Not meant for detection
+			skipped: []string{"44444444444444444444444444444444"},
+		},
+		{
+			file: "list.ioc",
+			sigs: []sig{
+				{"66666666666666666666666666666666", "Ioc.Named", false},
+				{"7777777777777777777777777777777777777777", "IOC.list", false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		for _, action := range []int{0, 1, 2} {
+			t.Run(fmt.Sprintf("%s/unknown size %d", tt.file, action), func(t *testing.T) {
+				data, err := os.ReadFile(filepath.Join("testdata", "intel", tt.file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				root := t.TempDir()
+				writeFile(t, filepath.Join(root, tt.file), string(data))
+
+				database := &DB{Path: root, UnknownSizeAction: action}
+				if err := database.Init(); err != nil {
+					t.Fatal(err)
+				}
+				if err := database.LoadSigs(); err != nil {
+					t.Fatal(err)
+				}
+				for _, s := range tt.sigs {
+					want := s.sized || action != 0
+					if ok, _ := database.HasSigWithHash(s.hash); ok != want {
+						t.Errorf("HasSigWithHash(%s) = %v, want %v", s.hash, ok, want)
+						continue
+					}
+					if !want {
+						continue
+					}
+					if item, err := database.GetItemByHash(s.hash); err != nil || item.MalwareName != s.name {
+						t.Errorf("%s is named %v (%v), want %s", s.hash, item, err, s.name)
+					}
+				}
+				for _, hash := range tt.skipped {
+					if ok, _ := database.HasSigWithHash(hash); ok {
+						t.Errorf("%s loaded", hash)
+					}
+				}
+			})
+		}
+	}
+}
+
+// With UnknownSizeAction 2, signatures of unknown size are only loaded from
+// threat-intel feeds.
+func TestUnknownSizeIntel(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.hsb"), teamHash+":*:Test.Team:73\n")
+	writeFile(t, filepath.Join(root, "feed.ioc"), topHash+"\n")
+
+	database := &DB{Path: root, UnknownSizeAction: 2}
+	if err := database.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.LoadSigs(); err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, h := range []string{teamHash, topHash} {
+		found[h], _ = database.HasSigWithHash(h)
+	}
+	if found[teamHash] || !found[topHash] {
+		t.Errorf("got %v, want only the hash of the feed", found)
+	}
+	if stats := database.GetHDBStats(); stats.Ignored != 1 {
+		t.Errorf("got %d ignored signatures, want 1", stats.Ignored)
+	}
+}
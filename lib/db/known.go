@@ -0,0 +1,151 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// KnownSuffix is the extension of known-good index files. Known-good indexes
+// are read from the top level of a database folder, outside of its
+// generations, so they survive updates and bundle imports.
+const KnownSuffix = ".kgi"
+
+// Layout of a known-good index file: a header, a fanout table and the sorted
+// md5 hashes.
+//
+// The fanout table holds for each value of the first two bytes of a hash the
+// number of hashes that start with that value or a lower one, which narrows
+// every lookup down to a few hundred hashes even for tens of millions of
+// entries.
+const (
+	knownMagic      = "GOAVAKGI"
+	knownVersion    = 1
+	knownHashSize   = 16
+	knownFanout     = 1 << 16
+	knownHeaderSize = len(knownMagic) + 4 + 4 + 8
+	knownTableSize  = knownFanout * 8
+)
+
+// A KnownIndex is an on-disk set of md5 hashes of files known to be clean,
+// such as those published by the NIST National Software Reference Library.
+//
+// Hashes are looked up directly in the file, so an index with tens of
+// millions of entries only costs its fanout table in memory. A KnownIndex is
+// safe for concurrent use.
+type KnownIndex struct {
+	path   string
+	f      *os.File
+	count  uint64
+	fanout []uint64
+}
+
+// OpenKnownIndex opens the known-good index file at path.
+func OpenKnownIndex(path string) (*KnownIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	k := &KnownIndex{path: path, f: f}
+	if err := k.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return k, nil
+}
+
+func (k *KnownIndex) readHeader() error {
+	br := bufio.NewReader(io.NewSectionReader(k.f, 0, int64(knownHeaderSize+knownTableSize)))
+	var header struct {
+		Magic    [len(knownMagic)]byte
+		Version  uint32
+		HashSize uint32
+		Count    uint64
+	}
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("invalid known-good index: %w", err)
+	}
+	if string(header.Magic[:]) != knownMagic {
+		return errors.New("not a known-good index")
+	}
+	if header.Version != knownVersion || header.HashSize != knownHashSize {
+		return fmt.Errorf("unsupported known-good index version %d", header.Version)
+	}
+	k.count = header.Count
+	k.fanout = make([]uint64, knownFanout)
+	if err := binary.Read(br, binary.LittleEndian, k.fanout); err != nil {
+		return fmt.Errorf("invalid known-good index: %w", err)
+	}
+	if k.fanout[knownFanout-1] != k.count {
+		return errors.New("invalid known-good index: fanout table does not match the number of hashes")
+	}
+
+	info, err := k.f.Stat()
+	if err != nil {
+		return err
+	}
+	if want := int64(knownHeaderSize+knownTableSize) + int64(k.count)*knownHashSize; info.Size() != want {
+		return fmt.Errorf("invalid known-good index: expected %d bytes, got %d", want, info.Size())
+	}
+	return nil
+}
+
+// Path returns the path of the index file.
+func (k *KnownIndex) Path() string {
+	return k.path
+}
+
+// Len returns the number of hashes in the index.
+func (k *KnownIndex) Len() int {
+	return int(k.count)
+}
+
+// Contains reports whether the hex encoded md5 hash is in the index. Hashes
+// of other types are never in the index.
+func (k *KnownIndex) Contains(hash string) (bool, error) {
+	var want [knownHashSize]byte
+	if len(hash) != 2*knownHashSize {
+		return false, nil
+	}
+	if _, err := hex.Decode(want[:], []byte(hash)); err != nil {
+		return false, nil
+	}
+
+	bucket := int(binary.BigEndian.Uint16(want[:2]))
+	lo := uint64(0)
+	if bucket > 0 {
+		lo = k.fanout[bucket-1]
+	}
+	hi := k.fanout[bucket]
+	if lo == hi {
+		return false, nil
+	}
+
+	// The buckets are small, so they are read whole and searched in memory
+	buf := make([]byte, (hi-lo)*knownHashSize)
+	if _, err := k.f.ReadAt(buf, int64(knownHeaderSize+knownTableSize)+int64(lo)*knownHashSize); err != nil {
+		return false, fmt.Errorf("%s: %w", k.path, err)
+	}
+	n := int(hi - lo)
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(buf[i*knownHashSize:(i+1)*knownHashSize], want[:]) >= 0
+	})
+	return i < n && bytes.Equal(buf[i*knownHashSize:(i+1)*knownHashSize], want[:]), nil
+}
+
+// Close closes the index file.
+func (k *KnownIndex) Close() error {
+	return k.f.Close()
+}
+
+// isKnownFile reports whether path is a known-good index file.
+func isKnownFile(path string) bool {
+	return strings.HasSuffix(path, KnownSuffix)
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeKnown builds the known-good index path from hashes.
+func writeKnown(t *testing.T, path string, hashes ...string) {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "NSRLFile.txt")
+	writeFile(t, src, `"SHA-1","MD5","FileName"`+"\n")
+	f, err := os.OpenFile(src, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range hashes {
+		f.WriteString(`"0000000000000000000000000000000000000000","` + strings.ToUpper(h) + `","file"` + "\n")
+	}
+	f.Close()
+	if _, err := ImportNSRL(path, src); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The files of the known-good indexes are closed once Reload replaces them,
+// but not when LoadBloom shares them with the index it publishes.
+func TestReloadClosesKnownIndexes(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.hdb"), teamHash+":68:Test.Team\n")
+	writeKnown(t, filepath.Join(root, "nsrl"+KnownSuffix), topHash)
+
+	database := &DB{Path: root, UseBloom: true, BloomFalsePositiveRate: 0.01}
+	if err := database.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.LoadSigs(); err != nil {
+		t.Fatal(err)
+	}
+	old := database.current().known
+	database.LoadBloom()
+	if database.current().known != old || old.closed {
+		t.Fatal("LoadBloom replaced the known-good indexes")
+	}
+
+	if err := database.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !old.closed {
+		t.Error("known-good indexes of the replaced index not closed")
+	}
+	if _, err := old.indexes[0].Contains(topHash); err == nil {
+		t.Error("file of a replaced known-good index still open")
+	}
+	if ok, err := database.IsKnownGood(topHash); err != nil || !ok {
+		t.Errorf("IsKnownGood after Reload: %v, %v, want true", ok, err)
+	}
+	if stats := database.GetHDBStats(); stats.KnownGood != 1 {
+		t.Errorf("got %d known-good hashes, want 1", stats.KnownGood)
+	}
+}
+
+// The known-good indexes are covered by the manifest of the database folder,
+// also if it uses generations and they are kept outside of them.
+func TestManifestKnownIndexes(t *testing.T) {
+	tests := []struct {
+		name        string
+		generations bool
+		sign        bool
+		tamper      bool
+		wantErr     bool
+	}{
+		{"plain", false, true, false, false},
+		{"plain tampered", false, true, true, true},
+		{"generations", true, true, false, false},
+		{"generations unsigned", true, false, false, true},
+		{"generations tampered", true, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFile(t, filepath.Join(root, "main.hdb"), teamHash+":68:Test.Team\n")
+			if tt.generations {
+				if err := MigrateToGenerations(root); err != nil {
+					t.Fatal(err)
+				}
+				staging, err := StageGeneration(root)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := InstallGeneration(root, staging, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			active := ActivePath(root)
+			writeKnown(t, filepath.Join(root, "nsrl"+KnownSuffix), topHash)
+
+			dirs := []string{active}
+			if tt.sign && active != root {
+				dirs = append(dirs, root)
+			}
+			for _, dir := range dirs {
+				m, err := BuildManifest(dir, 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := m.Write(dir); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.sign {
+				m, err := ReadManifest(root)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := m.Files["nsrl"+KnownSuffix]; !ok || (tt.generations && len(m.Files) != 1) {
+					t.Fatalf("manifest of the database folder has %v, want only the known-good index", m.Files)
+				}
+			}
+			if tt.tamper {
+				writeKnown(t, filepath.Join(root, "nsrl"+KnownSuffix), topHash, newHash)
+			}
+
+			database := &DB{Path: root, ManifestAction: 2}
+			if err := database.Init(); err != nil {
+				t.Fatal(err)
+			}
+			err := database.LoadSigs()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if ok, _ := database.IsKnownGood(topHash); !ok {
+					t.Error("known-good index not loaded")
+				}
+			}
+		})
+	}
+}
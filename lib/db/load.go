@@ -0,0 +1,213 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// A sigFile is a signature file found by LoadSigs.
+type sigFile struct {
+	path string
+
+	// The database folder the file belongs to
+	root string
+
+	// Path relative to the database folder
+	source string
+
+	// Exactly one of parse and decode is set, depending on whether the file
+	// is line based
+	parse  lineParser
+	decode docParser
+}
+
+// A fileResult holds the signatures parsed from a single file.
+type fileResult struct {
+	items []HDBItem
+
+	wildcardSizes  int
+	ignored        int
+	filtered       int
+	sizeAlwaysTrue bool
+
+	err error
+}
+
+// loadFiles parses files concurrently and merges the results into idx in the
+// order of files. If a hash is loaded more than once, the last signature wins.
+// Signatures not allowed by filter are dropped.
+func (db *DB) loadFiles(idx *index, files []sigFile, filter *sigFilter) error {
+	workers := db.LoadWorkers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(files))
+
+	results := make([]fileResult, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = db.parseFile(files[i], filter)
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	total := 0
+	for _, res := range results {
+		if res.err != nil {
+			return res.err
+		}
+		total += len(res.items)
+	}
+
+	idx.hashes = slices.Grow(idx.hashes, total)
+	idx.sizes = slices.Grow(idx.sizes, total)
+	idx.hashToItem = make(map[string]*HDBItem, total)
+	idx.hashTypes = make(map[string]bool)
+	idx.fuzzyIndex = make(map[string]int)
+
+	for i := range results {
+		res := &results[i]
+		idx.wildcardSizes += res.wildcardSizes
+		idx.ignored += res.ignored
+		idx.filtered += res.filtered
+		idx.sizeAlwaysTrue = idx.sizeAlwaysTrue || res.sizeAlwaysTrue
+		for j := range res.items {
+			item := &res.items[j]
+			if isFuzzyType(item.HashType) {
+				idx.addFuzzy(item)
+				continue
+			}
+			if _, ok := idx.hashToItem[item.Hash]; ok {
+				idx.duplicates++
+			} else {
+				idx.hashes = append(idx.hashes, item.Hash)
+			}
+			idx.hashToItem[item.Hash] = item
+			// Lookups are by hash only, so the type is the one of the hash
+			idx.hashTypes[hashTypeFromLen(len(item.Hash))] = true
+		}
+	}
+
+	// Only the sizes of the signatures that won are looked up, so a
+	// redefined hash doesn't keep matching its old size
+	for _, item := range idx.hashToItem {
+		idx.sizes = append(idx.sizes, item.Filesize)
+	}
+	return nil
+}
+
+// parseFile streams the signature file f line by line into a single buffer
+// of items, which is sized from the file size to avoid regrowing it. Files
+// that are not line based are decoded as a whole. Compressed files are
+// decoded while reading.
+func (db *DB) parseFile(f sigFile, filter *sigFilter) (res fileResult) {
+	db.nl(func() { db.Logger.Printf("Loading %s", f.path) })
+	r, compressed, err := openSigFile(f.path)
+	if err != nil {
+		res.err = err
+		return
+	}
+	defer r.Close()
+
+	if f.decode != nil {
+		items, err := f.decode(r, f.path)
+		if err != nil {
+			res.err = fmt.Errorf("%s: %w", f.path, err)
+			return
+		}
+		for _, item := range items {
+			res.items = append(res.items, item)
+			db.keepLast(&res, f, filter)
+		}
+		db.logUnknownSizes(&res, f)
+		return
+	}
+
+	// Signature lines are rarely shorter than 64 bytes, and compress to
+	// roughly a quarter of that
+	if info, err := os.Stat(f.path); err == nil {
+		estimate := info.Size() / 64
+		if compressed {
+			estimate *= 4
+		}
+		res.items = make([]HDBItem, 0, estimate+1)
+	}
+
+	scanner := newLineScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if len(line) == 0 {
+			continue
+		}
+		res.items = append(res.items, HDBItem{})
+		if err := f.parse(line, &res.items[len(res.items)-1]); err != nil {
+			res.err = fmt.Errorf("%s:%d: %w", f.path, lineNum, err)
+			return
+		}
+		db.keepLast(&res, f, filter)
+	}
+	res.err = scanner.Err()
+	db.logUnknownSizes(&res, f)
+	return
+}
+
+// keepLast fills in where the last item of res was loaded from, and removes
+// it again if it is excluded by filter or has an unknown size that is
+// ignored.
+func (db *DB) keepLast(res *fileResult, f sigFile, filter *sigFilter) {
+	item := &res.items[len(res.items)-1]
+	item.Root, item.Source = f.root, f.source
+	if !filter.allows(item) {
+		res.filtered++
+		res.items = res.items[:len(res.items)-1]
+		return
+	}
+	// Fuzzy signatures are not matched by size
+	if item.Filesize == -1 && !isFuzzyType(item.HashType) {
+		res.wildcardSizes++
+		action := db.UnknownSizeAction
+		if action == 2 {
+			// Threat-intel feeds rarely have sizes
+			action = 0
+			if f.decode != nil {
+				action = 1
+			}
+		}
+		if action == 1 {
+			res.sizeAlwaysTrue = true
+		} else if action == 0 {
+			res.ignored++
+			res.items = res.items[:len(res.items)-1]
+		}
+	}
+}
+
+// logUnknownSizes logs what was done with the signatures of unknown size of
+// the file f, once per file.
+func (db *DB) logUnknownSizes(res *fileResult, f sigFile) {
+	switch {
+	case res.ignored > 0:
+		db.nl(func() {
+			db.Logger.Printf("Skipped %d signatures with unknown size in %s", res.ignored, f.path)
+		})
+	case res.sizeAlwaysTrue:
+		db.nl(func() {
+			db.Logger.Printf("%s contains %d signatures with unknown size, disabling size checks", f.path, res.wildcardSizes)
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeBenchDB writes files signature files with lines signatures each into
+// dir, alternating between the hdb and csv formats.
+func writeBenchDB(b *testing.B, dir string, files, lines int) {
+	b.Helper()
+	rng := rand.New(rand.NewSource(1))
+	for i := range files {
+		ext := ".hdb"
+		if i%2 == 1 {
+			ext = ".csv"
+		}
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("bench%d%s", i, ext)))
+		if err != nil {
+			b.Fatal(err)
+		}
+		w := bufio.NewWriter(f)
+		var buf [8]byte
+		for j := range lines {
+			rng.Read(buf[:])
+			sum := md5.Sum(buf[:])
+			hash := hex.EncodeToString(sum[:])
+			size := rng.Intn(1 << 20)
+			if ext == ".hdb" {
+				fmt.Fprintf(w, "%s:%d:Win.Trojan.Bench-%d-0\n", hash, size, j)
+			} else {
+				fmt.Fprintf(w, "%s,md5,%d,Win.Trojan.Bench-%d-0,bench\n", hash, size, j)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			b.Fatal(err)
+		}
+		f.Close()
+	}
+}
+
+func BenchmarkLoadSigs(b *testing.B) {
+	dir := b.TempDir()
+	writeBenchDB(b, dir, 8, 50000)
+
+	for _, workers := range []int{1, 0} {
+		name := fmt.Sprintf("workers=%d", workers)
+		if workers == 0 {
+			name = "workers=default"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				database := &DB{Path: dir, LoadWorkers: workers}
+				if err := database.Init(); err != nil {
+					b.Fatal(err)
+				}
+				if err := database.LoadSigs(); err != nil {
+					b.Fatal(err)
+				}
+				if n := len(database.current().hashes); n != 8*50000 {
+					b.Fatalf("loaded %d signatures", n)
+				}
+			}
+		})
+	}
+}
+
+// A hash redefined by a later folder is only looked up with its new size.
+func TestLoadRedefinedSizes(t *testing.T) {
+	vendor, local := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(vendor, "main.hdb"), teamHash+":10:Test.Old\n"+topHash+":30:Test.Top\n")
+	writeFile(t, filepath.Join(local, "local.hdb"), teamHash+":20:Test.New\n")
+	database := &DB{Path: vendor, Paths: []string{local}}
+	if err := database.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.LoadSigs(); err != nil {
+		t.Fatal(err)
+	}
+
+	for size, want := range map[int]bool{10: false, 20: true, 30: true} {
+		if ok, err := database.HasSigWithSize(size); err != nil || ok != want {
+			t.Errorf("HasSigWithSize(%d): %v, %v, want %v", size, ok, err, want)
+		}
+	}
+	if stats := database.GetHDBStats(); stats.Count != 2 || stats.Duplicates != 1 {
+		t.Errorf("got %d signatures and %d duplicates, want 2 and 1", stats.Count, stats.Duplicates)
+	}
+}
@@ -0,0 +1,236 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ManifestFile is the name of the manifest inside a database directory.
+const ManifestFile = "goava.manifest.json"
+
+// A Manifest describes the contents of a database directory.
+type Manifest struct {
+	// Version of the signature set. Higher versions are newer.
+	Version int64 `json:"version"`
+
+	// When the manifest was created
+	Created time.Time `json:"created"`
+
+	// Hex encoded SHA-256 checksum of every signature file, and of the
+	// known-good indexes at the top level, keyed by their slash separated
+	// path relative to the database directory
+	Files map[string]string `json:"files"`
+}
+
+// ManifestMismatchError is returned by Manifest.Verify if the database
+// directory does not match the manifest.
+type ManifestMismatchError struct {
+	Added    []string
+	Removed  []string
+	Modified []string
+}
+
+func (e *ManifestMismatchError) Error() string {
+	var parts []string
+	if len(e.Added) > 0 {
+		parts = append(parts, fmt.Sprintf("added: %s", strings.Join(e.Added, ", ")))
+	}
+	if len(e.Removed) > 0 {
+		parts = append(parts, fmt.Sprintf("removed: %s", strings.Join(e.Removed, ", ")))
+	}
+	if len(e.Modified) > 0 {
+		parts = append(parts, fmt.Sprintf("modified: %s", strings.Join(e.Modified, ", ")))
+	}
+	return "database does not match manifest (" + strings.Join(parts, "; ") + ")"
+}
+
+// BuildManifest checksums every signature file and known-good index in dir
+// and returns a manifest with the given version. The generations of dir are
+// not included, as each has a manifest of its own.
+func BuildManifest(dir string, version int64) (*Manifest, error) {
+	files, err := checksumDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Manifest{
+		Version: version,
+		Created: time.Now().UTC(),
+		Files:   files,
+	}, nil
+}
+
+// ReadManifest reads the manifest of the database directory dir.
+// The returned error wraps os.ErrNotExist if dir has no manifest.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	return parseManifest(data)
+}
+
+func parseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.Files == nil {
+		m.Files = make(map[string]string)
+	}
+	return &m, nil
+}
+
+// Marshal returns the JSON encoding of the manifest.
+func (m *Manifest) Marshal() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// Write writes the manifest into the database directory dir, replacing any
+// existing manifest atomically.
+func (m *Manifest) Write(dir string) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, ManifestFile), data)
+}
+
+// Verify checksums every signature file in dir and compares the result with
+// the manifest. A *ManifestMismatchError is returned if files were added,
+// removed or modified.
+func (m *Manifest) Verify(dir string) error {
+	actual, err := checksumDir(dir)
+	if err != nil {
+		return err
+	}
+	return m.compare(actual)
+}
+
+// compare returns a *ManifestMismatchError if the checksums in actual differ
+// from the ones in the manifest.
+func (m *Manifest) compare(actual map[string]string) error {
+	mismatch := &ManifestMismatchError{}
+	for name, sum := range actual {
+		expected, ok := m.Files[name]
+		if !ok {
+			mismatch.Added = append(mismatch.Added, name)
+		} else if !strings.EqualFold(expected, sum) {
+			mismatch.Modified = append(mismatch.Modified, name)
+		}
+	}
+	for name := range m.Files {
+		if _, ok := actual[name]; !ok {
+			mismatch.Removed = append(mismatch.Removed, name)
+		}
+	}
+	if len(mismatch.Added)+len(mismatch.Removed)+len(mismatch.Modified) == 0 {
+		return nil
+	}
+	slices.Sort(mismatch.Added)
+	slices.Sort(mismatch.Removed)
+	slices.Sort(mismatch.Modified)
+	return mismatch
+}
+
+// checksumDir returns the SHA-256 checksum of every signature file in dir,
+// outside of its generations, and of the known-good indexes at its top
+// level, keyed by their slash separated path relative to dir.
+func checksumDir(dir string) (map[string]string, error) {
+	dir = filepath.Clean(dir)
+	sums := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if isHiddenDir(dir, path) || path == filepath.Join(dir, generationsDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !IsSigFile(path) && !(isKnownFile(path) && filepath.Dir(path) == dir) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		sum, err := checksumFile(path)
+		if err != nil {
+			return err
+		}
+		sums[filepath.ToSlash(rel)] = sum
+		return nil
+	})
+	return sums, err
+}
+
+// checksumKnown returns the SHA-256 checksum of every known-good index at the
+// top level of dir, keyed by its name.
+func checksumKnown(dir string) (map[string]string, error) {
+	paths, err := knownPaths(dir)
+	if err != nil {
+		return nil, err
+	}
+	sums := make(map[string]string)
+	for _, path := range paths {
+		sum, err := checksumFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sums[filepath.Base(path)] = sum
+	}
+	return sums, nil
+}
+
+func checksumFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// isNotExist reports whether err means that a manifest or file is missing.
+func isNotExist(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
@@ -0,0 +1,412 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// sqliteMagic starts every SQLite database file.
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// knownChunkSize is the number of hashes sorted in memory at a time while
+// building a known-good index. Larger imports are sorted in runs that are
+// merged on disk, which keeps memory use at about 64 MiB.
+var knownChunkSize = 1 << 22
+
+// ImportNSRL builds the known-good index file dst from the NIST NSRL
+// Reference Data Sets srcs, replacing dst atomically. The number of unique
+// hashes in the index is returned.
+//
+// Both the legacy NSRLFile.txt CSV format and the RDSv3 SQLite format are
+// supported, and are told apart by their content. CSV files may be
+// compressed with gzip, bzip2 or zstd.
+//
+// Only md5 hashes are imported. Sets with tens of millions of entries are
+// sorted in bounded memory, spilling to temporary files next to dst.
+func ImportNSRL(dst string, srcs ...string) (int, error) {
+	dir := filepath.Dir(dst)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	sorter := &hashSorter{dir: dir}
+	defer sorter.cleanup()
+
+	for _, src := range srcs {
+		isSQLite, err := hasMagic(src, sqliteMagic)
+		if err != nil {
+			return 0, err
+		}
+		if isSQLite {
+			err = readRDSv3(src, sorter.add)
+		} else {
+			err = readNSRLFile(src, sorter.add)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return sorter.writeIndex(dst)
+}
+
+func hasMagic(path string, magic []byte) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	buf := make([]byte, len(magic))
+	if _, err := io.ReadFull(f, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.Equal(buf, magic), nil
+}
+
+// readNSRLFile passes the md5 hashes of the legacy NSRLFile.txt at path to
+// add. The position of the MD5 column is taken from the header line.
+func readNSRLFile(path string, add func([knownHashSize]byte) error) error {
+	r, _, err := openSigFile(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	scanner := newLineScanner(r)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("%s is empty", path)
+	}
+	column := -1
+	for i, field := range strings.Split(scanner.Text(), ",") {
+		if strings.EqualFold(strings.Trim(field, `"`), "MD5") {
+			column = i
+			break
+		}
+	}
+	if column == -1 {
+		return fmt.Errorf("%s: header has no MD5 column", path)
+	}
+
+	lineNum := 1
+	var hash [knownHashSize]byte
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if len(line) == 0 {
+			continue
+		}
+		// The columns before MD5 are hashes, so they never contain commas
+		field := line
+		for range column {
+			var ok bool
+			if _, field, ok = strings.Cut(field, ","); !ok {
+				return fmt.Errorf("%s:%d: missing MD5 column", path, lineNum)
+			}
+		}
+		field, _, _ = strings.Cut(field, ",")
+		field = strings.Trim(field, `"`)
+		if len(field) != 2*knownHashSize {
+			return fmt.Errorf("%s:%d: invalid md5 hash %q", path, lineNum, field)
+		}
+		if _, err := hex.Decode(hash[:], []byte(field)); err != nil {
+			return fmt.Errorf("%s:%d: invalid md5 hash %q", path, lineNum, field)
+		}
+		if err := add(hash); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// readRDSv3 passes the md5 hashes of the FILE table of the RDSv3 SQLite
+// database at path to add.
+func readRDSv3(path string, add func([knownHashSize]byte) error) error {
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro&immutable=1")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rows, err := conn.Query("SELECT md5 FROM FILE")
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	defer rows.Close()
+
+	var field string
+	var hash [knownHashSize]byte
+	for rows.Next() {
+		if err := rows.Scan(&field); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if len(field) != 2*knownHashSize {
+			return fmt.Errorf("%s: invalid md5 hash %q", path, field)
+		}
+		if _, err := hex.Decode(hash[:], []byte(field)); err != nil {
+			return fmt.Errorf("%s: invalid md5 hash %q", path, field)
+		}
+		if err := add(hash); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// A hashSorter sorts and deduplicates hashes with an external merge sort.
+// Hashes are collected in chunks of knownChunkSize, and full chunks are
+// written to temporary run files that are merged by writeIndex.
+type hashSorter struct {
+	dir   string
+	chunk [][knownHashSize]byte
+	runs  []string
+}
+
+func compareHashes(a, b [knownHashSize]byte) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+func (s *hashSorter) add(hash [knownHashSize]byte) error {
+	if s.chunk == nil {
+		s.chunk = make([][knownHashSize]byte, 0, knownChunkSize)
+	}
+	s.chunk = append(s.chunk, hash)
+	if len(s.chunk) < knownChunkSize {
+		return nil
+	}
+	return s.spill()
+}
+
+// sortChunk sorts and deduplicates the current chunk.
+func (s *hashSorter) sortChunk() {
+	slices.SortFunc(s.chunk, compareHashes)
+	s.chunk = slices.Compact(s.chunk)
+}
+
+// spill writes the current chunk to a new run file.
+func (s *hashSorter) spill() error {
+	s.sortChunk()
+	f, err := os.CreateTemp(s.dir, ".kgi-run-*")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f.Name())
+	w := bufio.NewWriterSize(f, 1024*1024)
+	for _, hash := range s.chunk {
+		w.Write(hash[:])
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	s.chunk = s.chunk[:0]
+	return f.Close()
+}
+
+func (s *hashSorter) cleanup() {
+	for _, run := range s.runs {
+		os.Remove(run)
+	}
+}
+
+// writeIndex writes every added hash to the known-good index file dst and
+// returns the number of unique hashes.
+func (s *hashSorter) writeIndex(dst string) (int, error) {
+	next, closeRuns, err := s.merged()
+	if err != nil {
+		return 0, err
+	}
+	defer closeRuns()
+
+	f, err := os.CreateTemp(filepath.Dir(dst), ".kgi-*")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	// The hashes are written first, leaving room for the header and fanout
+	// table, which are only known once every hash has been seen
+	if _, err = f.Seek(int64(knownHeaderSize+knownTableSize), io.SeekStart); err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriterSize(f, 1024*1024)
+	fanout := make([]uint64, knownFanout)
+	var count uint64
+	var prev [knownHashSize]byte
+	for {
+		var hash [knownHashSize]byte
+		var ok bool
+		if hash, ok, err = next(); err != nil {
+			return 0, err
+		}
+		if !ok {
+			break
+		}
+		if count > 0 && hash == prev {
+			continue
+		}
+		if _, err = w.Write(hash[:]); err != nil {
+			return 0, err
+		}
+		fanout[binary.BigEndian.Uint16(hash[:2])]++
+		prev = hash
+		count++
+	}
+	if err = w.Flush(); err != nil {
+		return 0, err
+	}
+	for i := 1; i < knownFanout; i++ {
+		fanout[i] += fanout[i-1]
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	w.Reset(f)
+	w.WriteString(knownMagic)
+	binary.Write(w, binary.LittleEndian, uint32(knownVersion))
+	binary.Write(w, binary.LittleEndian, uint32(knownHashSize))
+	binary.Write(w, binary.LittleEndian, count)
+	binary.Write(w, binary.LittleEndian, fanout)
+	if err = w.Flush(); err != nil {
+		return 0, err
+	}
+	if err = f.Chmod(0644); err != nil {
+		return 0, err
+	}
+	if err = f.Sync(); err != nil {
+		return 0, err
+	}
+	if err = f.Close(); err != nil {
+		return 0, err
+	}
+	if err = os.Rename(f.Name(), dst); err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// merged returns an iterator over every added hash in sorted order, possibly
+// with duplicates, and a function that releases the run files.
+func (s *hashSorter) merged() (func() ([knownHashSize]byte, bool, error), func(), error) {
+	s.sortChunk()
+	if len(s.runs) == 0 {
+		i := 0
+		return func() ([knownHashSize]byte, bool, error) {
+			if i == len(s.chunk) {
+				return [knownHashSize]byte{}, false, nil
+			}
+			i++
+			return s.chunk[i-1], true, nil
+		}, func() {}, nil
+	}
+
+	// The last chunk is merged from memory along with the runs
+	h := &runHeap{}
+	var files []*os.File
+	closeRuns := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	for _, run := range s.runs {
+		f, err := os.Open(run)
+		if err != nil {
+			closeRuns()
+			return nil, nil, err
+		}
+		files = append(files, f)
+		r := &hashRun{r: bufio.NewReaderSize(f, 256*1024)}
+		if err := r.advance(); err != nil {
+			closeRuns()
+			return nil, nil, err
+		}
+		if r.ok {
+			h.runs = append(h.runs, r)
+		}
+	}
+	if len(s.chunk) > 0 {
+		r := &hashRun{chunk: s.chunk}
+		r.advance()
+		h.runs = append(h.runs, r)
+	}
+	heap.Init(h)
+
+	return func() ([knownHashSize]byte, bool, error) {
+		if h.Len() == 0 {
+			return [knownHashSize]byte{}, false, nil
+		}
+		r := h.runs[0]
+		hash := r.cur
+		if err := r.advance(); err != nil {
+			return hash, false, err
+		}
+		if r.ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+		return hash, true, nil
+	}, closeRuns, nil
+}
+
+// A hashRun is a sorted sequence of hashes, read from a run file or from the
+// in-memory chunk.
+type hashRun struct {
+	r     io.Reader
+	chunk [][knownHashSize]byte
+	cur   [knownHashSize]byte
+	ok    bool
+}
+
+func (r *hashRun) advance() error {
+	if r.r == nil {
+		r.ok = len(r.chunk) > 0
+		if r.ok {
+			r.cur, r.chunk = r.chunk[0], r.chunk[1:]
+		}
+		return nil
+	}
+	_, err := io.ReadFull(r.r, r.cur[:])
+	if errors.Is(err, io.EOF) {
+		r.ok = false
+		return nil
+	}
+	r.ok = err == nil
+	return err
+}
+
+// runHeap orders runs by their current hash.
+type runHeap struct {
+	runs []*hashRun
+}
+
+func (h *runHeap) Len() int           { return len(h.runs) }
+func (h *runHeap) Less(i, j int) bool { return compareHashes(h.runs[i].cur, h.runs[j].cur) < 0 }
+func (h *runHeap) Swap(i, j int)      { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *runHeap) Push(x any)         { h.runs = append(h.runs, x.(*hashRun)) }
+func (h *runHeap) Pop() any {
+	r := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return r
+}
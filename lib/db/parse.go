@@ -0,0 +1,184 @@
+package db
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A lineParser decodes a single non-empty line of a signature file into
+// item. Signatures with an unknown size are given a Filesize of -1.
+//
+// Parsers slice the fields out of line instead of copying them, so loading a
+// line costs a single allocation.
+type lineParser func(line string, item *HDBItem) error
+
+// A docParser decodes a signature file that is not line based, such as a
+// JSON threat-intel feed, read from r. The name of the file is passed for
+// formats that derive signature names from it. Signatures with an unknown
+// size are given a Filesize of -1.
+type docParser func(r io.Reader, name string) ([]HDBItem, error)
+
+// parserForPath returns the lineParser for the signature file at path, or nil
+// if the file is not a supported signature file. A compression suffix, such
+// as the .gz of main.hdb.gz, is ignored.
+func parserForPath(path string) lineParser {
+	path, _ = splitCompression(path)
+	switch filepath.Ext(path) {
+	case ".hdb", ".hsb", ".hdu", ".hsu":
+		return parseHDBLine
+	case ".csv":
+		return parseCSVLine
+	case ".fuzzy":
+		return parseFuzzyLine
+	}
+	return nil
+}
+
+// IsSigFile reports whether path has the extension of a supported signature
+// file, optionally followed by a compression suffix.
+func IsSigFile(path string) bool {
+	return parserForPath(path) != nil || docParserForPath(path) != nil
+}
+
+// parseHDBLine decodes a line of a Clamav hash-based signature file.
+//
+// The format is HashString:FileSize:MalwareName, where FileSize may be * if
+// the size is unknown. Any further fields are ignored.
+func parseHDBLine(line string, item *HDBItem) error {
+	hash, rest, ok := strings.Cut(line, ":")
+	if !ok {
+		return fmt.Errorf("expected at least 3 fields, got 1")
+	}
+	size, rest, ok := strings.Cut(rest, ":")
+	if !ok {
+		return fmt.Errorf("expected at least 3 fields, got 2")
+	}
+	name, _, _ := strings.Cut(rest, ":")
+
+	fileSize := -1
+	if size != "*" {
+		var err error
+		if fileSize, err = strconv.Atoi(size); err != nil {
+			return err
+		}
+	}
+
+	*item = HDBItem{
+		Hash:        hash,
+		HashType:    hashTypeFromLen(len(hash)),
+		Filesize:    fileSize,
+		MalwareName: name,
+	}
+	return nil
+}
+
+// parseCSVLine decodes a line of a Goava CSV file.
+//
+// The format is Hash,HashType,FileSize,MalwareName,Comment.
+func parseCSVLine(line string, item *HDBItem) error {
+	var fields [5]string
+	rest := line
+	for i := range fields {
+		var ok bool
+		if i == len(fields)-1 {
+			// Only the first five fields are used, as in the original format
+			fields[i], _, _ = strings.Cut(rest, ",")
+			break
+		}
+		fields[i], rest, ok = strings.Cut(rest, ",")
+		if !ok {
+			return fmt.Errorf("expected 5 fields, got %d", i+1)
+		}
+	}
+	fileSize, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return err
+	}
+
+	*item = HDBItem{
+		Hash:        fields[0],
+		HashType:    fields[1],
+		Filesize:    fileSize,
+		MalwareName: fields[3],
+		Comment:     fields[4],
+	}
+	return nil
+}
+
+// newLineScanner returns a scanner over the lines of r that accepts lines of
+// up to 1 MiB.
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return scanner
+}
+
+// hashTypeFromLen guesses the hash type from the length of its hex encoding.
+func hashTypeFromLen(n int) string {
+	switch n {
+	case 32:
+		return "md5"
+	case 40:
+		return "sha1"
+	case 64:
+		return "sha256"
+	}
+	return ""
+}
+
+// VerifySigFile checks that the file at path is a well-formed signature file
+// without loading it into a database. The file type is determined by the
+// extension of name, which allows verifying temporary files before they are
+// renamed into place.
+//
+// An error is returned if the file can't be read, has an unsupported
+// extension, contains a malformed line, or contains no signatures at all.
+func VerifySigFile(path string, name string) error {
+	parse, decode := parserForPath(name), docParserForPath(name)
+	if parse == nil && decode == nil {
+		return fmt.Errorf("%s is not a supported signature file", name)
+	}
+
+	r, _, err := openSigFile(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if decode != nil {
+		items, err := decode(r, name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if len(items) == 0 {
+			return fmt.Errorf("%s contains no signatures", name)
+		}
+		return nil
+	}
+
+	scanner := newLineScanner(r)
+	lineNum, count := 0, 0
+	var item HDBItem
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if len(line) == 0 {
+			continue
+		}
+		if err := parse(line, &item); err != nil {
+			return fmt.Errorf("%s:%d: %w", name, lineNum, err)
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("%s contains no signatures", name)
+	}
+	return nil
+}
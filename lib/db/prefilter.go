@@ -0,0 +1,294 @@
+package db
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"slices"
+	"unsafe"
+
+	"github.com/bits-and-blooms/bloom/v3"
+)
+
+// Pre-filter types, see DB.PreFilter.
+const (
+	PreFilterBloom = iota
+	PreFilterFuse
+)
+
+// A preFilter answers whether a hash may be loaded, so that most lookups of
+// unknown hashes don't need a binary search. It has false positives but no
+// false negatives.
+type preFilter interface {
+	test(hash string) bool
+
+	// The name of the filter for statistics
+	name() string
+
+	// The memory used by the filter in bytes
+	size() uint64
+
+	// The false positive rate of the filter, estimated from its size and
+	// number of entries
+	falsePositiveRate() float64
+}
+
+// PreFilterName returns the name of a pre-filter type, as used by the scan
+// command and in statistics.
+func PreFilterName(preFilter int) string {
+	switch preFilter {
+	case PreFilterBloom:
+		return "bloom"
+	case PreFilterFuse:
+		return "fuse"
+	}
+	return fmt.Sprintf("unknown (%d)", preFilter)
+}
+
+// buildPreFilter creates the pre-filter of idx.
+//
+// The filter is sized for BloomFalsePositiveRate. If it would need more than
+// MaxFilterMemory, the most accurate filter that fits is built instead. If
+// not even that fits, idx gets no filter and every lookup is a binary search.
+func (db *DB) buildPreFilter(idx *index) {
+	idx.filter = nil
+	maxMemory := db.MaxFilterMemory
+	if maxMemory == 0 {
+		maxMemory = math.MaxUint64
+	}
+
+	switch db.PreFilter {
+	case PreFilterFuse:
+		db.nl(func() { db.Logger.Print("Creating binary fuse filter...") })
+		filter, err := newFuseFilter(idx.hashes, db.BloomFalsePositiveRate, maxMemory)
+		if err != nil {
+			db.nl(func() { db.Logger.Printf("Not using a pre-filter: %v", err) })
+			return
+		}
+		idx.filter = filter
+
+	default:
+		db.nl(func() { db.Logger.Print("Creating bloom filter...") })
+		filter, err := newBloomFilter(idx.hashes, db.BloomFalsePositiveRate, maxMemory)
+		if err != nil {
+			db.nl(func() { db.Logger.Printf("Not using a pre-filter: %v", err) })
+			return
+		}
+		idx.filter = filter
+	}
+}
+
+// bloomFilter is a preFilter backed by a bloom filter.
+type bloomFilter struct {
+	*bloom.BloomFilter
+	n int
+}
+
+// newBloomFilter returns a bloom filter of hashes with a false positive rate
+// of fpr, or a higher one if that would need more than maxMemory bytes.
+func newBloomFilter(hashes []string, fpr float64, maxMemory uint64) (*bloomFilter, error) {
+	n := uint(len(hashes))
+	m, k := bloom.EstimateParameters(n, fpr)
+	if (uint64(m)+7)/8 > maxMemory {
+		m = uint(maxMemory * 8)
+		// The optimal number of hash functions for m bits and n entries
+		k = uint(math.Round(float64(m) / float64(max(n, 1)) * math.Ln2))
+		if k == 0 {
+			return nil, fmt.Errorf("a bloom filter of %d hashes does not fit in %d bytes", n, maxMemory)
+		}
+	}
+	f := &bloomFilter{bloom.New(m, k), len(hashes)}
+	for _, hash := range hashes {
+		f.AddString(hash)
+	}
+	return f, nil
+}
+
+func (f *bloomFilter) test(hash string) bool {
+	return f.TestString(hash)
+}
+
+func (f *bloomFilter) name() string {
+	return "bloom"
+}
+
+func (f *bloomFilter) size() uint64 {
+	return uint64(f.Cap()+7) / 8
+}
+
+func (f *bloomFilter) falsePositiveRate() float64 {
+	m, k, n := float64(f.Cap()), float64(f.K()), float64(f.n)
+	return math.Pow(1-math.Exp(-k*n/m), k)
+}
+
+// fuseFilter is a preFilter backed by a binary fuse filter with 8 or 16 bit
+// fingerprints, see "Binary Fuse Filters: Fast and Smaller Than Xor Filters"
+// by Graf and Lemire.
+//
+// Every hash maps to one slot in each of three consecutive segments of the
+// fingerprint array. The fingerprints are chosen so that the three slots of
+// every loaded hash xor to its fingerprint. An unknown hash passes with a
+// probability of one in 2^8 or 2^16.
+type fuseFilter[T uint8 | uint16] struct {
+	seed          maphash.Seed
+	segmentLength uint32
+	segmentMask   uint32
+	segmentsSize  uint32
+	fingerprints  []T
+}
+
+// fuseParams returns the segment length and the number of slots of a binary
+// fuse filter of n keys.
+func fuseParams(n int) (segmentLength, slots uint32) {
+	if n <= 1 {
+		return 4, 12
+	}
+	size := float64(n)
+	segmentLength = 1 << int(math.Floor(math.Log(size)/math.Log(3.33)+2.25))
+	segmentLength = min(segmentLength, 1<<18)
+	sizeFactor := max(1.125, 0.875+0.25*math.Log(1e6)/math.Log(size))
+	capacity := uint32(math.Round(size * sizeFactor))
+	segmentCount := max(int((capacity+segmentLength-1)/segmentLength)-2, 1)
+	return segmentLength, uint32(segmentCount+2) * segmentLength
+}
+
+// newFuseFilter returns a binary fuse filter of hashes with the smallest
+// fingerprints that give a false positive rate of at most fpr, or 8 bit
+// fingerprints if 16 bit ones would need more than maxMemory bytes.
+func newFuseFilter(hashes []string, fpr float64, maxMemory uint64) (preFilter, error) {
+	_, slots := fuseParams(len(hashes))
+	switch {
+	case fpr < 1.0/256 && uint64(slots)*2 <= maxMemory:
+		return buildFuseFilter[uint16](hashes)
+	case uint64(slots) <= maxMemory:
+		return buildFuseFilter[uint8](hashes)
+	}
+	return nil, fmt.Errorf("a binary fuse filter of %d hashes does not fit in %d bytes", len(hashes), maxMemory)
+}
+
+// Number of seeds tried before giving up on building a binary fuse filter.
+// A seed fails with a small probability, mostly for tiny sets.
+const fuseMaxAttempts = 100
+
+func buildFuseFilter[T uint8 | uint16](hashes []string) (*fuseFilter[T], error) {
+	segmentLength, slots := fuseParams(len(hashes))
+	f := &fuseFilter[T]{
+		segmentLength: segmentLength,
+		segmentMask:   segmentLength - 1,
+		segmentsSize:  slots - 2*segmentLength,
+		fingerprints:  make([]T, slots),
+	}
+
+	// Per slot, the number of keys mapped to it and the xor of those keys
+	counts := make([]uint32, slots)
+	xors := make([]uint64, slots)
+	// The keys in the order they were peeled off, and the slot each one was
+	// alone in
+	order := make([]uint64, 0, len(hashes))
+	alone := make([]uint32, 0, len(hashes))
+	queue := make([]uint32, 0, slots)
+
+	for range fuseMaxAttempts {
+		f.seed = maphash.MakeSeed()
+		keys := make([]uint64, len(hashes))
+		for i, hash := range hashes {
+			keys[i] = maphash.String(f.seed, hash)
+		}
+		// Duplicate keys could never be peeled off
+		slices.Sort(keys)
+		keys = slices.Compact(keys)
+
+		clear(counts)
+		clear(xors)
+		for _, key := range keys {
+			for _, slot := range f.slots(key) {
+				counts[slot]++
+				xors[slot] ^= key
+			}
+		}
+
+		// Repeatedly take a key that is alone in one of its slots and remove
+		// it from the others
+		order, alone, queue = order[:0], alone[:0], queue[:0]
+		for slot, count := range counts {
+			if count == 1 {
+				queue = append(queue, uint32(slot))
+			}
+		}
+		for len(queue) > 0 {
+			slot := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			if counts[slot] != 1 {
+				continue
+			}
+			key := xors[slot]
+			order = append(order, key)
+			alone = append(alone, slot)
+			for _, s := range f.slots(key) {
+				counts[s]--
+				xors[s] ^= key
+				if counts[s] == 1 {
+					queue = append(queue, s)
+				}
+			}
+		}
+		if len(order) < len(keys) {
+			continue
+		}
+
+		// Assign fingerprints in reverse, so that the slot a key was alone
+		// in is not used by any key assigned later
+		for i := len(order) - 1; i >= 0; i-- {
+			key := order[i]
+			fp := fuseFingerprint[T](key)
+			for _, s := range f.slots(key) {
+				if s != alone[i] {
+					fp ^= f.fingerprints[s]
+				}
+			}
+			f.fingerprints[alone[i]] = fp
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("could not build a binary fuse filter of %d hashes", len(hashes))
+}
+
+// slots returns the three slots of key, one in each of three consecutive
+// segments.
+func (f *fuseFilter[T]) slots(key uint64) [3]uint32 {
+	hi, _ := bits.Mul64(key, uint64(f.segmentsSize))
+	h0 := uint32(hi)
+	h1 := h0 + f.segmentLength
+	h2 := h1 + f.segmentLength
+	h1 ^= uint32(key>>18) & f.segmentMask
+	h2 ^= uint32(key) & f.segmentMask
+	return [3]uint32{h0, h1, h2}
+}
+
+func fuseFingerprint[T uint8 | uint16](key uint64) T {
+	return T(key ^ key>>32)
+}
+
+func (f *fuseFilter[T]) test(hash string) bool {
+	key := maphash.String(f.seed, hash)
+	s := f.slots(key)
+	return fuseFingerprint[T](key) == f.fingerprints[s[0]]^f.fingerprints[s[1]]^f.fingerprints[s[2]]
+}
+
+func (f *fuseFilter[T]) bits() int {
+	var t T
+	return int(unsafe.Sizeof(t)) * 8
+}
+
+func (f *fuseFilter[T]) name() string {
+	return fmt.Sprintf("binary fuse (%d bit)", f.bits())
+}
+
+func (f *fuseFilter[T]) size() uint64 {
+	return uint64(len(f.fingerprints)) * uint64(f.bits()/8)
+}
+
+func (f *fuseFilter[T]) falsePositiveRate() float64 {
+	return math.Ldexp(1, -f.bits())
+}
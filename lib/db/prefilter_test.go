@@ -0,0 +1,148 @@
+package db
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+// randomHashes returns n random md5 hashes, the same for the same seed.
+func randomHashes(n int, seed uint64) []string {
+	r := rand.New(rand.NewPCG(seed, 0))
+	hashes := make([]string, n)
+	for i := range hashes {
+		hashes[i] = fmt.Sprintf("%016x%016x", r.Uint64(), r.Uint64())
+	}
+	return hashes
+}
+
+// measuredFPR returns the share of n hashes that are not in f but pass it.
+func measuredFPR(f preFilter, n int) float64 {
+	passed := 0
+	// Seed 0 is never used for the loaded hashes
+	for _, hash := range randomHashes(n, 0) {
+		if f.test(hash) {
+			passed++
+		}
+	}
+	return float64(passed) / float64(n)
+}
+
+func TestPreFilterNoFalseNegatives(t *testing.T) {
+	builders := map[string]func([]string) (preFilter, error){
+		"bloom":  func(h []string) (preFilter, error) { return newBloomFilter(h, 0.01, 1<<30) },
+		"fuse8":  func(h []string) (preFilter, error) { return buildFuseFilter[uint8](h) },
+		"fuse16": func(h []string) (preFilter, error) { return buildFuseFilter[uint16](h) },
+	}
+	for name, build := range builders {
+		for _, n := range []int{0, 1, 2, 3, 10, 100, 1000, 100000} {
+			t.Run(fmt.Sprintf("%s/%d", name, n), func(t *testing.T) {
+				for seed := range uint64(10) {
+					hashes := randomHashes(n, seed+1)
+					if n > 1 {
+						// Loaded twice, as by files redefining a hash
+						hashes = append(hashes, hashes[0])
+					}
+					f, err := build(hashes)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, hash := range hashes {
+						if !f.test(hash) {
+							t.Fatalf("seed %d: %s loaded but rejected", seed, hash)
+						}
+					}
+					if n >= 100000 {
+						break
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestPreFilterFalsePositiveRate(t *testing.T) {
+	hashes := randomHashes(100000, 1)
+	tests := []struct {
+		name   string
+		filter func() (preFilter, error)
+		want   float64
+		probes int
+		// Relative tolerance of the measured rate, about four standard
+		// deviations of the number of false positives
+		tolerance float64
+	}{
+		{"bloom 1%", func() (preFilter, error) { return newBloomFilter(hashes, 0.01, 1<<30) }, 0.01, 200000, 0.1},
+		{"bloom 0.1%", func() (preFilter, error) { return newBloomFilter(hashes, 0.001, 1<<30) }, 0.001, 1000000, 0.15},
+		{"fuse 8 bit", func() (preFilter, error) { return newFuseFilter(hashes, 0.01, 1<<30) }, 1.0 / 256, 1000000, 0.1},
+		{"fuse 16 bit", func() (preFilter, error) { return newFuseFilter(hashes, 0.001, 1<<30) }, 1.0 / 65536, 4000000, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := tt.filter()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := measuredFPR(f, tt.probes)
+			if got < tt.want*(1-tt.tolerance) || got > tt.want*(1+tt.tolerance) {
+				t.Errorf("measured a false positive rate of %g, want %g", got, tt.want)
+			}
+			if est := f.falsePositiveRate(); est < tt.want*0.9 || est > tt.want*1.1 {
+				t.Errorf("estimated a false positive rate of %g, want %g", est, tt.want)
+			}
+		})
+	}
+}
+
+// Filters that need more than MaxFilterMemory are built smaller and less
+// accurate, or not at all.
+func TestPreFilterMaxMemory(t *testing.T) {
+	hashes := randomHashes(10000, 1)
+	full, err := newBloomFilter(hashes, 0.001, 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		preFilter int
+		maxMemory uint64
+		// Expected filter name, or "" for no filter
+		want string
+	}{
+		{"bloom unlimited", PreFilterBloom, 0, "bloom"},
+		{"bloom fits", PreFilterBloom, full.size(), "bloom"},
+		{"bloom shrunk", PreFilterBloom, full.size() / 4, "bloom"},
+		{"bloom too small", PreFilterBloom, 1, ""},
+		{"fuse fits", PreFilterFuse, 1 << 20, "binary fuse (16 bit)"},
+		{"fuse 8 bit", PreFilterFuse, 15000, "binary fuse (8 bit)"},
+		{"fuse too small", PreFilterFuse, 1000, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := &DB{PreFilter: tt.preFilter, BloomFalsePositiveRate: 0.001, MaxFilterMemory: tt.maxMemory}
+			idx := &index{hashes: hashes}
+			database.buildPreFilter(idx)
+			if idx.filter == nil {
+				if tt.want != "" {
+					t.Fatalf("got no filter, want %s", tt.want)
+				}
+				return
+			}
+			f := idx.filter
+			if f.name() != tt.want {
+				t.Fatalf("got filter %s, want %q", f.name(), tt.want)
+			}
+			if tt.maxMemory > 0 && f.size() > tt.maxMemory {
+				t.Errorf("filter uses %d bytes, more than %d", f.size(), tt.maxMemory)
+			}
+			if tt.maxMemory > 0 && tt.maxMemory < full.size() && f.falsePositiveRate() <= 0.001 {
+				t.Errorf("filter shrunk to %d bytes estimates a false positive rate of %g", f.size(), f.falsePositiveRate())
+			}
+			for _, hash := range hashes {
+				if !f.test(hash) {
+					t.Fatalf("%s loaded but rejected", hash)
+				}
+			}
+		})
+	}
+}
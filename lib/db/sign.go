@@ -0,0 +1,184 @@
+package db
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ManifestSigFile is the name of the manifest signature inside a database
+// directory. It holds the base64 encoded ed25519 signature of the manifest.
+const ManifestSigFile = "goava.manifest.sig"
+
+var (
+	// ErrNoManifest is returned when a manifest is required but missing.
+	ErrNoManifest = errors.New("database has no manifest")
+
+	// ErrBadSignature is returned when the manifest signature is missing or
+	// does not match the trusted key.
+	ErrBadSignature = errors.New("manifest signature is invalid")
+)
+
+// VerifyManifest checks the manifest of the database directory dir and then
+// checks the signature files in dir against it. If key is not nil, the
+// manifest must carry a valid signature made with the matching private key.
+//
+// ErrNoManifest, ErrBadSignature or a *ManifestMismatchError is returned if
+// verification fails.
+func VerifyManifest(dir string, key ed25519.PublicKey) (*Manifest, error) {
+	m, err := readSignedManifest(dir, key)
+	if err != nil {
+		return nil, err
+	}
+	return m, m.Verify(dir)
+}
+
+// verifyKnownManifest checks the known-good indexes at the top level of the
+// database directory dir against its manifest, like VerifyManifest, ignoring
+// all other files. It is used for directories with generations, as their
+// known-good indexes are kept outside of the generations and so are not
+// covered by the manifest of the active one. A directory without known-good
+// indexes needs no manifest.
+func verifyKnownManifest(dir string, key ed25519.PublicKey) error {
+	actual, err := checksumKnown(dir)
+	if err != nil {
+		return err
+	}
+	m, err := readSignedManifest(dir, key)
+	if errors.Is(err, ErrNoManifest) && len(actual) == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	known := &Manifest{Files: make(map[string]string)}
+	for name, sum := range m.Files {
+		if !strings.Contains(name, "/") && isKnownFile(name) {
+			known.Files[name] = sum
+		}
+	}
+	return known.compare(actual)
+}
+
+// readSignedManifest reads the manifest of the database directory dir and,
+// if key is not nil, checks its signature.
+func readSignedManifest(dir string, key ed25519.PublicKey) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if isNotExist(err) {
+		return nil, ErrNoManifest
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if key != nil {
+		sig, err := readSignature(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !ed25519.Verify(key, data, sig) {
+			return nil, ErrBadSignature
+		}
+	}
+
+	return parseManifest(data)
+}
+
+// SignManifest signs the manifest of the database directory dir with key and
+// writes the signature next to it.
+func SignManifest(dir string, key ed25519.PrivateKey) error {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if isNotExist(err) {
+		return ErrNoManifest
+	}
+	if err != nil {
+		return err
+	}
+	sig := ed25519.Sign(key, data)
+	return writeFileAtomic(filepath.Join(dir, ManifestSigFile), []byte(base64.StdEncoding.EncodeToString(sig)+"\n"))
+}
+
+func readSignature(dir string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestSigFile))
+	if isNotExist(err) {
+		return nil, fmt.Errorf("%w: %s is missing", ErrBadSignature, ManifestSigFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeSignature(data)
+}
+
+// decodeSignature decodes the contents of a manifest signature file.
+func decodeSignature(data []byte) ([]byte, error) {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: %s is malformed", ErrBadSignature, ManifestSigFile)
+	}
+	return sig, nil
+}
+
+// GenerateKey returns a new ed25519 key pair for signing manifests, encoded
+// as PEM.
+func GenerateKey() (public []byte, private []byte, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	public = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	private = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+	return public, private, nil
+}
+
+// ParsePublicKey parses an ed25519 public key, either PEM encoded or as the
+// base64 encoding of the raw 32 byte key.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key is not an ed25519 key")
+		}
+		return pub, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key is neither PEM nor a base64 encoded ed25519 key")
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// ParsePrivateKey parses a PEM encoded ed25519 private key.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an ed25519 key")
+	}
+	return priv, nil
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newKey returns a new ed25519 key pair, parsed from the encoding of
+// GenerateKey.
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pubPEM, privPEM, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ParsePublicKey(pubPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := ParsePrivateKey(privPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+func TestVerifyManifest(t *testing.T) {
+	pub, priv := newKey(t)
+	other, _ := newKey(t)
+	tests := []struct {
+		name string
+		// Changes the signed database folder dir
+		change func(t *testing.T, dir string)
+		key    ed25519.PublicKey
+		errIs  error
+		// Expected mismatch, as the added, removed and modified files
+		mismatch *ManifestMismatchError
+	}{
+		{name: "trusted key", key: pub},
+		{name: "no key", key: nil},
+		{name: "other key", key: other, errIs: ErrBadSignature},
+		{
+			name: "bad signature",
+			change: func(t *testing.T, dir string) {
+				sig := base64.StdEncoding.EncodeToString(make([]byte, ed25519.SignatureSize))
+				writeFile(t, filepath.Join(dir, ManifestSigFile), sig+"\n")
+			},
+			key:   pub,
+			errIs: ErrBadSignature,
+		},
+		{
+			name:   "malformed signature",
+			change: func(t *testing.T, dir string) { writeFile(t, filepath.Join(dir, ManifestSigFile), "not base64\n") },
+			key:    pub,
+			errIs:  ErrBadSignature,
+		},
+		{
+			name: "missing signature",
+			change: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, ManifestSigFile)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			key:   pub,
+			errIs: ErrBadSignature,
+		},
+		{
+			name: "missing signature without key",
+			change: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, ManifestSigFile)); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "tampered manifest",
+			change: func(t *testing.T, dir string) {
+				m, err := ReadManifest(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				m.Version++
+				if err := m.Write(dir); err != nil {
+					t.Fatal(err)
+				}
+			},
+			key:   pub,
+			errIs: ErrBadSignature,
+		},
+		{
+			name: "missing manifest",
+			change: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, ManifestFile)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			key:   pub,
+			errIs: ErrNoManifest,
+		},
+		{
+			name:     "tampered file",
+			change:   func(t *testing.T, dir string) { writeFile(t, filepath.Join(dir, "main.hdb"), topHash+":2:Test.Top\n") },
+			key:      pub,
+			mismatch: &ManifestMismatchError{Modified: []string{"main.hdb"}},
+		},
+		{
+			name: "added and removed files",
+			change: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "sub", "new.hdb"), newHash+":2:Test.New\n")
+				if err := os.Remove(filepath.Join(dir, "daily.hdb")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			key:      pub,
+			mismatch: &ManifestMismatchError{Added: []string{"sub/new.hdb"}, Removed: []string{"daily.hdb"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, "main.hdb"), teamHash+":68:Test.Team\n")
+			writeFile(t, filepath.Join(dir, "daily.hdb"), topHash+":2:Test.Top\n")
+			m, err := BuildManifest(dir, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Write(dir); err != nil {
+				t.Fatal(err)
+			}
+			if err := SignManifest(dir, priv); err != nil {
+				t.Fatal(err)
+			}
+			if tt.change != nil {
+				tt.change(t, dir)
+			}
+
+			got, err := VerifyManifest(dir, tt.key)
+			var mismatch *ManifestMismatchError
+			switch {
+			case tt.mismatch != nil:
+				if !errors.As(err, &mismatch) {
+					t.Fatalf("got error %v, want a mismatch", err)
+				}
+				if !slices.Equal(mismatch.Added, tt.mismatch.Added) || !slices.Equal(mismatch.Removed, tt.mismatch.Removed) || !slices.Equal(mismatch.Modified, tt.mismatch.Modified) {
+					t.Errorf("got %v, want %v", mismatch, tt.mismatch)
+				}
+			case tt.errIs != nil:
+				if !errors.Is(err, tt.errIs) {
+					t.Fatalf("got error %v, want %v", err, tt.errIs)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case got.Version != 1 || len(got.Files) != 2:
+				t.Errorf("got manifest %+v", got)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	pubPEM, privPEM, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ParsePublicKey(pubPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPubDER, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPrivDER, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemOf := func(typ string, der []byte) []byte { return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}) }
+
+	tests := []struct {
+		name    string
+		parse   func([]byte) error
+		data    []byte
+		wantErr bool
+	}{
+		{"public PEM", parsePublic, pubPEM, false},
+		{"public base64", parsePublic, []byte(base64.StdEncoding.EncodeToString(pub) + "\n"), false},
+		{"public base64 too short", parsePublic, []byte(base64.StdEncoding.EncodeToString(pub[:16])), true},
+		{"public garbage", parsePublic, []byte("not a key"), true},
+		{"public empty", parsePublic, nil, true},
+		{"public invalid PEM", parsePublic, pemOf("PUBLIC KEY", []byte("not DER")), true},
+		{"public ECDSA", parsePublic, pemOf("PUBLIC KEY", ecPubDER), true},
+		{"private key as public", parsePublic, privPEM, true},
+		{"private PEM", parsePrivate, privPEM, false},
+		{"private not PEM", parsePrivate, []byte(base64.StdEncoding.EncodeToString(make([]byte, ed25519.PrivateKeySize))), true},
+		{"private invalid PEM", parsePrivate, pemOf("PRIVATE KEY", []byte("not DER")), true},
+		{"private ECDSA", parsePrivate, pemOf("PRIVATE KEY", ecPrivDER), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.parse(tt.data); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func parsePublic(data []byte) error {
+	_, err := ParsePublicKey(data)
+	return err
+}
+
+func parsePrivate(data []byte) error {
+	_, err := ParsePrivateKey(data)
+	return err
+}
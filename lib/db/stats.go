@@ -0,0 +1,145 @@
+package db
+
+import (
+	"strings"
+	"time"
+	"unsafe"
+)
+
+type HDBStats struct {
+	// Number of unique signatures, not counting fuzzy signatures
+	Count int
+
+	// Number of fuzzy signatures
+	FuzzyCount int
+
+	// The generation of the last loaded database folder, or 0 if it does not
+	// use generations
+	Generation int
+
+	// The active generation of every loaded database folder that uses
+	// generations
+	Generations map[string]int
+
+	// Number of signatures per hash type, per database folder, per source
+	// file and per category. See HDBItem.Category for how the category is
+	// determined
+	ByHashType map[string]int
+	ByRoot     map[string]int
+	BySource   map[string]int
+	ByCategory map[string]int
+
+	// Number of signatures with an unknown size, whether loaded or ignored
+	WildcardSizes int
+
+	// Number of signatures whose hash was already loaded from another line
+	Duplicates int
+
+	// Number of signatures that were skipped, see UnknownSizeAction
+	Ignored int
+
+	// Number of signatures excluded by DB.Filter, and number of files that
+	// were not read because their source was excluded
+	Filtered      int
+	FilteredFiles int
+
+	// Number of hashes in the known-good indexes
+	KnownGood int
+
+	// The type of the pre-filter, e.g. bloom, or empty if there is none
+	FilterType string
+
+	// Size of the pre-filter in bytes, or 0 if there is none
+	FilterSize uint64
+
+	// The configured false positive rate of the pre-filter, and the rate
+	// estimated from its actual size and number of entries. The estimate is
+	// higher than configured if the filter was limited by MaxFilterMemory
+	FilterFalsePositiveRate          float64
+	FilterEstimatedFalsePositiveRate float64
+
+	// Time spent reading and parsing signature files, and sorting them
+	LoadTime time.Duration
+	SortTime time.Duration
+
+	// Approximate memory used by the loaded signatures and the pre-filter,
+	// in bytes
+	Memory uint64
+}
+
+// Approximate per signature overhead of an HDBItem and its map entry, on top
+// of the string contents.
+const itemOverhead = uint64(unsafe.Sizeof(HDBItem{})) + 48
+
+// GetHDBStats returns statistics about the loaded signatures.
+// Should be called after LoadSigs, and after LoadBloom to include the pre-filter.
+func (db *DB) GetHDBStats() HDBStats {
+	idx := db.current()
+	stats := HDBStats{
+		Count:         len(idx.hashes),
+		FuzzyCount:    len(idx.ssdeep) + len(idx.tlsh),
+		Generation:    idx.generation(),
+		Generations:   make(map[string]int),
+		ByHashType:    make(map[string]int),
+		ByRoot:        make(map[string]int),
+		BySource:      make(map[string]int),
+		ByCategory:    make(map[string]int),
+		WildcardSizes: idx.wildcardSizes,
+		Duplicates:    idx.duplicates,
+		Ignored:       idx.ignored,
+		Filtered:      idx.filtered,
+		FilteredFiles: idx.filteredFiles,
+		LoadTime:      idx.loadTime,
+		SortTime:      idx.sortTime,
+	}
+
+	for i, root := range idx.roots {
+		if idx.generations[i] > 0 {
+			stats.Generations[root] = idx.generations[i]
+		}
+	}
+
+	stats.KnownGood = idx.known.len()
+
+	count := func(item *HDBItem) {
+		stats.ByHashType[item.HashType]++
+		stats.ByRoot[item.Root]++
+		stats.BySource[item.Source]++
+		stats.ByCategory[item.Category()]++
+		stats.Memory += itemOverhead + uint64(len(item.Hash)+len(item.HashType)+len(item.MalwareName)+len(item.Comment)+len(item.Source))
+	}
+	for _, item := range idx.hashToItem {
+		count(item)
+	}
+	for _, sig := range idx.ssdeep {
+		count(sig.item)
+	}
+	for _, sig := range idx.tlsh {
+		count(sig.item)
+	}
+	stats.Memory += uint64(cap(idx.hashes))*uint64(unsafe.Sizeof("")) + uint64(cap(idx.sizes))*uint64(unsafe.Sizeof(0))
+
+	if idx.filter != nil {
+		stats.FilterType = idx.filter.name()
+		stats.FilterSize = idx.filter.size()
+		stats.FilterFalsePositiveRate = db.BloomFalsePositiveRate
+		stats.FilterEstimatedFalsePositiveRate = idx.filter.falsePositiveRate()
+		stats.Memory += stats.FilterSize
+	}
+
+	return stats
+}
+
+// Category returns the category of the signature, taken from its name.
+//
+// Clamav names signatures Platform.Category.Name-SigID-Revision, e.g.
+// Win.Trojan.Agent-12345-0, in which case the category is Trojan. Names that
+// don't follow this convention have the category "Unknown".
+func (item *HDBItem) Category() string {
+	_, rest, ok := strings.Cut(item.MalwareName, ".")
+	category, _, ok2 := strings.Cut(rest, ".")
+	if !ok || !ok2 || category == "" {
+		return "Unknown"
+	}
+	return category
+}
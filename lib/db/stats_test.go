@@ -0,0 +1,103 @@
+package db
+
+import (
+	"maps"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Signatures are counted per hash type, folder, source file and category,
+// after duplicates, ignored and filtered signatures are removed.
+func TestHDBStats(t *testing.T) {
+	sha256 := strings.Repeat("ab", 32)
+	root, extra := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(root, "main.hdb"), teamHash+":68:Win.Trojan.Agent-1-0\n"+
+		topHash+":10:Unix.Malware.Top-2-0\n"+
+		topHash+":10:Unix.Malware.Top-2-0\n"+
+		newHash+":*:Win.Trojan.Unsized-3-0\n")
+	writeFile(t, filepath.Join(root, "main.hsb"), sha256+":20:Eicar-Test-Signature\n")
+	writeFile(t, filepath.Join(root, "daily", "excluded.hdb"), strings.Repeat("cd", 16)+":5:Win.Trojan.Skipped-4-0\n")
+	writeFile(t, filepath.Join(extra, "extra.hdb"), strings.Repeat("ef", 16)+":5:Doc.Exploit.Macro-5-0\n"+
+		strings.Repeat("01", 16)+":5:Doc.Exploit.Filtered-6-0\n")
+
+	database := &DB{
+		Path:                   root,
+		Paths:                  []string{extra},
+		UseBloom:               true,
+		BloomFalsePositiveRate: 0.01,
+		Filter: Filter{
+			ExcludeNames:   []string{"*.Filtered-*"},
+			ExcludeSources: []string{"daily/*"},
+		},
+	}
+	if err := database.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.LoadSigs(); err != nil {
+		t.Fatal(err)
+	}
+	database.LoadBloom()
+	stats := database.GetHDBStats()
+
+	counts := []struct {
+		name      string
+		got, want map[string]int
+	}{
+		{"hash type", stats.ByHashType, map[string]int{"md5": 3, "sha256": 1}},
+		{"root", stats.ByRoot, map[string]int{root: 3, extra: 1}},
+		{"source", stats.BySource, map[string]int{"main.hdb": 2, "main.hsb": 1, "extra.hdb": 1}},
+		{"category", stats.ByCategory, map[string]int{"Trojan": 1, "Malware": 1, "Exploit": 1, "Unknown": 1}},
+	}
+	for _, c := range counts {
+		if !maps.Equal(c.got, c.want) {
+			t.Errorf("by %s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	totals := []struct {
+		name      string
+		got, want int
+	}{
+		{"signatures", stats.Count, 4},
+		{"wildcard sizes", stats.WildcardSizes, 1},
+		{"duplicates", stats.Duplicates, 1},
+		{"ignored", stats.Ignored, 1},
+		{"filtered", stats.Filtered, 1},
+		{"filtered files", stats.FilteredFiles, 1},
+	}
+	for _, c := range totals {
+		if c.got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, c.got, c.want)
+		}
+	}
+
+	if stats.FilterType == "" || stats.FilterSize == 0 || stats.FilterFalsePositiveRate != 0.01 {
+		t.Errorf("got pre-filter %q of %d bytes with rate %v, want one with rate 0.01", stats.FilterType, stats.FilterSize, stats.FilterFalsePositiveRate)
+	}
+	if stats.Memory <= stats.FilterSize {
+		t.Errorf("memory %d does not include the signatures", stats.Memory)
+	}
+}
+
+func TestCategory(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"Win.Trojan.Agent-12345-0", "Trojan"},
+		{"Unix.Malware.Agent", "Malware"},
+		{"Doc.Exploit.CVE_2017_0199-1", "Exploit"},
+		{"Eicar-Test-Signature", "Unknown"},
+		{"Win.Trojan", "Unknown"},
+		{"Win..Agent", "Unknown"},
+		{"", "Unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := &HDBItem{MalwareName: tt.name}
+			if got := item.Category(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch reloads the signatures whenever the database folder changes, until
+// ctx is cancelled. Changes are collected for WatchDelay before reloading, so
+// a batch of updated files causes a single reload.
+//
+// The folder of the active generation of every database folder is watched
+// along with the database folder itself, so both edits to the database files
+// and newly activated generations are picked up.
+//
+// Errors from reloads are passed to OnReload rather than returned, and the
+// previously loaded signatures stay in place. Watch only returns an error if
+// the folder can't be watched.
+//
+// Should be called after Init and LoadAll
+func (db *DB) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	delay := db.WatchDelay
+	if delay == 0 {
+		delay = time.Second
+	}
+
+	watched, err := db.watchPaths(watcher, nil)
+	if err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(delay)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if isHiddenName(event.Name) {
+				continue
+			}
+			timer.Reset(delay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			db.nl(func() { db.Logger.Printf("Error watching database: %v", err) })
+		case <-timer.C:
+			db.nl(func() { db.Logger.Print("Database changed, reloading signatures") })
+			err := db.Reload()
+			if err != nil {
+				db.nl(func() { db.Logger.Printf("Could not reload signatures: %v", err) })
+			}
+			if db.OnReload != nil {
+				db.OnReload(err)
+			}
+			if watched, err = db.watchPaths(watcher, watched); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// watchPaths makes watcher watch every database folder and every folder of
+// their active databases, and stops watching the folders in old that are no
+// longer part of them. The watched folders are returned.
+func (db *DB) watchPaths(watcher *fsnotify.Watcher, old map[string]bool) (map[string]bool, error) {
+	paths := make(map[string]bool)
+	for _, dbRoot := range db.Roots() {
+		paths[filepath.Clean(dbRoot)] = true
+		root := ActivePath(dbRoot)
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return nil
+			}
+			if isHiddenDir(root, path) || (root == dbRoot && filepath.Base(path) == generationsDir) {
+				return filepath.SkipDir
+			}
+			paths[filepath.Clean(path)] = true
+			return nil
+		})
+		if err != nil {
+			return old, err
+		}
+	}
+
+	for path := range paths {
+		if !old[path] {
+			if err := watcher.Add(path); err != nil {
+				return old, err
+			}
+		}
+	}
+	for path := range old {
+		if !paths[path] {
+			// The folder may already be gone if its generation was pruned
+			watcher.Remove(path)
+		}
+	}
+	return paths, nil
+}
+
+// isHiddenName reports whether the base name of path is hidden, which is the
+// case for temporary and staging files.
+func isHiddenName(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), ".")
+}
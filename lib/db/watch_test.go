@@ -0,0 +1,148 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Watch reloads the signatures after changes to the database folder, to the
+// active generation and to nested folders, and keeps the old signatures if a
+// reload fails.
+func TestWatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		generations bool
+		change      func(t *testing.T, root string)
+		wantErr     bool
+		// Expected result of looking up each hash after the reload
+		want map[string]bool
+	}{
+		{
+			name: "edit",
+			change: func(t *testing.T, root string) {
+				writeFile(t, filepath.Join(root, "main.hdb"), topHash+":2:Test.Top\n")
+			},
+			want: map[string]bool{teamHash: false, topHash: true},
+		},
+		{
+			name: "new file",
+			change: func(t *testing.T, root string) {
+				writeFile(t, filepath.Join(root, "daily.hdb"), topHash+":2:Test.Top\n")
+			},
+			want: map[string]bool{teamHash: true, topHash: true},
+		},
+		{
+			name: "nested folder",
+			change: func(t *testing.T, root string) {
+				writeFile(t, filepath.Join(root, "sub", "sub.hdb"), topHash+":2:Test.Top\n")
+			},
+			want: map[string]bool{teamHash: true, topHash: true},
+		},
+		{
+			name: "invalid file",
+			change: func(t *testing.T, root string) {
+				writeFile(t, filepath.Join(root, "main.hdb"), "not a signature\n")
+			},
+			wantErr: true,
+			want:    map[string]bool{teamHash: true},
+		},
+		{
+			name:        "new generation",
+			generations: true,
+			change: func(t *testing.T, root string) {
+				staging, err := StageGeneration(root)
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeFile(t, filepath.Join(staging, "daily.hdb"), topHash+":2:Test.Top\n")
+				if _, err := InstallGeneration(root, staging, 0); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: map[string]bool{teamHash: true, topHash: true},
+		},
+		{
+			name:        "active generation",
+			generations: true,
+			change: func(t *testing.T, root string) {
+				writeFile(t, filepath.Join(ActivePath(root), "daily.hdb"), topHash+":2:Test.Top\n")
+			},
+			want: map[string]bool{teamHash: true, topHash: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFile(t, filepath.Join(root, "main.hdb"), teamHash+":68:Test.Team\n")
+			if tt.generations {
+				if err := MigrateToGenerations(root); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Nested folders that exist when Watch starts are watched
+			writeFile(t, filepath.Join(ActivePath(root), "sub", ".keep"), "")
+
+			reloads := make(chan error, 16)
+			database := &DB{Path: root, WatchDelay: 50 * time.Millisecond, OnReload: func(err error) { reloads <- err }}
+			if err := database.Init(); err != nil {
+				t.Fatal(err)
+			}
+			if err := database.LoadAll(); err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- database.Watch(ctx) }()
+			defer func() {
+				cancel()
+				if err := <-done; err != nil {
+					t.Error(err)
+				}
+			}()
+			// Give Watch time to add its watches
+			time.Sleep(50 * time.Millisecond)
+
+			tt.change(t, root)
+			select {
+			case err := <-reloads:
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("reload got error %v, want error %v", err, tt.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("signatures not reloaded")
+			}
+			for hash, want := range tt.want {
+				if ok, err := database.HasSigWithHash(hash); err != nil || ok != want {
+					t.Errorf("HasSigWithHash(%s): %v, %v, want %v", hash, ok, err, want)
+				}
+			}
+		})
+	}
+}
+
+// Hidden files, such as the temporary files of updates, do not cause reloads.
+func TestWatchIgnoresHidden(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.hdb"), teamHash+":68:Test.Team\n")
+	reloads := make(chan error, 16)
+	database := &DB{Path: root, WatchDelay: 20 * time.Millisecond, OnReload: func(err error) { reloads <- err }}
+	if err := database.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go database.Watch(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	writeFile(t, filepath.Join(root, ".main.hdb.tmp"), topHash+":2:Test.Top\n")
+	select {
+	case <-reloads:
+		t.Error("hidden file caused a reload")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
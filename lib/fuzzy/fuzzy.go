@@ -0,0 +1,33 @@
+// Package fuzzy computes and compares ssdeep and TLSH fuzzy hashes, which
+// are similar for similar inputs, unlike cryptographic hashes.
+package fuzzy
+
+// A Hasher computes the ssdeep and TLSH hashes of the data written to it in
+// a single pass.
+type Hasher struct {
+	ssdeep *ssdeepState
+	tlsh   tlshState
+}
+
+// New returns a new Hasher.
+func New() *Hasher {
+	return &Hasher{ssdeep: newSSDeepState()}
+}
+
+// Write adds p to the hashed data. It never returns an error.
+func (h *Hasher) Write(p []byte) (int, error) {
+	h.ssdeep.write(p)
+	h.tlsh.write(p)
+	return len(p), nil
+}
+
+// SSDeep returns the ssdeep hash of the data written so far.
+func (h *Hasher) SSDeep() string {
+	return h.ssdeep.digest()
+}
+
+// TLSH returns the TLSH hash of the data written so far, or an empty string
+// if the data is shorter than 50 bytes or too uniform to be hashed.
+func (h *Hasher) TLSH() string {
+	return h.tlsh.digest()
+}
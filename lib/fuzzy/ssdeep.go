@@ -0,0 +1,326 @@
+package fuzzy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parameters of the ssdeep algorithm.
+const (
+	ssdeepWindow       = 7
+	ssdeepMinBlockSize = 3
+	ssdeepLength       = 64
+	ssdeepNumBlocks    = 31
+
+	// ssdeep hashes the content of blocks with FNV. Only the hash modulo 64
+	// ends up in the digest, and the lowest bits of the hash don't depend on
+	// the higher ones, so the hash is kept modulo 64, like its parameters
+	ssdeepHashInit  = 0x28021967 % 64
+	ssdeepHashPrime = 0x01000193 % 64
+)
+
+const ssdeepAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// ssdeepRoll is the rolling hash that decides where ssdeep splits the input
+// into blocks.
+type ssdeepRoll struct {
+	window     [ssdeepWindow]byte
+	h1, h2, h3 uint32
+	n          int
+}
+
+func (r *ssdeepRoll) update(c byte) {
+	r.h2 -= r.h1
+	r.h2 += ssdeepWindow * uint32(c)
+	r.h1 += uint32(c)
+	r.h1 -= uint32(r.window[r.n])
+	r.window[r.n] = c
+	if r.n++; r.n == ssdeepWindow {
+		r.n = 0
+	}
+	r.h3 = (r.h3 << 5) ^ uint32(c)
+}
+
+func (r *ssdeepRoll) sum() uint32 {
+	return r.h1 + r.h2 + r.h3
+}
+
+// An ssdeepBlock is the digest of the input for one block size. digest[dlen]
+// holds the character of the current block once the digest is full, and
+// halfDigest tracks the digest truncated to half its length.
+type ssdeepBlock struct {
+	digest     [ssdeepLength]byte
+	dlen       int
+	halfDigest byte
+}
+
+// ssdeepState computes the ssdeep digest for every block size at once, so
+// the input only has to be read once.
+//
+// Only the block sizes from start to end are tracked. Block sizes above end
+// have not ended a block yet, so their state equals that of the last tracked
+// one, which is copied when it first ends a block. Block sizes below start
+// have been dropped, as their digest grew too long to be picked.
+type ssdeepState struct {
+	roll       ssdeepRoll
+	blocks     [ssdeepNumBlocks]ssdeepBlock
+	start, end int
+
+	// The hashes of the current block, and of the current block of the
+	// digest truncated to half its length, per block size
+	h, half [ssdeepNumBlocks]byte
+	total   uint64
+}
+
+func newSSDeepState() *ssdeepState {
+	s := &ssdeepState{end: 1}
+	s.h[0], s.half[0] = ssdeepHashInit, ssdeepHashInit
+	return s
+}
+
+func ssdeepBlockSize(i int) uint32 {
+	return ssdeepMinBlockSize << i
+}
+
+func (s *ssdeepState) write(p []byte) {
+	s.total += uint64(len(p))
+	for _, c := range p {
+		s.roll.update(c)
+		h, half := s.h[s.start:s.end], s.half[s.start:s.end]
+		for i := range h {
+			h[i] = (h[i]*ssdeepHashPrime ^ c) % 64
+			half[i] = (half[i]*ssdeepHashPrime ^ c) % 64
+		}
+
+		sum := s.roll.sum()
+		for i := s.start; i < s.end; i++ {
+			bs := ssdeepBlockSize(i)
+			// Block sizes are powers of two multiples of each other, so no
+			// larger block ends here either
+			if sum%bs != bs-1 {
+				break
+			}
+			b := &s.blocks[i]
+			if b.dlen == 0 && i == s.end-1 && s.end < ssdeepNumBlocks {
+				s.h[s.end], s.half[s.end] = s.h[i], s.half[i]
+				s.end++
+			}
+			b.digest[b.dlen] = ssdeepAlphabet[s.h[i]]
+			b.halfDigest = ssdeepAlphabet[s.half[i]]
+			if b.dlen < ssdeepLength-1 {
+				b.dlen++
+				b.digest[b.dlen] = 0
+				s.h[i] = ssdeepHashInit
+				if b.dlen < ssdeepLength/2 {
+					s.half[i] = ssdeepHashInit
+					b.halfDigest = 0
+				}
+			} else {
+				s.reduce()
+			}
+		}
+	}
+}
+
+// reduce stops tracking the smallest block size once it can no longer be
+// picked for the digest.
+func (s *ssdeepState) reduce() {
+	if s.end-s.start < 2 {
+		return
+	}
+	if uint64(ssdeepBlockSize(s.start))*ssdeepLength >= s.total {
+		return
+	}
+	if s.blocks[s.start+1].dlen < ssdeepLength/2 {
+		return
+	}
+	s.start++
+}
+
+// digest returns the ssdeep digest of the input written so far.
+func (s *ssdeepState) digest() string {
+	end := s.end
+	bi := s.start
+	for uint64(ssdeepBlockSize(bi))*ssdeepLength < s.total {
+		bi++
+	}
+	for bi >= end {
+		bi--
+	}
+	for bi > s.start && s.blocks[bi].dlen < ssdeepLength/2 {
+		bi--
+	}
+
+	sum := s.roll.sum()
+	var sb strings.Builder
+	sb.WriteString(strconv.FormatUint(uint64(ssdeepBlockSize(bi)), 10))
+	sb.WriteByte(':')
+
+	b := &s.blocks[bi]
+	sb.Write(b.digest[:b.dlen])
+	if sum != 0 {
+		sb.WriteByte(ssdeepAlphabet[s.h[bi]])
+	} else if b.digest[b.dlen] != 0 {
+		sb.WriteByte(b.digest[b.dlen])
+	}
+	sb.WriteByte(':')
+
+	if bi < end-1 {
+		b = &s.blocks[bi+1]
+		n := min(b.dlen, ssdeepLength/2-1)
+		sb.Write(b.digest[:n])
+		if sum != 0 {
+			sb.WriteByte(ssdeepAlphabet[s.half[bi+1]])
+		} else if b.halfDigest != 0 {
+			sb.WriteByte(b.halfDigest)
+		}
+	} else if sum != 0 {
+		sb.WriteByte(ssdeepAlphabet[s.h[bi]])
+	}
+	return sb.String()
+}
+
+// An SSDeep is a parsed ssdeep hash, such as
+// 3:AXGBicFlgVNhBGcL6wCrFQEv:AXGHsNhxLsr2C.
+type SSDeep struct {
+	BlockSize uint32
+
+	// The digests for BlockSize and twice BlockSize, with runs of more than
+	// three identical characters shortened to three, as done before
+	// comparing
+	Digest1 string
+	Digest2 string
+
+	raw string
+}
+
+// ParseSSDeep parses an ssdeep hash.
+func ParseSSDeep(s string) (SSDeep, error) {
+	size, rest, ok := strings.Cut(s, ":")
+	if !ok {
+		return SSDeep{}, fmt.Errorf("invalid ssdeep hash %q", s)
+	}
+	d1, d2, ok := strings.Cut(rest, ":")
+	if !ok {
+		return SSDeep{}, fmt.Errorf("invalid ssdeep hash %q", s)
+	}
+	// A file name may follow the hash, as in the output of the ssdeep tool
+	d2, _, _ = strings.Cut(d2, ",")
+	bs, err := strconv.ParseUint(size, 10, 32)
+	if err != nil || bs < ssdeepMinBlockSize || len(d1) > ssdeepLength || len(d2) > ssdeepLength {
+		return SSDeep{}, fmt.Errorf("invalid ssdeep hash %q", s)
+	}
+	for _, d := range []string{d1, d2} {
+		if strings.Trim(d, ssdeepAlphabet) != "" {
+			return SSDeep{}, fmt.Errorf("invalid ssdeep hash %q", s)
+		}
+	}
+	return SSDeep{
+		BlockSize: uint32(bs),
+		Digest1:   eliminateSequences(d1),
+		Digest2:   eliminateSequences(d2),
+		raw:       size + ":" + d1 + ":" + d2,
+	}, nil
+}
+
+// String returns the hash as it was parsed.
+func (h SSDeep) String() string {
+	return h.raw
+}
+
+// eliminateSequences shortens runs of more than three identical characters
+// to three.
+func eliminateSequences(s string) string {
+	if len(s) <= 3 {
+		return s
+	}
+	out := []byte(s[:3])
+	for i := 3; i < len(s); i++ {
+		if s[i] != s[i-1] || s[i] != s[i-2] || s[i] != s[i-3] {
+			out = append(out, s[i])
+		}
+	}
+	return string(out)
+}
+
+// CompareSSDeep returns the similarity of two ssdeep hashes, from 0 for
+// unrelated inputs to 100 for (nearly) identical ones. Hashes can only be
+// compared if their block sizes are equal or differ by a factor of two,
+// otherwise the score is 0.
+func CompareSSDeep(a, b SSDeep) int {
+	switch {
+	case a.BlockSize == b.BlockSize:
+		if a.Digest1 == b.Digest1 && a.Digest2 == b.Digest2 {
+			return 100
+		}
+		return max(ssdeepScore(a.Digest1, b.Digest1, a.BlockSize), ssdeepScore(a.Digest2, b.Digest2, a.BlockSize*2))
+	case a.BlockSize == b.BlockSize*2:
+		return ssdeepScore(a.Digest1, b.Digest2, a.BlockSize)
+	case b.BlockSize == a.BlockSize*2:
+		return ssdeepScore(a.Digest2, b.Digest1, b.BlockSize)
+	}
+	return 0
+}
+
+// ssdeepScore scores two digests of the same block size.
+func ssdeepScore(s1, s2 string, blockSize uint32) int {
+	if len(s1) > ssdeepLength || len(s2) > ssdeepLength || !hasCommonSubstring(s1, s2) {
+		return 0
+	}
+	score := editDistance(s1, s2)
+	score = score * ssdeepLength / (len(s1) + len(s2))
+	score = 100 * score / ssdeepLength
+	if score >= 100 {
+		return 0
+	}
+	score = 100 - score
+
+	// Small block sizes can't produce matches as meaningful as their score
+	// suggests, so the score is capped by the digest length
+	if blockSize >= (99+ssdeepWindow)/ssdeepWindow*ssdeepMinBlockSize {
+		return score
+	}
+	limit := int(blockSize) / ssdeepMinBlockSize * min(len(s1), len(s2))
+	return min(score, limit)
+}
+
+// hasCommonSubstring reports whether s1 and s2 share a substring of the
+// length of the rolling window.
+func hasCommonSubstring(s1, s2 string) bool {
+	if len(s1) < ssdeepWindow || len(s2) < ssdeepWindow {
+		return false
+	}
+	subs := make(map[string]bool, len(s1))
+	for i := 0; i+ssdeepWindow <= len(s1); i++ {
+		subs[s1[i:i+ssdeepWindow]] = true
+	}
+	for i := 0; i+ssdeepWindow <= len(s2); i++ {
+		if subs[s2[i:i+ssdeepWindow]] {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance returns the edit distance of s1 and s2, where insertions and
+// deletions cost 1 and substitutions cost 2.
+func editDistance(s1, s2 string) int {
+	prev := make([]int, len(s2)+1)
+	cur := make([]int, len(s2)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s1); i++ {
+		cur[0] = i
+		for j := 1; j <= len(s2); j++ {
+			cost := 2
+			if s1[i-1] == s2[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(s2)]
+}
@@ -0,0 +1,141 @@
+package fuzzy
+
+import (
+	"testing"
+)
+
+// random returns n pseudo-random bytes, the same for the same seed.
+func random(n int, seed uint32) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		seed = seed*1103515245 + 12345
+		data[i] = byte(seed >> 16)
+	}
+	return data
+}
+
+// modified returns a copy of data with every step-th byte changed.
+func modified(data []byte, step int) []byte {
+	data = append([]byte(nil), data...)
+	for i := step / 2; i < len(data); i += step {
+		data[i]++
+	}
+	return data
+}
+
+func hashOf(data []byte) *Hasher {
+	h := New()
+	h.Write(data)
+	return h
+}
+
+// The hashes of the README of python-ssdeep, computed by the reference
+// implementation.
+const (
+	ssdeepCtph = "3:AXGBicFlgVNhBGcL6wCrFQEv:AXGHsNhxLsr2C"
+	ssdeepCTPH = "3:AXGBicFlIHBGcL6wCrFQEv:AXGH6xLsr2C"
+)
+
+func TestSSDeep(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty", "", "3::"},
+		{"Ctph", "Also called fuzzy hashes, Ctph can match inputs that have homologies.", ssdeepCtph},
+		{"CTPH", "Also called fuzzy hashes, CTPH can match inputs that have homologies.", ssdeepCTPH},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Written in two parts, to hash across writes
+			h := New()
+			h.Write([]byte(tt.input[:len(tt.input)/2]))
+			h.Write([]byte(tt.input[len(tt.input)/2:]))
+			if got := h.SSDeep(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareSSDeep(t *testing.T) {
+	data := random(20000, 1)
+	tests := []struct {
+		name string
+		a, b string
+		// Range of the expected score
+		min, max int
+	}{
+		{"README", ssdeepCtph, ssdeepCTPH, 22, 22},
+		// Scores of the reference implementation, as in the tests of
+		// github.com/glaslos/ssdeep
+		{
+			"block size 192",
+			"192:MUPMinqP6+wNQ7Q40L/iB3n2rIBrP0GZKF4jsef+0FVQLSwbLbj41iH8nFVYv980:x0CllivQiFmt",
+			"192:JkjRcePWsNVQza3ntZStn5VfsoXMhRD9+xJMinqF6+wNQ7Q40L/i737rPVt:JkjlQyIrx+kll2",
+			35, 35,
+		},
+		{
+			"block size 196608",
+			"196608:pDSC8olnoL1v/uawvbQD7XlZUFYzYyMb615NktYHF7dREN/JNnQrmhnUPI+/n2Yr:5DHoJXv7XOq7Mb2TwYHXREN/3QrmktPd",
+			"196608:7DSC8olnoL1v/uawvbQD7XlZUFYzYyMb615NktYHF7dREN/JNnQrmhnUPI+/n2Y7:3DHoJXv7XOq7Mb2TwYHXREN/3QrmktPt",
+			97, 97,
+		},
+		{
+			"block size 24",
+			"24:YDVLfsT1ds/1H9Wpgq7n4XMijV6h4Z3QCw4qat:YD51H9CiMuV6uACwVat",
+			"24:YDVLfyvDj+C+opg8DV0Mdle6hPZ3QCw4qat:YDMvDj+C+kBOM+6HACwVat",
+			54, 54,
+		},
+		{"identical", hashOf(data).SSDeep(), hashOf(data).SSDeep(), 100, 100},
+		{"similar", hashOf(data).SSDeep(), hashOf(modified(data, 5000)).SSDeep(), 50, 99},
+		{"unrelated", hashOf(data).SSDeep(), hashOf(random(20000, 2)).SSDeep(), 0, 0},
+		{"block sizes too far apart", hashOf(data).SSDeep(), hashOf(data[:2000]).SSDeep(), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := ParseSSDeep(tt.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ParseSSDeep(tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			score := CompareSSDeep(a, b)
+			if score < tt.min || score > tt.max {
+				t.Errorf("got score %d, want %d to %d", score, tt.min, tt.max)
+			}
+			if reverse := CompareSSDeep(b, a); reverse != score {
+				t.Errorf("got score %d in reverse, want %d", reverse, score)
+			}
+		})
+	}
+}
+
+func TestParseSSDeep(t *testing.T) {
+	tests := []struct {
+		hash    string
+		wantErr bool
+	}{
+		{ssdeepCtph, false},
+		{"3::", false},
+		{"", true},
+		{"3:AXGBicFlgVNhBGcL6wCrFQEv", true},
+		{"0:AXGB:AXGH", true},
+		{"x:AXGB:AXGH", true},
+		{"3:AX,B:AXGH", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hash, func(t *testing.T) {
+			h, err := ParseSSDeep(tt.hash)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && h.String() != tt.hash {
+				t.Errorf("formatted as %s", h)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package fuzzy
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Parameters of the TLSH algorithm, using 128 buckets and a 1 byte checksum.
+const (
+	tlshWindow    = 5
+	tlshBuckets   = 128
+	tlshCodeSize  = tlshBuckets / 4
+	tlshMinLength = 50
+	tlshPrefix    = "T1"
+	tlshHexLength = 2 * (3 + tlshCodeSize)
+)
+
+// tlshTable is the Pearson hashing table of TLSH.
+var tlshTable = [256]byte{
+	1, 87, 49, 12, 176, 178, 102, 166, 121, 193, 6, 84, 249, 230, 44, 163,
+	14, 197, 213, 181, 161, 85, 218, 80, 64, 239, 24, 226, 236, 142, 38, 200,
+	110, 177, 104, 103, 141, 253, 255, 50, 77, 101, 81, 18, 45, 96, 31, 222,
+	25, 107, 190, 70, 86, 237, 240, 34, 72, 242, 20, 214, 244, 227, 149, 235,
+	97, 234, 57, 22, 60, 250, 82, 175, 208, 5, 127, 199, 111, 62, 135, 248,
+	174, 169, 211, 58, 66, 154, 106, 195, 245, 171, 17, 187, 182, 179, 0, 243,
+	132, 56, 148, 75, 128, 133, 158, 100, 130, 126, 91, 13, 153, 246, 216, 219,
+	119, 68, 223, 78, 83, 88, 201, 99, 122, 11, 92, 32, 136, 114, 52, 10,
+	138, 30, 48, 183, 156, 35, 61, 26, 143, 74, 251, 94, 129, 162, 63, 152,
+	170, 7, 115, 167, 241, 206, 3, 150, 55, 59, 151, 220, 90, 53, 23, 131,
+	125, 173, 15, 238, 79, 95, 89, 16, 105, 137, 225, 224, 217, 160, 37, 123,
+	118, 73, 2, 157, 46, 116, 9, 145, 134, 228, 207, 212, 202, 215, 69, 229,
+	27, 188, 67, 124, 168, 252, 42, 4, 29, 108, 21, 247, 19, 205, 39, 203,
+	233, 40, 186, 147, 198, 192, 155, 33, 164, 191, 98, 204, 165, 180, 117, 76,
+	140, 36, 210, 172, 41, 54, 159, 8, 185, 232, 113, 196, 231, 47, 146, 120,
+	51, 65, 28, 144, 254, 221, 93, 189, 194, 139, 112, 43, 71, 109, 184, 209,
+}
+
+func tlshMapping(salt, i, j, k byte) byte {
+	h := tlshTable[salt]
+	h = tlshTable[h^i]
+	h = tlshTable[h^j]
+	return tlshTable[h^k]
+}
+
+// tlshState counts the triplets of every window of five bytes into buckets.
+type tlshState struct {
+	buckets  [256]uint32
+	window   [tlshWindow - 1]byte
+	checksum byte
+	length   uint64
+}
+
+func (s *tlshState) write(p []byte) {
+	// The window is kept in locals, a being the newest byte
+	b, c, d, e := s.window[0], s.window[1], s.window[2], s.window[3]
+	checksum := s.checksum
+	buckets := &s.buckets
+	for i, a := range p {
+		if s.length+uint64(i) >= tlshWindow-1 {
+			checksum = tlshMapping(0, a, b, checksum)
+			buckets[tlshMapping(2, a, b, c)]++
+			buckets[tlshMapping(3, a, b, d)]++
+			buckets[tlshMapping(5, a, c, d)]++
+			buckets[tlshMapping(7, a, c, e)]++
+			buckets[tlshMapping(11, a, b, e)]++
+			buckets[tlshMapping(13, a, d, e)]++
+		}
+		b, c, d, e = a, b, c, d
+	}
+	s.window = [tlshWindow - 1]byte{b, c, d, e}
+	s.checksum = checksum
+	s.length += uint64(len(p))
+}
+
+// digest returns the TLSH hash of the input written so far, or an empty
+// string if the input is too short or too uniform to be hashed.
+func (s *tlshState) digest() string {
+	if s.length < tlshMinLength {
+		return ""
+	}
+	sorted := slices.Clone(s.buckets[:tlshBuckets])
+	slices.Sort(sorted)
+	q1, q2, q3 := sorted[tlshBuckets/4-1], sorted[tlshBuckets/2-1], sorted[tlshBuckets*3/4-1]
+	if q3 == 0 {
+		return ""
+	}
+	nonZero := 0
+	for _, n := range s.buckets[:tlshBuckets] {
+		if n > 0 {
+			nonZero++
+		}
+	}
+	if nonZero <= tlshBuckets/2 {
+		return ""
+	}
+
+	h := TLSH{
+		Checksum: s.checksum,
+		Length:   tlshLength(s.length),
+		Q1Ratio:  byte(uint64(q1) * 100 / uint64(q3) % 16),
+		Q2Ratio:  byte(uint64(q2) * 100 / uint64(q3) % 16),
+	}
+	for i := range tlshCodeSize {
+		var code byte
+		for j := range 4 {
+			n := s.buckets[4*i+j]
+			switch {
+			case q3 < n:
+				code |= 3 << (j * 2)
+			case q2 < n:
+				code |= 2 << (j * 2)
+			case q1 < n:
+				code |= 1 << (j * 2)
+			}
+		}
+		h.Code[i] = code
+	}
+	return h.String()
+}
+
+// tlshLength encodes the length of the input logarithmically.
+func tlshLength(n uint64) byte {
+	l := math.Log(float64(n))
+	var i int
+	switch {
+	case n <= 656:
+		i = int(math.Floor(l / 0.4054651))
+	case n <= 3199:
+		i = int(math.Floor(l/0.26236426 - 8.72777))
+	default:
+		i = int(math.Floor(l/0.095310180 - 62.5472))
+	}
+	return byte(i & 0xff)
+}
+
+// A TLSH is a parsed TLSH hash, such as T1 followed by 70 hex digits.
+type TLSH struct {
+	Checksum byte
+	Length   byte
+	Q1Ratio  byte
+	Q2Ratio  byte
+	Code     [tlshCodeSize]byte
+}
+
+func swapNibbles(b byte) byte {
+	return b<<4 | b>>4
+}
+
+// ParseTLSH parses a TLSH hash. The T1 prefix of current versions is
+// optional.
+func ParseTLSH(s string) (TLSH, error) {
+	raw := strings.TrimPrefix(s, tlshPrefix)
+	if len(raw) != tlshHexLength {
+		return TLSH{}, fmt.Errorf("invalid TLSH hash %q", s)
+	}
+	b, err := hex.DecodeString(raw)
+	if err != nil {
+		return TLSH{}, fmt.Errorf("invalid TLSH hash %q", s)
+	}
+	q := swapNibbles(b[2])
+	h := TLSH{
+		Checksum: swapNibbles(b[0]),
+		Length:   swapNibbles(b[1]),
+		Q1Ratio:  q & 0x0f,
+		Q2Ratio:  q >> 4,
+	}
+	for i := range tlshCodeSize {
+		h.Code[i] = b[len(b)-1-i]
+	}
+	return h, nil
+}
+
+// String returns the hash in the format of current TLSH versions.
+func (h TLSH) String() string {
+	b := make([]byte, 0, 3+tlshCodeSize)
+	b = append(b, swapNibbles(h.Checksum), swapNibbles(h.Length), swapNibbles(h.Q2Ratio<<4|h.Q1Ratio))
+	for i := tlshCodeSize - 1; i >= 0; i-- {
+		b = append(b, h.Code[i])
+	}
+	return tlshPrefix + strings.ToUpper(hex.EncodeToString(b))
+}
+
+// modDiff returns the distance of x and y on a circle of size r.
+func modDiff(x, y, r int) int {
+	d := x - y
+	if d < 0 {
+		d = -d
+	}
+	return min(d, r-d)
+}
+
+// DiffTLSH returns the distance of two TLSH hashes. Identical inputs have a
+// distance of 0, and the distance grows as the inputs become less similar.
+// Distances below 50 or so indicate closely related inputs.
+func DiffTLSH(a, b TLSH) int {
+	diff := 0
+	switch d := modDiff(int(a.Length), int(b.Length), 256); d {
+	case 0, 1:
+		diff += d
+	default:
+		diff += d * 12
+	}
+	for _, d := range []int{modDiff(int(a.Q1Ratio), int(b.Q1Ratio), 16), modDiff(int(a.Q2Ratio), int(b.Q2Ratio), 16)} {
+		if d <= 1 {
+			diff += d
+		} else {
+			diff += (d - 1) * 12
+		}
+	}
+	if a.Checksum != b.Checksum {
+		diff++
+	}
+	for i := range a.Code {
+		x, y := a.Code[i], b.Code[i]
+		for j := 0; j < 8; j += 2 {
+			d := int(x>>j&3) - int(y>>j&3)
+			if d < 0 {
+				d = -d
+			}
+			if d == 3 {
+				d = 6
+			}
+			diff += d
+		}
+	}
+	return diff
+}
@@ -0,0 +1,98 @@
+package fuzzy
+
+import (
+	"bytes"
+	"testing"
+)
+
+// No TLSH tool was at hand to hash these inputs: the expected hashes were
+// computed by a separate port of the reference implementation.
+func TestTLSH(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  string
+	}{
+		{"Ctph", []byte("Also called fuzzy hashes, Ctph can match inputs that have homologies."), "T1A2A022A3CC0FB00C8C0222228B82082A8E02E0F2C28002A8CC0CAC0E022023E00C30F0"},
+		{"CTPH", []byte("Also called fuzzy hashes, CTPH can match inputs that have homologies."), "T1FFA022E38E0BA80A8C0032238382002A8E3AC0BAC28022A8CA0C2E0F020023F00C38F0"},
+		{"100 bytes", random(100, 1), "T125B012465415D9028005EB9C24A838844B1601E7E55C36A35430182282D0284CA804A5"},
+		{"1000 bytes", random(1000, 1), "T1D71198D7171DD7C30188165823F51568B7597773DBEC311F40200960EEF0B9780AD169"},
+		{"5000 bytes", random(5000, 1), "T180A19DFF062DD5716844F010D1F5067C7B2897F2DACD3D2AD8144590A6A83C3D2EE848"},
+		{"100000 bytes", random(100000, 1), "T187A312EA315D90A64A88E709D91C35B57843EA93D0773DFA5FE00F541638A8B38F8C97"},
+		{"too short", random(tlshMinLength-1, 1), ""},
+		{"too uniform", bytes.Repeat([]byte("a"), 1000), ""},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Written in two parts, to hash across writes
+			h := New()
+			h.Write(tt.input[:len(tt.input)/2])
+			h.Write(tt.input[len(tt.input)/2:])
+			if got := h.TLSH(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffTLSH(t *testing.T) {
+	data := random(20000, 1)
+	tests := []struct {
+		name string
+		a, b string
+		// Range of the expected distance
+		min, max int
+	}{
+		{"README", "T1A2A022A3CC0FB00C8C0222228B82082A8E02E0F2C28002A8CC0CAC0E022023E00C30F0", "T1FFA022E38E0BA80A8C0032238382002A8E3AC0BAC28022A8CA0C2E0F020023F00C38F0", 48, 48},
+		{"identical", hashOf(data).TLSH(), hashOf(data).TLSH(), 0, 0},
+		{"similar", hashOf(data).TLSH(), hashOf(modified(data, 5000)).TLSH(), 1, 20},
+		{"unrelated", hashOf(data).TLSH(), hashOf(random(20000, 2)).TLSH(), 100, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := ParseTLSH(tt.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ParseTLSH(tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			distance := DiffTLSH(a, b)
+			if distance < tt.min || distance > tt.max {
+				t.Errorf("got distance %d, want %d to %d", distance, tt.min, tt.max)
+			}
+			if reverse := DiffTLSH(b, a); reverse != distance {
+				t.Errorf("got distance %d in reverse, want %d", reverse, distance)
+			}
+		})
+	}
+}
+
+func TestParseTLSH(t *testing.T) {
+	const hash = "T1A2A022A3CC0FB00C8C0222228B82082A8E02E0F2C28002A8CC0CAC0E022023E00C30F0"
+	tests := []struct {
+		name    string
+		hash    string
+		wantErr bool
+	}{
+		{"current", hash, false},
+		{"without prefix", hash[len(tlshPrefix):], false},
+		{"lowercase", "T1a2a022a3cc0fb00c8c0222228b82082a8e02e0f2c28002a8cc0cac0e022023e00c30f0", false},
+		{"empty", "", true},
+		{"truncated", hash[:len(hash)-2], true},
+		{"not hexadecimal", hash[:len(hash)-1] + "G", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ParseTLSH(tt.hash)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && h.String() != hash {
+				t.Errorf("formatted as %s, want %s", h, hash)
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Refs returns the refs of the repository by name, such as HEAD and
+// refs/heads/main, resolving symbolic refs. Refs that can't be resolved are
+// left out.
+func (r *Repo) Refs() (map[string]Hash, error) {
+	values := make(map[string]string)
+
+	packed, err := os.ReadFile(filepath.Join(r.dir, "packed-refs"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(packed), "\n") {
+		// Lines starting with ^ are the targets of the annotated tag before
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		if hash, name, ok := strings.Cut(line, " "); ok {
+			values[name] = hash
+		}
+	}
+
+	// Loose refs take precedence over packed ones
+	err = filepath.WalkDir(filepath.Join(r.dir, "refs"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(r.dir, p)
+		values[filepath.ToSlash(name)] = strings.TrimSpace(string(data))
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if head, err := os.ReadFile(filepath.Join(r.dir, "HEAD")); err == nil {
+		values["HEAD"] = strings.TrimSpace(string(head))
+	}
+
+	refs := make(map[string]Hash)
+	for name := range values {
+		value := values[name]
+		for i := 0; i < 5; i++ {
+			target, ok := strings.CutPrefix(value, "ref: ")
+			if !ok {
+				break
+			}
+			value = values[target]
+		}
+		if h, err := ParseHash(value); err == nil {
+			refs[name] = h
+		}
+	}
+	return refs, nil
+}
+
+// An Occurrence is a commit in which a blob appears, and its path in the
+// tree of the commit.
+type Occurrence struct {
+	Commit Hash
+	Path   string
+}
+
+func (o Occurrence) String() string {
+	return o.Commit.String() + ":" + o.Path
+}
+
+// A HistoryBlob is a blob reachable from the refs of a repository.
+type HistoryBlob struct {
+	Hash Hash
+
+	// For every path the blob has, the first commit in which it appears
+	// there, parents before children. Empty if the blob is only referenced
+	// by a tag
+	Occurrences []Occurrence
+}
+
+// History returns the blobs reachable from all the refs of the repository,
+// each one once, in the order they are added to the history, parents
+// before children. Submodules and symbolic links are left out.
+//
+// Commits missing from the repository, such as the parents of the oldest
+// commits of a shallow clone, are skipped.
+func (r *Repo) History(ctx context.Context) ([]*HistoryBlob, error) {
+	refs, err := r.Refs()
+	if err != nil {
+		return nil, err
+	}
+	w := &historyWalk{
+		repo:    r,
+		ctx:     ctx,
+		commits: make(map[Hash]*commitInfo),
+		trees:   make(map[string]bool),
+		blobs:   make(map[Hash]*HistoryBlob),
+	}
+
+	// Peel annotated tags down to what they point to
+	var tips, trees []Hash
+	for _, h := range refs {
+		for depth := 0; ; depth++ {
+			typ, data, err := r.Object(h)
+			if errors.Is(err, ErrNotFound) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if typ == Tag && depth < 10 {
+				target, err := headerHash(data, "object")
+				if err != nil {
+					return nil, fmt.Errorf("tag %s: %w", h, err)
+				}
+				h = target
+				continue
+			}
+			switch typ {
+			case Commit:
+				tips = append(tips, h)
+			case Tree:
+				trees = append(trees, h)
+			case Blob:
+				w.addBlob(h, Occurrence{}, false)
+			}
+			break
+		}
+	}
+
+	order, err := w.commitOrder(tips)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range order {
+		if err := w.walkTree(w.commits[c].tree, c, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range trees {
+		if err := w.walkTree(t, Hash{}, false); err != nil {
+			return nil, err
+		}
+	}
+	return w.order, nil
+}
+
+type commitInfo struct {
+	tree    Hash
+	parents []Hash
+}
+
+type historyWalk struct {
+	repo    *Repo
+	ctx     context.Context
+	commits map[Hash]*commitInfo
+
+	// Trees already walked, by hash and path. A tree walked again at the
+	// same path can't hold anything new
+	trees map[string]bool
+
+	blobs map[Hash]*HistoryBlob
+	order []*HistoryBlob
+}
+
+// commitOrder returns the commits reachable from tips, parents before
+// children.
+func (w *historyWalk) commitOrder(tips []Hash) ([]Hash, error) {
+	var order []Hash
+	type frame struct {
+		hash     Hash
+		expanded bool
+	}
+	visited := make(map[Hash]bool)
+	var stack []frame
+	for _, tip := range tips {
+		stack = append(stack, frame{hash: tip})
+	}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if f.expanded {
+			order = append(order, f.hash)
+			continue
+		}
+		if visited[f.hash] {
+			continue
+		}
+		visited[f.hash] = true
+		if err := w.ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		typ, data, err := w.repo.Object(f.hash)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if typ != Commit {
+			return nil, fmt.Errorf("object %s: not a commit", f.hash)
+		}
+		info, err := parseCommit(data)
+		if err != nil {
+			return nil, fmt.Errorf("commit %s: %w", f.hash, err)
+		}
+		w.commits[f.hash] = info
+		stack = append(stack, frame{hash: f.hash, expanded: true})
+		for _, parent := range info.parents {
+			if !visited[parent] {
+				stack = append(stack, frame{hash: parent})
+			}
+		}
+	}
+	return order, nil
+}
+
+// walkTree adds the blobs of the tree named root, found in commit if
+// inCommit is set.
+func (w *historyWalk) walkTree(root, commit Hash, inCommit bool) error {
+	type dir struct {
+		hash Hash
+		path string
+	}
+	stack := []dir{{hash: root}}
+	for len(stack) > 0 {
+		d := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		key := string(d.hash[:]) + d.path
+		if w.trees[key] {
+			continue
+		}
+		w.trees[key] = true
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+
+		typ, data, err := w.repo.Object(d.hash)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if typ != Tree {
+			return fmt.Errorf("object %s: not a tree", d.hash)
+		}
+		for len(data) > 0 {
+			// Entries are "<octal mode> <name>\0<hash>"
+			mode, rest, ok := bytes.Cut(data, []byte(" "))
+			if !ok {
+				return fmt.Errorf("tree %s: invalid entry", d.hash)
+			}
+			name, rest, ok := bytes.Cut(rest, []byte{0})
+			if !ok || len(rest) < len(Hash{}) {
+				return fmt.Errorf("tree %s: invalid entry", d.hash)
+			}
+			var h Hash
+			copy(h[:], rest)
+			data = rest[len(h):]
+
+			p := path.Join(d.path, string(name))
+			switch string(mode) {
+			case "40000":
+				stack = append(stack, dir{hash: h, path: p})
+			case "160000", "120000":
+				// Submodules and symbolic links
+			default:
+				w.addBlob(h, Occurrence{Commit: commit, Path: p}, inCommit)
+			}
+		}
+	}
+	return nil
+}
+
+// addBlob records the blob named h, and where it occurs if inCommit is set.
+func (w *historyWalk) addBlob(h Hash, o Occurrence, inCommit bool) {
+	b := w.blobs[h]
+	if b == nil {
+		b = &HistoryBlob{Hash: h}
+		w.blobs[h] = b
+		w.order = append(w.order, b)
+	}
+	if !inCommit {
+		return
+	}
+	for _, seen := range b.Occurrences {
+		if seen.Path == o.Path {
+			return
+		}
+	}
+	b.Occurrences = append(b.Occurrences, o)
+}
+
+// parseCommit returns the tree and the parents of a commit.
+func parseCommit(data []byte) (*commitInfo, error) {
+	info := &commitInfo{}
+	tree, err := headerHash(data, "tree")
+	if err != nil {
+		return nil, err
+	}
+	info.tree = tree
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() && sc.Text() != "" {
+		if value, ok := strings.CutPrefix(sc.Text(), "parent "); ok {
+			parent, err := ParseHash(value)
+			if err != nil {
+				return nil, err
+			}
+			info.parents = append(info.parents, parent)
+		}
+	}
+	return info, nil
+}
+
+// headerHash returns the hash in the header named key of a commit or tag.
+func headerHash(data []byte, key string) (Hash, error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, key+" "); ok {
+			return ParseHash(value)
+		}
+	}
+	return Hash{}, fmt.Errorf("no %s header", key)
+}
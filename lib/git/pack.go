@@ -0,0 +1,406 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Types of packed objects that are deltas against another object, in
+// addition to the ObjectTypes.
+const (
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+// Resolved delta bases are cached up to this many bytes per packfile, as
+// objects are often bases of several others.
+const packCacheSize = 32 << 20
+
+// Maximum number of deltas between an object and its base, counting those
+// of the bases of ref deltas, which may be in other packfiles. Git never
+// writes chains longer than 4095, so longer ones are most likely cycles.
+const maxDeltaChain = 10000
+
+var errDeltaChain = errors.New("delta chain too long")
+
+// A pack is a packfile and its index.
+type pack struct {
+	f *os.File
+
+	// Object names, sorted, and their offsets in the packfile
+	names   []Hash
+	offsets []int64
+
+	// Number of names starting with each byte value or less
+	fanout [256]uint32
+
+	cache     map[int64]cachedObject
+	cacheSize int
+}
+
+type cachedObject struct {
+	typ  ObjectType
+	data []byte
+}
+
+// openPack opens the packfile at path.pack and reads its index at path.idx,
+// in version 1 or 2.
+func openPack(path string) (*pack, error) {
+	idx, err := os.ReadFile(path + ".idx")
+	if err != nil {
+		return nil, err
+	}
+	p := &pack{cache: make(map[int64]cachedObject)}
+	if err := p.readIndex(idx); err != nil {
+		return nil, fmt.Errorf("%s.idx: %w", path, err)
+	}
+	if p.f, err = os.Open(path + ".pack"); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+var errInvalidIndex = errors.New("invalid pack index")
+
+func (p *pack) readIndex(idx []byte) error {
+	// Version 2 starts with a magic number, version 1 with the fanout table
+	v2 := bytes.HasPrefix(idx, []byte("\xfftOc"))
+	if v2 {
+		if len(idx) < 8 || binary.BigEndian.Uint32(idx[4:]) != 2 {
+			return errors.New("unsupported pack index version")
+		}
+		idx = idx[8:]
+	}
+	if len(idx) < 256*4 {
+		return errInvalidIndex
+	}
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(idx[4*i:])
+		if i > 0 && p.fanout[i] < p.fanout[i-1] {
+			return errInvalidIndex
+		}
+	}
+	idx = idx[256*4:]
+	n := int(p.fanout[255])
+	p.names = make([]Hash, n)
+	p.offsets = make([]int64, n)
+
+	if !v2 {
+		if len(idx) < n*24 {
+			return errInvalidIndex
+		}
+		for i := range n {
+			entry := idx[24*i:]
+			p.offsets[i] = int64(binary.BigEndian.Uint32(entry))
+			copy(p.names[i][:], entry[4:24])
+		}
+		return nil
+	}
+
+	// Names, CRCs, offsets and offsets larger than 2 GiB
+	if len(idx) < n*(20+4+4) {
+		return errInvalidIndex
+	}
+	for i := range n {
+		copy(p.names[i][:], idx[20*i:])
+	}
+	offsets := idx[n*(20+4):]
+	large := offsets[n*4:]
+	for i := range n {
+		offset := binary.BigEndian.Uint32(offsets[4*i:])
+		if offset&0x80000000 == 0 {
+			p.offsets[i] = int64(offset)
+			continue
+		}
+		j := int(offset &^ 0x80000000)
+		if len(large) < 8*(j+1) {
+			return errInvalidIndex
+		}
+		p.offsets[i] = int64(binary.BigEndian.Uint64(large[8*j:]))
+	}
+	return nil
+}
+
+func (p *pack) Close() error {
+	return p.f.Close()
+}
+
+// find returns the offset of the object named h in the packfile.
+func (p *pack) find(h Hash) (int64, bool) {
+	lo := 0
+	if h[0] > 0 {
+		lo = int(p.fanout[h[0]-1])
+	}
+	hi := int(p.fanout[h[0]])
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.names[lo+i][:], h[:]) >= 0
+	})
+	if i < hi && p.names[i] == h {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// A packEntry is the header of an object in a packfile.
+type packEntry struct {
+	typ  int
+	size int64
+
+	// The base of a delta, at baseOffset if typ is packOfsDelta, or named
+	// baseName if typ is packRefDelta
+	baseOffset int64
+	baseName   Hash
+
+	// Reads the compressed data after the header
+	r *bufio.Reader
+}
+
+// entry reads the header of the object at offset.
+func (p *pack) entry(offset int64) (*packEntry, error) {
+	r := bufio.NewReader(io.NewSectionReader(p.f, offset, 1<<62))
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	e := &packEntry{typ: int(b>>4) & 7, size: int64(b & 0x0f), r: r}
+	for shift := 4; b&0x80 != 0; shift += 7 {
+		if b, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+		if shift > 56 {
+			return nil, errors.New("invalid packed object size")
+		}
+		e.size |= int64(b&0x7f) << shift
+	}
+
+	switch e.typ {
+	case packOfsDelta:
+		// The offset of the base relative to this object, big-endian, with
+		// 1 added to every byte but the last
+		if b, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+		rel := int64(b & 0x7f)
+		for b&0x80 != 0 {
+			if b, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+			if rel > 1<<48 {
+				return nil, errors.New("invalid delta base offset")
+			}
+			rel = (rel+1)<<7 | int64(b&0x7f)
+		}
+		if rel <= 0 || rel > offset {
+			return nil, errors.New("invalid delta base offset")
+		}
+		e.baseOffset = offset - rel
+	case packRefDelta:
+		if _, err := io.ReadFull(r, e.baseName[:]); err != nil {
+			return nil, err
+		}
+	case int(Commit), int(Tree), int(Blob), int(Tag):
+	default:
+		return nil, fmt.Errorf("invalid packed object type %d", e.typ)
+	}
+	return e, nil
+}
+
+// inflate returns the decompressed data of e.
+func (e *packEntry) inflate() ([]byte, error) {
+	if e.size > maxObjectSize {
+		return nil, errors.New("object too large")
+	}
+	zr, err := zlib.NewReader(e.r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	data := make([]byte, e.size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// object returns the type and contents of the object at offset, resolving
+// deltas. The bases of ref deltas are looked up in r. depth is the number of
+// deltas already followed to get to the object.
+func (p *pack) object(r *Repo, offset int64, depth int) (ObjectType, []byte, error) {
+	// Follow the chain of deltas down to a base, then apply them back up
+	type delta struct {
+		offset int64
+		data   []byte
+	}
+	var chain []delta
+	var typ ObjectType
+	var data []byte
+	for {
+		if cached, ok := p.cache[offset]; ok {
+			typ, data = cached.typ, cached.data
+			break
+		}
+		if depth+len(chain) > maxDeltaChain {
+			return 0, nil, errDeltaChain
+		}
+		e, err := p.entry(offset)
+		if err != nil {
+			return 0, nil, fmt.Errorf("packed object at %d: %w", offset, err)
+		}
+		payload, err := e.inflate()
+		if err != nil {
+			return 0, nil, fmt.Errorf("packed object at %d: %w", offset, err)
+		}
+		if e.typ != packOfsDelta && e.typ != packRefDelta {
+			typ, data = ObjectType(e.typ), payload
+			if len(chain) > 0 {
+				p.cachePut(offset, typ, data)
+			}
+			break
+		}
+		chain = append(chain, delta{offset, payload})
+		if e.typ == packOfsDelta {
+			offset = e.baseOffset
+			continue
+		}
+		if typ, data, err = r.object(e.baseName, depth+len(chain)); err != nil {
+			return 0, nil, err
+		}
+		break
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		var err error
+		if data, err = applyDelta(data, chain[i].data); err != nil {
+			return 0, nil, fmt.Errorf("packed object at %d: %w", chain[i].offset, err)
+		}
+		// Intermediate objects are the bases of the following ones
+		if i > 0 {
+			p.cachePut(chain[i].offset, typ, data)
+		}
+	}
+	return typ, data, nil
+}
+
+func (p *pack) cachePut(offset int64, typ ObjectType, data []byte) {
+	if len(data) > packCacheSize/4 {
+		return
+	}
+	if p.cacheSize+len(data) > packCacheSize {
+		clear(p.cache)
+		p.cacheSize = 0
+	}
+	p.cache[offset] = cachedObject{typ, data}
+	p.cacheSize += len(data)
+}
+
+// stat returns the type and size of the object at offset. Only the start of
+// deltas is decompressed, to read the size of their result, but the chain of
+// deltas is followed down to its base for the type. depth is as for object.
+func (p *pack) stat(r *Repo, offset int64, depth int) (ObjectType, int64, error) {
+	size := int64(-1)
+	for ; ; depth++ {
+		if depth > maxDeltaChain {
+			return 0, 0, errDeltaChain
+		}
+		e, err := p.entry(offset)
+		if err != nil {
+			return 0, 0, fmt.Errorf("packed object at %d: %w", offset, err)
+		}
+		if e.typ != packOfsDelta && e.typ != packRefDelta {
+			if size < 0 {
+				size = e.size
+			}
+			return ObjectType(e.typ), size, nil
+		}
+		if size < 0 {
+			zr, err := zlib.NewReader(e.r)
+			if err != nil {
+				return 0, 0, fmt.Errorf("packed object at %d: %w", offset, err)
+			}
+			br := bufio.NewReaderSize(zr, 32)
+			_, err = binary.ReadUvarint(br)
+			if err == nil {
+				var target uint64
+				target, err = binary.ReadUvarint(br)
+				size = int64(target)
+			}
+			zr.Close()
+			if err != nil {
+				return 0, 0, fmt.Errorf("packed object at %d: %w", offset, err)
+			}
+		}
+		if e.typ == packRefDelta {
+			typ, _, err := r.stat(e.baseName, depth+1)
+			return typ, size, err
+		}
+		offset = e.baseOffset
+	}
+}
+
+var errInvalidDelta = errors.New("invalid delta")
+
+// applyDelta returns the result of applying delta to base.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	d := bytes.NewReader(delta)
+	baseSize, err := binary.ReadUvarint(d)
+	if err != nil || baseSize != uint64(len(base)) {
+		return nil, errInvalidDelta
+	}
+	size, err := binary.ReadUvarint(d)
+	if err != nil || size > maxObjectSize {
+		return nil, errInvalidDelta
+	}
+	out := make([]byte, 0, size)
+
+	for d.Len() > 0 {
+		op, _ := d.ReadByte()
+		switch {
+		case op&0x80 != 0:
+			// Copy from base: bits 0-3 select the bytes of the offset, and
+			// bits 4-6 those of the size, that follow
+			var offset, n uint64
+			for i := range 7 {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				b, err := d.ReadByte()
+				if err != nil {
+					return nil, errInvalidDelta
+				}
+				if i < 4 {
+					offset |= uint64(b) << (8 * i)
+				} else {
+					n |= uint64(b) << (8 * (i - 4))
+				}
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if offset+n > uint64(len(base)) || uint64(len(out))+n > size {
+				return nil, errInvalidDelta
+			}
+			out = append(out, base[offset:offset+n]...)
+		case op != 0:
+			// Insert the op bytes that follow
+			if int(op) > d.Len() || uint64(len(out))+uint64(op) > size {
+				return nil, errInvalidDelta
+			}
+			start := len(delta) - d.Len()
+			out = append(out, delta[start:start+int(op)]...)
+			d.Seek(int64(op), io.SeekCurrent)
+		default:
+			return nil, errInvalidDelta
+		}
+	}
+	if uint64(len(out)) != size {
+		return nil, errInvalidDelta
+	}
+	return out, nil
+}
@@ -0,0 +1,73 @@
+package git
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func blobName(content string) Hash {
+	return sha1.Sum(fmt.Appendf(nil, "blob %d\x00%s", len(content), content))
+}
+
+// The repositories are written by testdata/gen.go.
+func TestPackObject(t *testing.T) {
+	const (
+		base   = "The quick brown fox jumps over the lazy dog\n"
+		first  = "The quick brown fox jumps over the lazy cat\n"
+		second = "The quick red fox jumps over the lazy cat\n"
+		ref    = "The quick brown fox sleeps\n"
+	)
+	tests := []struct {
+		name    string
+		repo    string
+		object  Hash
+		want    string
+		wantErr bool
+
+		// If set, the error Object and Stat return must wrap it
+		errIs error
+	}{
+		{name: "base", repo: "deltas", object: blobName(base), want: base},
+		{name: "ofs delta", repo: "deltas", object: blobName(first), want: first},
+		{name: "ofs delta chain", repo: "deltas", object: blobName(second), want: second},
+		{name: "ref delta", repo: "deltas", object: blobName(ref), want: ref},
+		{name: "copy past base", repo: "deltas", object: blobName("bad copy"), wantErr: true},
+		{name: "wrong base size", repo: "deltas", object: blobName("bad base"), wantErr: true},
+		{name: "missing base", repo: "deltas", object: blobName("missing base"), wantErr: true, errIs: ErrNotFound},
+		{name: "ref delta cycle", repo: "cycle", object: blobName("x"), wantErr: true, errIs: errDeltaChain},
+		{name: "truncated base", repo: "truncated", object: blobName(base), want: base},
+		{name: "truncated delta", repo: "truncated", object: blobName(first), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Open("testdata/" + tt.repo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			typ, data, err := r.Object(tt.object)
+			if tt.wantErr {
+				if err == nil || tt.errIs != nil && !errors.Is(err, tt.errIs) {
+					t.Fatalf("got error %v, want %v", err, tt.errIs)
+				}
+			} else if err != nil || typ != Blob || string(data) != tt.want {
+				t.Fatalf("got %v %q, %v, want blob %q", typ, data, err, tt.want)
+			}
+
+			// Stat only follows the chain to its base, so it doesn't notice
+			// invalid deltas
+			typ, size, err := r.Stat(tt.object)
+			switch {
+			case tt.errIs != nil:
+				if !errors.Is(err, tt.errIs) {
+					t.Errorf("Stat: got error %v, want %v", err, tt.errIs)
+				}
+			case !tt.wantErr && (err != nil || typ != Blob || size != int64(len(tt.want))):
+				t.Errorf("Stat: got %v %d, %v, want blob %d", typ, size, err, len(tt.want))
+			}
+		})
+	}
+}
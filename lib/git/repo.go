@@ -0,0 +1,276 @@
+// Package git reads the objects and refs of a Git repository directly from
+// its object store, without the git command. Only repositories using SHA-1
+// object names are supported.
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A Hash is the name of an object, the SHA-1 hash of its contents.
+type Hash [20]byte
+
+// ParseHash parses a hash in hexadecimal.
+func ParseHash(s string) (Hash, error) {
+	var h Hash
+	if len(s) != 2*len(h) {
+		return h, fmt.Errorf("invalid object name %q", s)
+	}
+	if _, err := hex.Decode(h[:], []byte(s)); err != nil {
+		return h, fmt.Errorf("invalid object name %q", s)
+	}
+	return h, nil
+}
+
+func (h Hash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// MarshalText encodes the hash in hexadecimal, e.g. in JSON output.
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// An ObjectType is the type of a Git object.
+type ObjectType int
+
+// Object types, numbered like in packfiles.
+const (
+	Commit ObjectType = 1
+	Tree   ObjectType = 2
+	Blob   ObjectType = 3
+	Tag    ObjectType = 4
+)
+
+var objectTypes = map[string]ObjectType{
+	"commit": Commit,
+	"tree":   Tree,
+	"blob":   Blob,
+	"tag":    Tag,
+}
+
+func (t ObjectType) String() string {
+	for name, typ := range objectTypes {
+		if typ == t {
+			return name
+		}
+	}
+	return fmt.Sprintf("ObjectType(%d)", int(t))
+}
+
+// ErrNotFound is returned for objects that are not in the repository, such
+// as the parents of the oldest commits of a shallow clone.
+var ErrNotFound = errors.New("object not found")
+
+// Objects larger than this are not read into memory.
+const maxObjectSize = 1 << 30
+
+// A Repo is a Git repository opened with Open. It is not safe for concurrent
+// use.
+type Repo struct {
+	dir     string
+	objects []*objectDir
+}
+
+// An objectDir is an object store, the one of the repository or one of its
+// alternates.
+type objectDir struct {
+	path  string
+	packs []*pack
+}
+
+// Open opens the repository at path: a working tree with a .git folder or
+// file, or a bare repository.
+func Open(path string) (*Repo, error) {
+	dir := path
+	if gitDir := filepath.Join(path, ".git"); exists(gitDir) {
+		dir = gitDir
+	}
+	// The .git of worktrees and submodules is a file pointing to the
+	// actual folder
+	if data, err := os.ReadFile(dir); err == nil {
+		target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+		if !ok {
+			return nil, fmt.Errorf("%s is not a Git repository", path)
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(dir), target)
+		}
+		dir = target
+	}
+	// Linked worktrees share the objects and refs of the main repository
+	if data, err := os.ReadFile(filepath.Join(dir, "commondir")); err == nil {
+		common := strings.TrimSpace(string(data))
+		if !filepath.IsAbs(common) {
+			common = filepath.Join(dir, common)
+		}
+		dir = common
+	}
+	if !exists(filepath.Join(dir, "objects")) || !exists(filepath.Join(dir, "HEAD")) {
+		return nil, fmt.Errorf("%s is not a Git repository", path)
+	}
+	if config, err := os.ReadFile(filepath.Join(dir, "config")); err == nil && bytes.Contains(bytes.ToLower(config), []byte("objectformat = sha256")) {
+		return nil, fmt.Errorf("%s: SHA-256 repositories are not supported", path)
+	}
+
+	r := &Repo{dir: dir}
+	if err := r.addObjectDir(filepath.Join(dir, "objects"), 0); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// addObjectDir adds the object store at path and, recursively, its
+// alternates.
+func (r *Repo) addObjectDir(path string, depth int) error {
+	// Like git, don't follow chains of alternates too far
+	if depth > 5 {
+		return nil
+	}
+	for _, od := range r.objects {
+		if od.path == path {
+			return nil
+		}
+	}
+	od := &objectDir{path: path}
+	r.objects = append(r.objects, od)
+
+	idxs, _ := filepath.Glob(filepath.Join(path, "pack", "pack-*.idx"))
+	for _, idx := range idxs {
+		p, err := openPack(strings.TrimSuffix(idx, ".idx"))
+		if err != nil {
+			return err
+		}
+		od.packs = append(od.packs, p)
+	}
+
+	alternates, err := os.ReadFile(filepath.Join(path, "info", "alternates"))
+	if err != nil {
+		return nil
+	}
+	for _, line := range strings.Split(string(alternates), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(path, line)
+		}
+		if err := r.addObjectDir(filepath.Clean(line), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the packfiles of the repository.
+func (r *Repo) Close() error {
+	var err error
+	for _, od := range r.objects {
+		for _, p := range od.packs {
+			err = errors.Join(err, p.Close())
+		}
+	}
+	return err
+}
+
+// Object returns the type and contents of the object named h.
+func (r *Repo) Object(h Hash) (ObjectType, []byte, error) {
+	return r.object(h, 0)
+}
+
+// object is Object for the base of a ref delta, depth deltas away from the
+// object that was asked for.
+func (r *Repo) object(h Hash, depth int) (ObjectType, []byte, error) {
+	for _, od := range r.objects {
+		for _, p := range od.packs {
+			if offset, ok := p.find(h); ok {
+				return p.object(r, offset, depth)
+			}
+		}
+		typ, _, data, err := readLoose(od.path, h, false)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return typ, data, err
+		}
+	}
+	return 0, nil, fmt.Errorf("%s: %w", h, ErrNotFound)
+}
+
+// Stat returns the type and size of the object named h, reading as little of
+// it as possible.
+func (r *Repo) Stat(h Hash) (ObjectType, int64, error) {
+	return r.stat(h, 0)
+}
+
+// stat is Stat for the base of a ref delta, like object.
+func (r *Repo) stat(h Hash, depth int) (ObjectType, int64, error) {
+	for _, od := range r.objects {
+		for _, p := range od.packs {
+			if offset, ok := p.find(h); ok {
+				return p.stat(r, offset, depth)
+			}
+		}
+		typ, size, _, err := readLoose(od.path, h, true)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return typ, size, err
+		}
+	}
+	return 0, 0, fmt.Errorf("%s: %w", h, ErrNotFound)
+}
+
+// readLoose reads the loose object named h in the object store at dir. If
+// headerOnly is set, only its type and size are read.
+func readLoose(dir string, h Hash, headerOnly bool) (ObjectType, int64, []byte, error) {
+	name := h.String()
+	f, err := os.Open(filepath.Join(dir, name[:2], name[2:]))
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer f.Close()
+	zr, err := zlib.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("object %s: %w", name, err)
+	}
+	defer zr.Close()
+
+	// The contents are preceded by "<type> <size>\0"
+	br := bufio.NewReaderSize(zr, 64)
+	header, err := br.ReadSlice(0)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("object %s: invalid header", name)
+	}
+	typeName, sizeStr, _ := strings.Cut(string(header[:len(header)-1]), " ")
+	typ, ok := objectTypes[typeName]
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if !ok || err != nil || size < 0 {
+		return 0, 0, nil, fmt.Errorf("object %s: invalid header", name)
+	}
+	if headerOnly {
+		return typ, size, nil, nil
+	}
+	if size > maxObjectSize {
+		return 0, 0, nil, fmt.Errorf("object %s: too large", name)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return 0, 0, nil, fmt.Errorf("object %s: %w", name, err)
+	}
+	return typ, size, data, nil
+}
@@ -0,0 +1,194 @@
+//go:build ignore
+
+// This program generates the test repositories of the git package, made of
+// hand written packfiles. Run it from the testdata folder with go run gen.go.
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	typeBlob     = 3
+	typeOfsDelta = 6
+	typeRefDelta = 7
+)
+
+// A packObject is an object of a packfile. Deltas have the index of their
+// base in the packfile, or the name of their base if it is a ref delta.
+type packObject struct {
+	name    [20]byte
+	typ     int
+	data    []byte
+	base    int
+	baseRef [20]byte
+}
+
+func blobName(content string) [20]byte {
+	return sha1.Sum(fmt.Appendf(nil, "blob %d\x00%s", len(content), content))
+}
+
+// delta returns a delta from base to a target of the given size, made of ops.
+func delta(base string, size int, ops ...[]byte) []byte {
+	d := binary.AppendUvarint(nil, uint64(len(base)))
+	d = binary.AppendUvarint(d, uint64(size))
+	for _, op := range ops {
+		d = append(d, op...)
+	}
+	return d
+}
+
+// copyOp copies n bytes at offset of the base, both less than 256.
+func copyOp(offset, n int) []byte {
+	return []byte{0x80 | 0x01 | 0x10, byte(offset), byte(n)}
+}
+
+// insertOp inserts s, at most 127 bytes.
+func insertOp(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func main() {
+	const (
+		base   = "The quick brown fox jumps over the lazy dog\n"
+		first  = "The quick brown fox jumps over the lazy cat\n"
+		second = "The quick red fox jumps over the lazy cat\n"
+		ref    = "The quick brown fox sleeps\n"
+	)
+	objects := []packObject{
+		{name: blobName(base), typ: typeBlob, data: []byte(base)},
+		// base -> first -> second, by offset
+		{name: blobName(first), typ: typeOfsDelta, base: 0, data: delta(base, len(first), copyOp(0, 40), insertOp("cat\n"))},
+		{name: blobName(second), typ: typeOfsDelta, base: 1, data: delta(first, len(second), copyOp(0, 10), insertOp("red"), copyOp(15, 29))},
+		// base -> ref, by name
+		{name: blobName(ref), typ: typeRefDelta, baseRef: blobName(base), data: delta(base, len(ref), copyOp(0, 20), insertOp("sleeps\n"))},
+		// Copies past the end of its base
+		{name: blobName("bad copy"), typ: typeOfsDelta, base: 0, data: delta(base, 8, copyOp(40, 8))},
+		// Declares a base of the wrong size
+		{name: blobName("bad base"), typ: typeOfsDelta, base: 0, data: delta(first[:10], 8, copyOp(0, 8))},
+		// Has a base that is not in the repository
+		{name: blobName("missing base"), typ: typeRefDelta, baseRef: blobName("missing"), data: delta("missing", 7, copyOp(0, 7))},
+	}
+	pack := writeRepo("deltas", objects)
+
+	// Two objects that are ref deltas of each other
+	x, y := blobName("x"), blobName("y")
+	writeRepo("cycle", []packObject{
+		{name: x, typ: typeRefDelta, baseRef: y, data: delta("y", 1, insertOp("x"))},
+		{name: y, typ: typeRefDelta, baseRef: x, data: delta("x", 1, insertOp("y"))},
+	})
+
+	// The objects of deltas, with the packfile cut in the middle of the
+	// first delta
+	writeRepo("truncated", objects)
+	packPath := filepath.Join("truncated", "objects", "pack", "pack-test.pack")
+	if err := os.WriteFile(packPath, pack[:12+len(base)+10], 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeRepo writes a bare repository named dir holding objects in a single
+// packfile, and returns the packfile.
+func writeRepo(dir string, objects []packObject) []byte {
+	packDir := filepath.Join(dir, "objects", "pack")
+	if err := os.MkdirAll(packDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "HEAD"), []byte("ref: refs/heads/main\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	pack := []byte("PACK")
+	pack = binary.BigEndian.AppendUint32(pack, 2)
+	pack = binary.BigEndian.AppendUint32(pack, uint32(len(objects)))
+	offsets := make([]int, len(objects))
+	crcs := make([]uint32, len(objects))
+	for i, obj := range objects {
+		offsets[i] = len(pack)
+
+		// Type and size, 4 bits of the size first and then 7 at a time
+		size := len(obj.data)
+		b := byte(obj.typ<<4) | byte(size&0x0f)
+		size >>= 4
+		for size > 0 {
+			pack = append(pack, b|0x80)
+			b = byte(size & 0x7f)
+			size >>= 7
+		}
+		pack = append(pack, b)
+
+		switch obj.typ {
+		case typeOfsDelta:
+			// The distance to the base, most significant bits first, with
+			// one added to all but the last group of bits
+			rel := offsets[i] - offsets[obj.base]
+			enc := []byte{byte(rel & 0x7f)}
+			for rel >>= 7; rel > 0; rel >>= 7 {
+				rel--
+				enc = append([]byte{0x80 | byte(rel&0x7f)}, enc...)
+			}
+			pack = append(pack, enc...)
+		case typeRefDelta:
+			pack = append(pack, obj.baseRef[:]...)
+		}
+
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(obj.data)
+		zw.Close()
+		pack = append(pack, z.Bytes()...)
+		crcs[i] = crc32.ChecksumIEEE(pack[offsets[i]:])
+	}
+	sum := sha1.Sum(pack)
+	pack = append(pack, sum[:]...)
+
+	// Version 2 index: fanout table, then names, CRCs and offsets in the
+	// order of the names
+	order := make([]int, len(objects))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return bytes.Compare(objects[order[a]].name[:], objects[order[b]].name[:]) < 0
+	})
+	idx := []byte("\xfftOc")
+	idx = binary.BigEndian.AppendUint32(idx, 2)
+	for i := range 256 {
+		n := 0
+		for _, obj := range objects {
+			if int(obj.name[0]) <= i {
+				n++
+			}
+		}
+		idx = binary.BigEndian.AppendUint32(idx, uint32(n))
+	}
+	for _, i := range order {
+		idx = append(idx, objects[i].name[:]...)
+	}
+	for _, i := range order {
+		idx = binary.BigEndian.AppendUint32(idx, crcs[i])
+	}
+	for _, i := range order {
+		idx = binary.BigEndian.AppendUint32(idx, uint32(offsets[i]))
+	}
+	idx = append(idx, sum[:]...)
+	idxSum := sha1.Sum(idx)
+	idx = append(idx, idxSum[:]...)
+
+	if err := os.WriteFile(filepath.Join(packDir, "pack-test.pack"), pack, 0644); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(packDir, "pack-test.idx"), idx, 0644); err != nil {
+		log.Fatal(err)
+	}
+	return pack
+}
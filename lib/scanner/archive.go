@@ -0,0 +1,432 @@
+package scanner
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// An archiveFormat is a container or compression format whose members the
+// scanner looks into.
+type archiveFormat int
+
+const (
+	formatNone archiveFormat = iota
+	formatZip
+	formatTar
+	formatCpio
+	formatAr
+	formatRpm
+	formatGzip
+	formatBzip2
+	formatXz
+	formatZstd
+	formatISO
+	formatFAT
+)
+
+// Number of leading bytes needed to detect every archiveFormat. The magic of
+// ISO 9660 images is the furthest, after their first 16 sectors.
+const sniffLen = isoMagicOffset + 5
+
+// detectFormat returns the format of a file starting with head, detected from
+// its content rather than its name.
+func detectFormat(head []byte) archiveFormat {
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return formatZip
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return formatGzip
+	case len(head) >= 4 && bytes.HasPrefix(head, []byte("BZh")) && '1' <= head[3] && head[3] <= '9':
+		return formatBzip2
+	case bytes.HasPrefix(head, []byte("\xfd7zXZ\x00")):
+		return formatXz
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return formatZstd
+	case bytes.HasPrefix(head, []byte("!<arch>\n")):
+		return formatAr
+	case bytes.HasPrefix(head, []byte{0xed, 0xab, 0xee, 0xdb}):
+		return formatRpm
+	case bytes.HasPrefix(head, []byte("070701")) || bytes.HasPrefix(head, []byte("070702")):
+		return formatCpio
+	case len(head) >= 262 && bytes.Equal(head[257:262], []byte("ustar")):
+		return formatTar
+	case len(head) >= sniffLen && string(head[isoMagicOffset:sniffLen]) == "CD001":
+		return formatISO
+	case isFAT(head):
+		return formatFAT
+	}
+	return formatNone
+}
+
+// An extraction tracks the recursive extraction of a file given to ScanFile,
+// ScanPath or ScanReader.
+type extraction struct {
+	ctx    context.Context
+	limits Limits
+	depth  int
+	budget *budget
+}
+
+// newExtraction returns the extraction of a file given to the scanner.
+func (s *Scanner) newExtraction(ctx context.Context) extraction {
+	return extraction{ctx: ctx, limits: s.opts.Limits, budget: &budget{}}
+}
+
+// nested returns the extraction of the members of an archive.
+func (e extraction) nested() extraction {
+	e.depth++
+	return e
+}
+
+// memberPath returns the path of an archive member in nested path notation,
+// e.g. bundle.tar.gz!inner/evil.zip.
+func memberPath(parent, name string) string {
+	return parent + "!" + strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// scanContent scans the contents of ra, of the given size, and if ra is an
+// archive, its members. If extraction exceeds a limit of the whole file
+// given to the scanner, the limitError is returned so the archives res is
+// nested in stop as well.
+func (s *Scanner) scanContent(e extraction, ra io.ReaderAt, size int64, res *ScanResult) error {
+	s.scan(e.ctx, io.NewSectionReader(ra, 0, size), res)
+	if res.Verdict == Error || res.Verdict == LimitsExceeded || !s.opts.ScanArchives {
+		return nil
+	}
+
+	head := make([]byte, sniffLen)
+	n, _ := ra.ReadAt(head, 0)
+	format := detectFormat(head[:n])
+	if format == formatNone {
+		return nil
+	}
+	if e.limits.MaxDepth >= 0 && e.depth >= e.limits.MaxDepth {
+		res.exceed(exceedsDepth(e.limits.MaxDepth))
+		return nil
+	}
+	s.stats.archives.Add(1)
+	sr := io.NewSectionReader(ra, 0, size)
+	e = e.nested()
+	var err error
+	switch format {
+	case formatZip:
+		err = s.scanZip(e, ra, size, res)
+	case formatTar:
+		err = s.scanTar(e, sr, res)
+	case formatCpio:
+		err = s.scanCpio(e, sr, res)
+	case formatAr:
+		err = s.scanAr(e, ra, size, res)
+	case formatRpm:
+		err = s.scanRpm(e, ra, size, res)
+	case formatISO:
+		err = s.scanISO(e, ra, size, res)
+	case formatFAT:
+		err = s.scanFAT(e, ra, res)
+	default:
+		err = s.scanCompressed(e, format, sr, res)
+	}
+
+	// Members of a package belong to it, unless they are packages themselves
+	if pkg := res.Package; pkg != nil {
+		res.Walk(func(member *ScanResult) {
+			if member.Package == nil {
+				member.Package = pkg
+			}
+		})
+	}
+
+	var limitErr *limitError
+	switch {
+	case err == nil || e.ctx.Err() != nil:
+	case errors.As(err, &limitErr):
+		res.exceed(limitErr)
+		if limitErr.total {
+			return limitErr
+		}
+	default:
+		res.Members = append(res.Members, ScanResult{
+			Path:    res.Path,
+			Size:    -1,
+			Verdict: Error,
+			Reason:  "Error reading archive",
+			Err:     err,
+		})
+	}
+	return nil
+}
+
+// scanStream scans the contents of r, whose size is res.Size or unknown if
+// -1, and if r is an archive, its members. Archives are spooled to memory or
+// a temporary file first, as they are read more than once. Limits of the
+// whole file are reported like by scanContent.
+func (s *Scanner) scanStream(e extraction, r io.Reader, res *ScanResult) error {
+	if !s.opts.ScanArchives {
+		s.scan(e.ctx, r, res)
+		return nil
+	}
+
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, _ := br.Peek(sniffLen)
+	if detectFormat(head) == formatNone {
+		s.scan(e.ctx, br, res)
+		return totalLimit(res)
+	}
+
+	f, size, err := spool(&contextReader{e.ctx, br})
+	if err != nil {
+		res.fail("Error reading file", err)
+		return totalLimit(res)
+	}
+	defer f.Close()
+	res.Size = size
+	return s.scanContent(e, f, size, res)
+}
+
+// totalLimit returns the error of res if it exceeded a limit of the whole
+// file given to the scanner.
+func totalLimit(res *ScanResult) error {
+	var limitErr *limitError
+	if errors.As(res.Err, &limitErr) && limitErr.total {
+		return limitErr
+	}
+	return nil
+}
+
+// scanMember scans a member of an archive, read from r, and adds its result
+// to the members of res. If compressed is set, it returns the compressed
+// size of the member, see memberReader.
+func (s *Scanner) scanMember(e extraction, r io.Reader, name string, size int64, compressed func() int64, res *ScanResult) error {
+	if e.limits.MaxMembers >= 0 && e.budget.members >= e.limits.MaxMembers {
+		return exceedsMembers(e.limits.MaxMembers)
+	}
+	e.budget.members++
+	s.stats.archiveMembers.Add(1)
+
+	member := ScanResult{Path: memberPath(res.Path, name), Size: size}
+	defer func() {
+		s.count(&member)
+		res.Members = append(res.Members, member)
+	}()
+
+	// Check the declared sizes first, so nothing is extracted
+	if e.limits.MaxMemberSize >= 0 && size > e.limits.MaxMemberSize {
+		member.exceed(exceedsMemberSize(e.limits.MaxMemberSize))
+		return nil
+	}
+	if compressed != nil && size >= 0 && exceedsRatioOf(e.limits.MaxRatio, size, compressed()) {
+		member.exceed(exceedsRatio(e.limits.MaxRatio))
+		return nil
+	}
+
+	return s.scanStream(e, &memberReader{r: r, e: e, compressed: compressed}, &member)
+}
+
+func (s *Scanner) scanZip(e extraction, ra io.ReaderAt, size int64, res *ScanResult) error {
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return err
+	}
+	res.Package = jarPackage(zr)
+	for _, f := range zr.File {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if f.Flags&0x1 != 0 {
+			s.stats.archiveMembers.Add(1)
+			member := ScanResult{Path: memberPath(res.Path, f.Name), Size: int64(f.UncompressedSize64)}
+			res.Members = append(res.Members, member.skip("encrypted"))
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			s.stats.archiveMembers.Add(1)
+			member := ScanResult{Path: memberPath(res.Path, f.Name), Size: -1}
+			res.Members = append(res.Members, member.fail("Error opening archive member", err))
+			continue
+		}
+		compressed := int64(f.CompressedSize64)
+		err = s.scanMember(e, rc, f.Name, int64(f.UncompressedSize64), func() int64 { return compressed }, res)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) scanTar(e extraction, r io.Reader, res *ScanResult) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		var r io.Reader = tr
+		if path.Clean("/"+hdr.Name) == "/.PKGINFO" && hdr.Size <= maxMetadataSize {
+			// Alpine and Arch Linux packages are tar archives starting with
+			// their metadata
+			pkgInfo, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			res.Package = parsePkgInfo(pkgInfo)
+			r = bytes.NewReader(pkgInfo)
+		}
+		if err := s.scanMember(e, r, hdr.Name, hdr.Size, nil, res); err != nil {
+			return err
+		}
+	}
+}
+
+// scanCompressed scans a compressed stream. Compression is transparent: if
+// the decompressed data is a tar or cpio archive, its members become members
+// of res, as in bundle.tar.gz!inner/file. Otherwise the decompressed data is
+// the only member, named after the stream, as in evil.exe.gz!evil.exe.
+func (s *Scanner) scanCompressed(e extraction, format archiveFormat, r io.Reader, res *ScanResult) error {
+	cr := &countingReader{r: r}
+	dr, err := decompress(format, cr)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	name := path.Base(res.Path[strings.LastIndex(res.Path, "!")+1:])
+	if zr, ok := dr.(*gzip.Reader); ok && zr.Name != "" {
+		name = zr.Name
+	} else {
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+
+	br := bufio.NewReaderSize(&contextReader{e.ctx, &ratioReader{r: dr, max: e.limits.MaxRatio, compressed: cr}}, sniffLen)
+	head, _ := br.Peek(sniffLen)
+	switch detectFormat(head) {
+	case formatTar:
+		return s.scanTar(e, br, res)
+	case formatCpio:
+		return s.scanCpio(e, br, res)
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "data"
+	}
+	return s.scanMember(e, br, name, -1, nil, res)
+}
+
+// scanPayload scans the payload of a package, a tar or cpio archive that may
+// be compressed, adding the files in it to the members of res.
+func (s *Scanner) scanPayload(e extraction, r io.Reader, res *ScanResult) error {
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, _ := br.Peek(sniffLen)
+	switch format := detectFormat(head); format {
+	case formatTar:
+		return s.scanTar(e, br, res)
+	case formatCpio:
+		return s.scanCpio(e, br, res)
+	case formatGzip, formatBzip2, formatXz, formatZstd:
+		return s.scanCompressed(e, format, br, res)
+	}
+	return errors.New("unsupported package payload")
+}
+
+// decompress returns a reader of the decompressed contents of r, which is
+// compressed in format. Gzip streams are returned as a *gzip.Reader.
+func decompress(format archiveFormat, r io.Reader) (io.ReadCloser, error) {
+	switch format {
+	case formatGzip:
+		return gzip.NewReader(r)
+	case formatBzip2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case formatXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	case formatZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return nil, errors.New("unsupported compression")
+}
+
+// Archives up to this size are spooled to memory, larger ones to a temporary
+// file.
+const spoolMemory = 16 << 20
+
+// spooled is a spooled copy of a stream.
+type spooled interface {
+	io.ReaderAt
+	io.Closer
+}
+
+type memSpool struct {
+	*bytes.Reader
+}
+
+func (memSpool) Close() error {
+	return nil
+}
+
+type fileSpool struct {
+	*os.File
+}
+
+func (f fileSpool) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// spool copies r to memory, or to a temporary file if it is larger than
+// spoolMemory, so it can be read at random.
+func spool(r io.Reader) (spooled, int64, error) {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, spoolMemory+1)
+	if err == io.EOF {
+		return memSpool{bytes.NewReader(buf.Bytes())}, n, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f, err := os.CreateTemp("", "goava-spool-")
+	if err != nil {
+		return nil, 0, err
+	}
+	spool := fileSpool{f}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		spool.Close()
+		return nil, 0, err
+	}
+	rest, err := io.Copy(f, r)
+	if err != nil {
+		spool.Close()
+		return nil, 0, err
+	}
+	return spool, n + rest, nil
+}
@@ -0,0 +1,139 @@
+package scanner
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// The test malware compressed with bzip2, which the standard library cannot
+// write.
+const malwareBzip2 = "425a6839314159265359be4a1bc600000751800010400423c79f802000314d3231313108831064c6a6e08d16983c4f119c3616f3465ee85083e2ee48a70a1217c94378c0"
+
+// tarOf returns a tar archive of the members.
+func tarOf(t *testing.T, members ...zipMember) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, m := range members {
+		if err := tw.WriteHeader(&tar.Header{Name: m.name, Mode: 0644, Size: int64(len(m.data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(m.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Archives are detected by their content, and their members are scanned
+// recursively and named after the path within them.
+func TestScanArchives(t *testing.T) {
+	evil := zipMember{"dir/evil", []byte(malware)}
+	clean := zipMember{"clean.txt", []byte("clean\n")}
+	bzipped, err := hex.DecodeString(malwareBzip2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	named := func() []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Name = "real.bin"
+		zw.Write([]byte(malware))
+		zw.Close()
+		return buf.Bytes()
+	}
+	encrypted := func() []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "secret", Flags: 0x1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(malware))
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		data     []byte
+		archives bool
+		want     []string
+		// Whether the last member is an error reading the archive itself
+		readErr bool
+	}{
+		{"zip", "sample.zip", zipOf(t, clean, evil), true, []string{"clean.txt clean", "dir/evil infected"}, false},
+		{"tar", "sample.tar", tarOf(t, clean, evil), true, []string{"clean.txt clean", "dir/evil infected"}, false},
+		{"tar.gz", "sample.tar.gz", gzipOf(t, tarOf(t, clean, evil)), true, []string{"clean.txt clean", "dir/evil infected"}, false},
+		{"gzip", "evil.exe.gz", gzipOf(t, []byte(malware)), true, []string{"evil.exe infected"}, false},
+		{"gzip without suffix", "evil", gzipOf(t, []byte(malware)), true, []string{"evil infected"}, false},
+		{"gzip with name", "evil.gz", named(), true, []string{"real.bin infected"}, false},
+		{"bzip2", "evil.bz2", bzipped, true, []string{"evil infected"}, false},
+		{"zip in zip", "outer.zip", zipOf(t, zipMember{"inner.zip", zipOf(t, evil)}), true, []string{"inner.zip clean", "inner.zip!dir/evil infected"}, false},
+		{
+			"zip in tar.gz", "deep.tar.gz", gzipOf(t, tarOf(t, zipMember{"a.zip", zipOf(t, zipMember{"evil.gz", gzipOf(t, []byte(malware))})})), true,
+			[]string{"a.zip clean", "a.zip!evil.gz clean", "a.zip!evil.gz!evil infected"}, false,
+		},
+		{"encrypted member", "secret.zip", encrypted(), true, []string{"secret skipped"}, false},
+		{"truncated zip", "sample.zip", zipOf(t, evil)[:40], true, nil, true},
+		{"archives disabled", "sample.zip", zipOf(t, evil), false, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			s := newTestScanner(t, Options{ScanArchives: tt.archives, SkipSize: true})
+			res := s.ScanFile(context.Background(), path)
+			if res.Verdict != Clean {
+				t.Fatalf("got verdict %v (%v), want %v", res.Verdict, res.Err, Clean)
+			}
+			if got := verdicts(res); !slices.Equal(got, tt.want) {
+				t.Errorf("got members %q, want %q", got, tt.want)
+			}
+			readErr := len(res.Members) > 0 && res.Members[len(res.Members)-1].Path == res.Path
+			if readErr != tt.readErr {
+				t.Errorf("got read error %v, want %v", readErr, tt.readErr)
+			}
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want archiveFormat
+	}{
+		{"zip", []byte("PK\x03\x04rest"), formatZip},
+		{"gzip", []byte{0x1f, 0x8b, 0x08}, formatGzip},
+		{"bzip2", []byte("BZh9"), formatBzip2},
+		{"bzip2 without level", []byte("BZh"), formatNone},
+		{"bzip2 bad level", []byte("BZhx"), formatNone},
+		{"xz", []byte("\xfd7zXZ\x00\x00"), formatXz},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, formatZstd},
+		{"tar", tarOf(t, zipMember{"a", []byte("a")}), formatTar},
+		{"text", []byte("hello"), formatNone},
+		{"empty", nil, formatNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectFormat(tt.head); got != tt.want {
+				t.Errorf("got format %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,468 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf16"
+)
+
+// An extent is a contiguous range of bytes of a disk image.
+type extent struct {
+	offset int64
+	size   int64
+}
+
+func extentsSize(extents []extent) int64 {
+	var size int64
+	for _, e := range extents {
+		size += e.size
+	}
+	return size
+}
+
+// readExtents returns a reader of the extents of ra, one after the other.
+func readExtents(ra io.ReaderAt, extents []extent) io.Reader {
+	readers := make([]io.Reader, len(extents))
+	for i, e := range extents {
+		readers[i] = io.NewSectionReader(ra, e.offset, e.size)
+	}
+	return io.MultiReader(readers...)
+}
+
+// Directories of FAT volumes hold at most 65536 entries of 32 bytes.
+const fatMaxDirSize = 65536 * 32
+
+// A fatVolume reads the directory tree of a FAT12, FAT16 or FAT32 volume.
+type fatVolume struct {
+	ra io.ReaderAt
+
+	// Bits per entry of the file allocation table: 12, 16 or 32
+	bits int
+
+	clusterSize int64
+	clusters    uint32
+	fatOffset   int64
+	dataOffset  int64
+
+	// The root directory of FAT12 and FAT16 volumes has a fixed location,
+	// the one of FAT32 volumes is a cluster chain
+	root        extent
+	rootCluster uint32
+
+	// The part of the file allocation table read last
+	fatWindow int64
+	fatBuf    []byte
+}
+
+// newFATVolume returns the volume whose boot sector is boot, at offset in ra,
+// or nil if boot isn't the boot sector of a FAT volume.
+func newFATVolume(ra io.ReaderAt, offset int64, boot []byte) *fatVolume {
+	if len(boot) < 512 || boot[510] != 0x55 || boot[511] != 0xaa || (boot[0] != 0xeb && boot[0] != 0xe9) {
+		return nil
+	}
+	// Formatting tools always set the file system type, which tells FAT
+	// apart from other boot sectors
+	if string(boot[54:57]) != "FAT" && string(boot[82:87]) != "FAT32" {
+		return nil
+	}
+
+	bytesPerSector := int64(binary.LittleEndian.Uint16(boot[11:]))
+	sectorsPerCluster := int64(boot[13])
+	reserved := int64(binary.LittleEndian.Uint16(boot[14:]))
+	fats := int64(boot[16])
+	rootEntries := int64(binary.LittleEndian.Uint16(boot[17:]))
+	sectors := int64(binary.LittleEndian.Uint16(boot[19:]))
+	if sectors == 0 {
+		sectors = int64(binary.LittleEndian.Uint32(boot[32:]))
+	}
+	fatSize := int64(binary.LittleEndian.Uint16(boot[22:]))
+	if fatSize == 0 {
+		fatSize = int64(binary.LittleEndian.Uint32(boot[36:]))
+	}
+	if bytesPerSector < 512 || bytesPerSector > 4096 || bytesPerSector&(bytesPerSector-1) != 0 ||
+		sectorsPerCluster == 0 || sectorsPerCluster&(sectorsPerCluster-1) != 0 ||
+		reserved == 0 || fats == 0 || fats > 2 || fatSize == 0 {
+		return nil
+	}
+
+	rootSectors := (rootEntries*32 + bytesPerSector - 1) / bytesPerSector
+	firstData := reserved + fats*fatSize + rootSectors
+	if sectors <= firstData {
+		return nil
+	}
+	v := &fatVolume{
+		ra:          ra,
+		clusterSize: sectorsPerCluster * bytesPerSector,
+		clusters:    uint32((sectors - firstData) / sectorsPerCluster),
+		fatOffset:   offset + reserved*bytesPerSector,
+		dataOffset:  offset + firstData*bytesPerSector,
+		fatWindow:   -1,
+	}
+
+	// The type is given by the number of clusters only
+	switch {
+	case v.clusters < 4085:
+		v.bits = 12
+	case v.clusters < 65525:
+		v.bits = 16
+	default:
+		v.bits = 32
+	}
+	if v.bits == 32 {
+		v.rootCluster = binary.LittleEndian.Uint32(boot[44:])
+		if rootEntries != 0 || v.rootCluster < 2 {
+			return nil
+		}
+	} else {
+		v.root = extent{offset + (reserved+fats*fatSize)*bytesPerSector, rootEntries * 32}
+	}
+	return v
+}
+
+var errInvalidChain = errors.New("invalid FAT cluster chain")
+
+// next returns the cluster following c in its chain, or 0 at the end of the
+// chain.
+func (v *fatVolume) next(c uint32) (uint32, error) {
+	offset := int64(c) * int64(v.bits) / 8
+	window := offset &^ 4095
+	if window != v.fatWindow {
+		// Read a bit more than the window, for the FAT12 entries across it
+		buf := make([]byte, 4096+4)
+		n, err := v.ra.ReadAt(buf, v.fatOffset+window)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		v.fatWindow, v.fatBuf = window, buf[:n]
+	}
+	i := int(offset - window)
+	if i+max(v.bits/8, 2) > len(v.fatBuf) {
+		return 0, errInvalidChain
+	}
+
+	var next, end uint32
+	switch v.bits {
+	case 12:
+		next = uint32(binary.LittleEndian.Uint16(v.fatBuf[i:]))
+		if c%2 == 1 {
+			next >>= 4
+		}
+		next &= 0xfff
+		end = 0xff8
+	case 16:
+		next = uint32(binary.LittleEndian.Uint16(v.fatBuf[i:]))
+		end = 0xfff8
+	default:
+		next = binary.LittleEndian.Uint32(v.fatBuf[i:]) & 0x0fffffff
+		end = 0x0ffffff8
+	}
+	if next >= end {
+		return 0, nil
+	}
+	if next < 2 || next >= v.clusters+2 {
+		return 0, errInvalidChain
+	}
+	return next, nil
+}
+
+// chain returns the extents of the cluster chain starting at c, up to size
+// bytes.
+func (v *fatVolume) chain(c uint32, size int64) ([]extent, error) {
+	var extents []extent
+	for size > 0 && c != 0 {
+		if c < 2 || c >= v.clusters+2 {
+			return nil, errInvalidChain
+		}
+		n := min(size, v.clusterSize)
+		offset := v.dataOffset + int64(c-2)*v.clusterSize
+		if k := len(extents) - 1; k >= 0 && extents[k].offset+extents[k].size == offset {
+			extents[k].size += n
+		} else {
+			extents = append(extents, extent{offset, n})
+		}
+		size -= n
+		if size == 0 {
+			break
+		}
+		var err error
+		if c, err = v.next(c); err != nil {
+			return nil, err
+		}
+	}
+	return extents, nil
+}
+
+// A fatEntry is a file or directory of a FAT volume.
+type fatEntry struct {
+	name    string
+	dir     bool
+	cluster uint32
+	size    int64
+}
+
+// readDir returns the files of the directory stored in extents, without the
+// entries for itself and its parent.
+func (v *fatVolume) readDir(extents []extent) ([]fatEntry, error) {
+	data, err := io.ReadAll(readExtents(v.ra, extents))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []fatEntry
+	// Long names are stored in the entries before the short one, last
+	// part first, with the checksum of the short name
+	var long []uint16
+	var longSum, longOrd byte
+	for pos := 0; pos+32 <= len(data); pos += 32 {
+		d := data[pos : pos+32]
+		if d[0] == 0 {
+			break
+		}
+		if d[0] == 0xe5 {
+			long = nil
+			continue
+		}
+		attr := d[11]
+		if attr&0x3f == 0x0f {
+			ord := d[0] & 0x1f
+			switch {
+			case d[0]&0x40 != 0 && ord > 0:
+				long = make([]uint16, 13*int(ord))
+				longSum = d[13]
+			case long == nil || ord == 0 || ord != longOrd-1 || d[13] != longSum:
+				long = nil
+				continue
+			}
+			longOrd = ord
+			part := long[13*int(ord-1):]
+			for i, off := range [13]int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+				part[i] = binary.LittleEndian.Uint16(d[off:])
+			}
+			continue
+		}
+
+		name := ""
+		if long != nil && longOrd == 1 && shortNameSum(d[:11]) == longSum {
+			n := 0
+			for n < len(long) && long[n] != 0 {
+				n++
+			}
+			name = string(utf16.Decode(long[:n]))
+		}
+		long = nil
+		if attr&0x08 != 0 {
+			// Volume label
+			continue
+		}
+		if name == "" {
+			name = shortName(d)
+		}
+		if name == "." || name == ".." || name == "" {
+			continue
+		}
+
+		cluster := uint32(binary.LittleEndian.Uint16(d[26:]))
+		if v.bits == 32 {
+			cluster |= uint32(binary.LittleEndian.Uint16(d[20:])) << 16
+		}
+		entries = append(entries, fatEntry{
+			name:    name,
+			dir:     attr&0x10 != 0,
+			cluster: cluster,
+			size:    int64(binary.LittleEndian.Uint32(d[28:])),
+		})
+	}
+	return entries, nil
+}
+
+// shortNameSum returns the checksum of an 8.3 name, as stored in the long
+// name entries of a file.
+func shortNameSum(name []byte) byte {
+	var sum byte
+	for _, c := range name {
+		sum = (sum&1)<<7 + sum>>1 + c
+	}
+	return sum
+}
+
+// shortName returns the 8.3 name of the directory entry d, e.g. SETUP.EXE.
+func shortName(d []byte) string {
+	base := []byte(strings.TrimRight(string(d[:8]), " "))
+	ext := []byte(strings.TrimRight(string(d[8:11]), " "))
+	// 0xe5 marks deleted entries, so a leading 0xe5 is stored as 0x05
+	if len(base) > 0 && base[0] == 0x05 {
+		base[0] = 0xe5
+	}
+	// Windows stores names with a lowercase base or extension as flags
+	if d[12]&0x08 != 0 {
+		base = []byte(strings.ToLower(string(base)))
+	}
+	if d[12]&0x10 != 0 {
+		ext = []byte(strings.ToLower(string(ext)))
+	}
+
+	// Names are in an OEM code page, read as Latin-1
+	var name strings.Builder
+	for _, c := range base {
+		name.WriteRune(rune(c))
+	}
+	if len(ext) > 0 {
+		name.WriteByte('.')
+		for _, c := range ext {
+			name.WriteRune(rune(c))
+		}
+	}
+	return name.String()
+}
+
+// walk calls fn with the path and the contents of every regular file of the
+// volume, with prefix prepended to the paths.
+func (v *fatVolume) walk(prefix string, fn func(name string, r io.Reader, size int64) error) error {
+	root := []extent{v.root}
+	if v.bits == 32 {
+		var err error
+		if root, err = v.chain(v.rootCluster, fatMaxDirSize); err != nil {
+			return err
+		}
+	}
+
+	visited := make(map[uint32]bool)
+	var walk func(dir []extent, prefix string, depth int) error
+	walk = func(dir []extent, prefix string, depth int) error {
+		if depth > 64 {
+			return nil
+		}
+		entries, err := v.readDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, f := range entries {
+			name := path.Join(prefix, f.name)
+			if f.dir {
+				if f.cluster == 0 || visited[f.cluster] {
+					continue
+				}
+				visited[f.cluster] = true
+				extents, err := v.chain(f.cluster, fatMaxDirSize)
+				if err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+				if err := walk(extents, name, depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+			r := &fatFileReader{v: v, cluster: f.cluster, size: f.size}
+			if err := fn(name, r, f.size); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(root, prefix, 0)
+}
+
+// fatFileReader reads a file of a FAT volume, following its cluster chain
+// once it is first read, as files exceeding the limits are never read.
+type fatFileReader struct {
+	v       *fatVolume
+	cluster uint32
+	size    int64
+	r       io.Reader
+}
+
+func (r *fatFileReader) Read(p []byte) (int, error) {
+	if r.r == nil {
+		extents, err := r.v.chain(r.cluster, r.size)
+		if err != nil {
+			return 0, err
+		}
+		if extentsSize(extents) != r.size {
+			return 0, errInvalidChain
+		}
+		r.r = readExtents(r.v.ra, extents)
+	}
+	return r.r.Read(p)
+}
+
+// Partition types of FAT volumes in an MBR partition table.
+var fatPartitionTypes = map[byte]bool{0x01: true, 0x04: true, 0x06: true, 0x0b: true, 0x0c: true, 0x0e: true}
+
+// fatPartitions returns the start and the number of the FAT partitions of
+// the MBR partition table in the first sector mbr, or nil if there is none.
+func fatPartitions(mbr []byte) (starts []int64, numbers []int) {
+	if len(mbr) < 512 || mbr[510] != 0x55 || mbr[511] != 0xaa {
+		return nil, nil
+	}
+	for i := range 4 {
+		p := mbr[446+16*i : 446+16*(i+1)]
+		if p[0] != 0 && p[0] != 0x80 {
+			return nil, nil
+		}
+		start := int64(binary.LittleEndian.Uint32(p[8:]))
+		if fatPartitionTypes[p[4]] && start > 0 {
+			starts = append(starts, start*512)
+			numbers = append(numbers, i+1)
+		}
+	}
+	return starts, numbers
+}
+
+// isFAT reports whether head is the start of a FAT volume, or of a disk
+// image with FAT partitions.
+func isFAT(head []byte) bool {
+	if newFATVolume(nil, 0, head) != nil {
+		return true
+	}
+	starts, _ := fatPartitions(head)
+	return len(starts) > 0
+}
+
+// walkFAT calls fn with the path and the contents of every regular file of
+// the FAT volume in ra, or of the FAT partitions of the disk image in ra.
+// The paths of files in partitions start with partitionN when there are
+// several.
+func walkFAT(ra io.ReaderAt, fn func(name string, r io.Reader, size int64) error) error {
+	boot := make([]byte, 512)
+	if _, err := ra.ReadAt(boot, 0); err != nil {
+		return err
+	}
+	if v := newFATVolume(ra, 0, boot); v != nil {
+		return v.walk("", fn)
+	}
+
+	starts, numbers := fatPartitions(boot)
+	for i, start := range starts {
+		prefix := ""
+		if len(starts) > 1 {
+			prefix = fmt.Sprintf("partition%d", numbers[i])
+		}
+		partBoot := make([]byte, 512)
+		if _, err := ra.ReadAt(partBoot, start); err != nil {
+			return err
+		}
+		v := newFATVolume(ra, start, partBoot)
+		if v == nil {
+			continue
+		}
+		if err := v.walk(prefix, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanFAT scans the files of a FAT disk image. They become members of res,
+// as in usb.img!autorun.inf.
+func (s *Scanner) scanFAT(e extraction, ra io.ReaderAt, res *ScanResult) error {
+	return walkFAT(ra, func(name string, r io.Reader, size int64) error {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+		return s.scanMember(e, r, name, size, nil, res)
+	})
+}
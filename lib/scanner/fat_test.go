@@ -0,0 +1,145 @@
+package scanner
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"testing"
+)
+
+// pattern returns n bytes that differ from one cluster or sector to the next,
+// as in testdata/gen.go.
+func pattern(n int, seed byte) string {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = seed + byte(i%251)
+	}
+	return string(data)
+}
+
+// diskFiles returns the contents of the files of the FAT and ISO 9660 test
+// images by path, prefixed with prefix, with their 8.3 paths if short is set.
+func diskFiles(prefix string, short bool) map[string]string {
+	files := []struct{ path, short, content string }{
+		{"README.TXT", "README.TXT", "readme\n"},
+		{"notes.txt", "NOTES.TXT", "lowercase short name\n"},
+		{"A long file name with spaces.exe", "ALONGF~1.EXE", "goava test malware, not harmful\n"},
+		{"dir1/sub/deep.bin", "DIR1/SUB/DEEP.BIN", pattern(3000, 1)},
+		{"dir1/Mixed Case Ünïcode.txt", "DIR1/MIXEDC~1.TXT", "unicode\n"},
+		{"dir1/empty", "DIR1/EMPTY", ""},
+		{"frag.dat", "FRAG.DAT", pattern(9000, 2)},
+		{"after.dat", "AFTER.DAT", pattern(2000, 3)},
+	}
+	m := make(map[string]string)
+	for _, f := range files {
+		name := f.path
+		if short {
+			name = f.short
+		}
+		m[path.Join(prefix, name)] = f.content
+	}
+	return m
+}
+
+// readDisk returns the gzipped disk image name of testdata.
+func readDisk(t *testing.T, name string) []byte {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// walkDisk returns the contents of the files walk finds in the disk image
+// data, by path.
+func walkDisk(t *testing.T, walk func(io.ReaderAt, func(string, io.Reader, int64) error) error, data []byte) (map[string]string, error) {
+	t.Helper()
+	files := make(map[string]string)
+	err := walk(bytes.NewReader(data), func(name string, r io.Reader, size int64) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if int64(len(data)) != size {
+			t.Errorf("%s: read %d bytes, want %d", name, len(data), size)
+		}
+		files[name] = string(data)
+		return nil
+	})
+	return files, err
+}
+
+// diffFiles reports the differences between the files got and want.
+func diffFiles(t *testing.T, got, want map[string]string) {
+	t.Helper()
+	var names []string
+	for name := range want {
+		names = append(names, name)
+	}
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		content, ok := got[name]
+		wantContent, wanted := want[name]
+		switch {
+		case !wanted:
+			t.Errorf("unexpected %s", name)
+		case !ok:
+			t.Errorf("missing %s", name)
+		case content != wantContent:
+			t.Errorf("%s: got %d bytes not matching the %d expected", name, len(content), len(wantContent))
+		}
+	}
+}
+
+func TestWalkFAT(t *testing.T) {
+	mbr := diskFiles("partition1", false)
+	maps.Copy(mbr, diskFiles("partition3", false))
+	tests := []struct {
+		name  string
+		file  string
+		want  map[string]string
+		errIs error
+	}{
+		{name: "FAT12", file: "fat12.img.gz", want: diskFiles("", false)},
+		{name: "FAT16", file: "fat16.img.gz", want: diskFiles("", false)},
+		{name: "FAT32", file: "fat32.img.gz", want: diskFiles("", false)},
+		{name: "MBR partitions", file: "mbr.img.gz", want: mbr},
+		{name: "chain out of the volume", file: "fat-badchain.img.gz", errIs: errInvalidChain},
+		{name: "truncated", file: "fat-truncated.img.gz", errIs: errInvalidChain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := walkDisk(t, walkFAT, readDisk(t, tt.file))
+			if tt.errIs != nil {
+				if !errors.Is(err, tt.errIs) {
+					t.Fatalf("got error %v, want %v", err, tt.errIs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			diffFiles(t, got, tt.want)
+		})
+	}
+}
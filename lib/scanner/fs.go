@@ -0,0 +1,174 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// readLinkFS is implemented by file systems with symbolic links, such as the
+// ones returned by os.DirFS since Go 1.25. It has the methods of
+// fs.ReadLinkFS.
+type readLinkFS interface {
+	fs.FS
+
+	// ReadLink returns the destination of the symbolic link name
+	ReadLink(name string) (string, error)
+
+	// Lstat returns a FileInfo describing name, without following it if it
+	// is a symbolic link
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// ScanFS scans the file at root in fsys, or the files in the directory at
+// root if Recursive is enabled, like ScanPath scans the file system of the
+// operating system. Paths are those of fsys, such as dir/evil.exe, and fsys
+// may be an embed.FS, a *zip.Reader, an os.DirFS or any other fs.FS.
+//
+// Symbolic links are only known if fsys reports them in the types of its
+// directory entries. They are skipped, unless FollowSymlinks is enabled and
+// fsys has a ReadLink method, like fs.ReadLinkFS, in which case they are
+// resolved within fsys. Links pointing outside of fsys can't be resolved.
+//
+// Files that are an io.ReaderAt are read in place, others are read as a
+// stream, and spooled if they are archives.
+func (s *Scanner) ScanFS(ctx context.Context, fsys fs.FS, root string, fn func(ScanResult)) error {
+	p := newPool(ctx, s.opts.Jobs, fn)
+
+	info, err := fs.Stat(fsys, root)
+	switch {
+	case err == nil && info.IsDir() && !s.opts.Recursive:
+		res := ScanResult{Path: root, Size: -1}
+		p.submit(func() ScanResult { return res.skip("directory") })
+	case err == nil && info.IsDir():
+		s.walkFS(ctx, p, fsys, root)
+	default:
+		p.submit(func() ScanResult { return s.scanFSFile(ctx, fsys, root) })
+	}
+
+	p.wait()
+	return ctx.Err()
+}
+
+// walkFS walks the directory at root in fsys and submits its files to p.
+func (s *Scanner) walkFS(ctx context.Context, p *pool, fsys fs.FS, root string) {
+	fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return fs.SkipAll
+		}
+		res := ScanResult{Path: name, Size: -1}
+		if err != nil {
+			p.submit(func() ScanResult { return res.fail("Error walking path", err) })
+			return nil
+		}
+
+		isDir := d.IsDir()
+		if d.Type()&fs.ModeSymlink != 0 {
+			if !s.opts.FollowSymlinks {
+				p.submit(func() ScanResult { return res.skip("symlink") })
+				return nil
+			}
+			resolved, err := evalFSSymlinks(fsys, name)
+			if err != nil {
+				p.submit(func() ScanResult { return res.fail("Failed to resolve symlink", err) })
+				return nil
+			}
+			stat, err := fs.Stat(fsys, resolved)
+			if err != nil {
+				p.submit(func() ScanResult { return res.fail("Failed to stat resolved path", err) })
+				return nil
+			}
+			// Like on disk, directories are not walked through links
+			isDir = stat.IsDir()
+		}
+
+		if !isDir {
+			p.submit(func() ScanResult { return s.scanFSFile(ctx, fsys, name) })
+		} else {
+			s.stats.scannedFolders.Add(1)
+		}
+		return nil
+	})
+}
+
+// scanFSFile scans the file name in fsys, like ScanFile. If FollowSymlinks
+// is enabled, the path of the result is the resolved one.
+func (s *Scanner) scanFSFile(ctx context.Context, fsys fs.FS, name string) ScanResult {
+	res := ScanResult{Path: name, Size: -1}
+	if err := ctx.Err(); err != nil {
+		return res.fail("Error scanning file", err)
+	}
+
+	if s.opts.FollowSymlinks {
+		resolved, err := evalFSSymlinks(fsys, name)
+		if err != nil {
+			return res.fail("Error resolving symlink", err)
+		}
+		res.Path = resolved
+	}
+
+	file, err := fsys.Open(res.Path)
+	if err != nil {
+		return res.fail("Error opening file", err)
+	}
+	defer file.Close()
+	return s.scanOpenFile(ctx, file, res)
+}
+
+var errLinkOutside = errors.New("symbolic link points outside of the file system")
+
+// evalFSSymlinks returns the path of name in fsys after resolving symbolic
+// links, like filepath.EvalSymlinks. If fsys has no ReadLink method, name is
+// returned as is.
+func evalFSSymlinks(fsys fs.FS, name string) (string, error) {
+	lfs, ok := fsys.(readLinkFS)
+	if !ok {
+		return name, nil
+	}
+
+	// Resolve the components of the path one by one, as any of them may be a
+	// link
+	resolved := "."
+	rest := strings.Split(name, "/")
+	for links := 0; len(rest) > 0; {
+		elem := rest[0]
+		rest = rest[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if resolved == "." {
+				return "", &fs.PathError{Op: "readlink", Path: name, Err: errLinkOutside}
+			}
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, elem)
+		info, err := lfs.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		// Like the operating system, give up on chains of links too long to
+		// be anything but a loop
+		if links++; links > 40 {
+			return "", &fs.PathError{Op: "readlink", Path: name, Err: errors.New("too many levels of symbolic links")}
+		}
+		target, err := lfs.ReadLink(next)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			return "", &fs.PathError{Op: "readlink", Path: name, Err: errLinkOutside}
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,191 @@
+package scanner
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// linkDirFS is the file system of the directory dir, with symbolic links.
+type linkDirFS struct {
+	fs.FS
+	dir string
+}
+
+func (fsys linkDirFS) ReadLink(name string) (string, error) {
+	return os.Readlink(filepath.Join(fsys.dir, filepath.FromSlash(name)))
+}
+
+func (fsys linkDirFS) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(filepath.Join(fsys.dir, filepath.FromSlash(name)))
+}
+
+// newLinkFS returns a file system with the test malware at a/evil, a clean
+// file at a/clean.txt, and the symbolic links in links, by name.
+func newLinkFS(t *testing.T, links map[string]string) linkDirFS {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a/evil": malware, "a/clean.txt": "clean\n"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, target := range links {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return linkDirFS{os.DirFS(dir), dir}
+}
+
+func TestEvalFSSymlinks(t *testing.T) {
+	fsys := newLinkFS(t, map[string]string{
+		"link":          "a/evil",
+		"chain":         "link",
+		"dirlink":       "a",
+		"b/rel":         "../a/evil",
+		"b/dirrel":      "../dirlink/clean.txt",
+		"escape":        "../outside",
+		"b/deep/escape": "../../../outside",
+		"abs":           "/etc/passwd",
+		"loop1":         "loop2",
+		"loop2":         "loop1",
+		"self":          "self",
+		"dangling":      "missing",
+	})
+	tests := []struct {
+		name string
+		want string
+		// Part of the expected error if want is empty
+		wantErr string
+	}{
+		{"a/evil", "a/evil", ""},
+		{"a", "a", ""},
+		{".", ".", ""},
+		{"link", "a/evil", ""},
+		{"chain", "a/evil", ""},
+		{"dirlink/evil", "a/evil", ""},
+		{"b/rel", "a/evil", ""},
+		{"b/dirrel", "a/clean.txt", ""},
+		{"a/../link", "a/evil", ""},
+		{"b/deep/../../chain", "a/evil", ""},
+		{"escape", "", "points outside"},
+		{"b/deep/escape", "", "points outside"},
+		{"../a/evil", "", "points outside"},
+		{"a/../../a/evil", "", "points outside"},
+		{"abs", "", "points outside"},
+		{"loop1", "", "too many levels"},
+		{"self", "", "too many levels"},
+		{"dangling", "", "no such file"},
+		{"a/missing", "", "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evalFSSymlinks(fsys, tt.name)
+			if tt.want != "" {
+				if err != nil || got != tt.want {
+					t.Errorf("got %q, %v, want %q", got, err, tt.want)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got %q, want an error", got)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Without ReadLink, paths are taken as they are
+	plain := struct{ fs.FS }{fsys}
+	if got, err := evalFSSymlinks(plain, "link"); err != nil || got != "link" {
+		t.Errorf("without ReadLink: got %q, %v, want link", got, err)
+	}
+}
+
+func TestScanFS(t *testing.T) {
+	links := newLinkFS(t, map[string]string{
+		"b/link":   "../a/evil",
+		"b/escape": "../../outside",
+		"c":        "a",
+		"d/loop":   "loop",
+	})
+	mapFS := fstest.MapFS{
+		"a/evil":      {Data: []byte(malware)},
+		"a/clean.txt": {Data: []byte("clean\n")},
+		"b.txt":       {Data: []byte("clean\n")},
+	}
+	var zipData bytes.Buffer
+	zw := zip.NewWriter(&zipData)
+	for _, name := range []string{"a/evil", "a/clean.txt"} {
+		w, _ := zw.Create(name)
+		w.Write(mapFS[name].Data)
+	}
+	zw.Close()
+	zipFS, err := zip.NewReader(bytes.NewReader(zipData.Bytes()), int64(zipData.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		fsys fs.FS
+		root string
+		opts Options
+		want []string
+	}{
+		{"file", mapFS, "a/evil", Options{}, []string{"a/evil infected"}},
+		{"missing", mapFS, "missing", Options{}, []string{"missing error"}},
+		{"directory", mapFS, "a", Options{}, []string{"a skipped"}},
+		{"recursive", mapFS, ".", Options{Recursive: true}, []string{"a/clean.txt clean", "a/evil infected", "b.txt clean"}},
+		{"subdirectory", mapFS, "a", Options{Recursive: true}, []string{"a/clean.txt clean", "a/evil infected"}},
+		{"zip", zipFS, ".", Options{Recursive: true, Jobs: 1}, []string{"a/clean.txt clean", "a/evil infected"}},
+		{
+			"symlinks skipped", links, ".", Options{Recursive: true},
+			[]string{"a/clean.txt clean", "a/evil infected", "b/escape skipped", "b/link skipped", "c skipped", "d/loop skipped"},
+		},
+		{
+			"symlinks followed", links, ".", Options{Recursive: true, FollowSymlinks: true},
+			// Directories are not walked through links
+			[]string{"a/clean.txt clean", "a/evil infected", "b/escape error", "a/evil infected", "d/loop error"},
+		},
+		{"link to file", links, "b/link", Options{FollowSymlinks: true}, []string{"a/evil infected"}},
+		{"path through link", links, "c/evil", Options{FollowSymlinks: true}, []string{"a/evil infected"}},
+		{"link outside", links, "b/escape", Options{FollowSymlinks: true}, []string{"b/escape error"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScanner(t, tt.opts)
+			var got []string
+			escaped := false
+			err := s.ScanFS(context.Background(), tt.fsys, tt.root, func(res ScanResult) {
+				got = append(got, res.Path+" "+res.Verdict.String())
+				escaped = escaped || errors.Is(res.Err, errLinkOutside)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if want := slices.Contains(tt.want, "b/escape error"); escaped != want {
+				t.Errorf("link outside of the file system reported: %v, want %v", escaped, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/hexahigh/goava/lib/git"
+)
+
+// ScanGit scans the history of the Git repository at path, a working tree or
+// a bare repository, reading its objects directly from loose objects and
+// packfiles.
+//
+// Every blob reachable from the refs of the repository is scanned once, even
+// if it was deleted since. The blobs become members of the result, named
+// after the first path they appear at, as in repo!bin/evil.exe, with the
+// commits and paths they appear at in ScanResult.Git. The working tree
+// itself is not scanned, see ScanPath.
+func (s *Scanner) ScanGit(ctx context.Context, path string) ScanResult {
+	res := ScanResult{Path: path, Size: -1}
+	if err := ctx.Err(); err != nil {
+		return res.fail("Error scanning repository", err)
+	}
+
+	repo, err := git.Open(path)
+	if err != nil {
+		return res.fail("Error opening repository", err)
+	}
+	defer repo.Close()
+	s.stats.scannedFiles.Add(1)
+
+	blobs, err := repo.History(ctx)
+	if err != nil {
+		return res.fail("Error reading repository history", err)
+	}
+	s.stats.archives.Add(1)
+	res.Verdict = Clean
+
+	for _, blob := range blobs {
+		if ctx.Err() != nil {
+			break
+		}
+		name := blob.Hash.String()
+		if len(blob.Occurrences) > 0 {
+			name = blob.Occurrences[0].Path
+		}
+
+		// The size is known without reading the blob, so those exceeding
+		// the limits are never read
+		_, size, err := repo.Stat(blob.Hash)
+		if err != nil {
+			s.stats.archiveMembers.Add(1)
+			member := ScanResult{Path: memberPath(res.Path, name), Size: -1, Git: blob.Occurrences}
+			res.Members = append(res.Members, member.fail("Error reading blob", err))
+			continue
+		}
+		r := &blobReader{repo: repo, hash: blob.Hash}
+
+		// Every blob is extracted within its own limits, like a file on disk
+		s.scanMember(s.newExtraction(ctx), r, name, size, nil, &res)
+		res.Members[len(res.Members)-1].Walk(func(member *ScanResult) {
+			member.Git = blob.Occurrences
+		})
+	}
+	return res
+}
+
+// blobReader reads a blob, once it is first read.
+type blobReader struct {
+	repo *git.Repo
+	hash git.Hash
+	r    io.Reader
+}
+
+func (r *blobReader) Read(p []byte) (int, error) {
+	if r.r == nil {
+		_, data, err := r.repo.Object(r.hash)
+		if err != nil {
+			return 0, err
+		}
+		r.r = bytes.NewReader(data)
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,471 @@
+package scanner
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ScanImage scans the container image at path, either an archive written by
+// docker save or a directory in the OCI image layout, or a tar archive of
+// one.
+//
+// The final filesystem of the image is scanned, that is the files of the
+// layers minus those deleted or replaced by a later layer, or the files of
+// every layer if ScanImageLayers is enabled. The files become members of the
+// result, as in image.tar!usr/bin/evil, with the digest of their layer in
+// ScanResult.Layer. If the archive or layout holds several images, each one
+// is a member of the result, named after its tag, and its files are members
+// of it.
+func (s *Scanner) ScanImage(ctx context.Context, path string) ScanResult {
+	res := ScanResult{Path: path, Size: -1}
+	if err := ctx.Err(); err != nil {
+		return res.fail("Error scanning image", err)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return res.fail("Error opening image", err)
+	}
+	var src blobSource
+	if stat.IsDir() {
+		src = dirBlobs(path)
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return res.fail("Error opening image", err)
+		}
+		defer f.Close()
+		res.Size = stat.Size()
+		s.stats.dataScanned.Add(uint64(res.Size))
+		if src, err = tarBlobs(f); err != nil {
+			return res.fail("Error reading image", err)
+		}
+	}
+	s.stats.scannedFiles.Add(1)
+
+	images, err := readImages(src, s.opts.Limits.MaxDepth)
+	if err != nil {
+		return res.fail("Error reading image", err)
+	}
+	res.Verdict = Clean
+	for _, img := range images {
+		s.stats.archives.Add(1)
+		parent := &res
+		if len(images) > 1 {
+			s.stats.archiveMembers.Add(1)
+			res.Members = append(res.Members, ScanResult{Path: memberPath(res.Path, img.name), Size: -1})
+			parent = &res.Members[len(res.Members)-1]
+		}
+		if err := s.scanImage(ctx, src, img, parent); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			parent.Members = append(parent.Members, ScanResult{
+				Path:    parent.Path,
+				Size:    -1,
+				Verdict: Error,
+				Reason:  "Error reading image",
+				Err:     err,
+			})
+		}
+	}
+	return res
+}
+
+// scanImage scans the files of img, adding them to the members of res.
+func (s *Scanner) scanImage(ctx context.Context, src blobSource, img image, res *ScanResult) error {
+	if s.opts.ScanImageLayers {
+		for _, layer := range img.layers {
+			if err := s.scanLayer(ctx, src, layer, nil, res); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// The layers are read from the top, so a file is scanned in the last
+	// layer that has it, and ignored in the layers below
+	ov := &overlay{covered: make(map[string]coverage), pending: make(map[string]coverage)}
+	for i := len(img.layers) - 1; i >= 0; i-- {
+		if err := s.scanLayer(ctx, src, img.layers[i], ov, res); err != nil {
+			return err
+		}
+		ov.commit()
+	}
+	return nil
+}
+
+// scanLayer scans the regular files of a layer that are visible in ov, or
+// all of them if ov is nil.
+func (s *Scanner) scanLayer(ctx context.Context, src blobSource, layer imageLayer, ov *overlay, res *ScanResult) error {
+	blob, err := src.open(layer.blob)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	// Layers are tar archives, compressed or not
+	cr := &countingReader{r: blob}
+	br := bufio.NewReaderSize(cr, sniffLen)
+	head, _ := br.Peek(sniffLen)
+	var r io.Reader = br
+	if format := detectFormat(head); format != formatTar {
+		dr, err := decompress(format, br)
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", layer.digest, err)
+		}
+		defer dr.Close()
+		r = &ratioReader{r: dr, max: s.opts.Limits.MaxRatio, compressed: cr}
+	}
+
+	tr := tar.NewReader(&contextReader{ctx, r})
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", layer.digest, err)
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		dir, base := path.Split(name)
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			if ov != nil {
+				ov.whiteout(path.Clean(dir), base)
+			}
+			continue
+		}
+		if ov != nil && !ov.visible(name, hdr.Typeflag == tar.TypeDir) {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// Every file is extracted within its own limits, like a file on disk
+		s.scanMember(s.newExtraction(ctx), tr, name, hdr.Size, nil, res)
+		res.Members[len(res.Members)-1].Walk(func(member *ScanResult) {
+			member.Layer = layer.digest
+		})
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// Prefix of the names of whiteout files, which mark files of lower layers as
+// deleted, and name of the whiteout file that marks a directory as opaque,
+// hiding all the files of lower layers in it.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// How an upper layer covers a path of the lower layers.
+type coverage int
+
+const (
+	// The path and everything under it is deleted or replaced
+	coverAll coverage = iota + 1
+
+	// Everything under the path is hidden, but not the directory itself
+	coverBelow
+)
+
+// An overlay tracks which files of the lower layers of an image are visible
+// in its final filesystem, as the layers are read from the top.
+type overlay struct {
+	covered map[string]coverage
+
+	// Coverage of the layer being read, which only applies to the layers
+	// below it
+	pending map[string]coverage
+}
+
+// visible reports whether the file at name of the current layer is visible,
+// and records that it covers the lower layers unless it is a directory.
+func (ov *overlay) visible(name string, dir bool) bool {
+	if ov.covered[name] == coverAll {
+		return false
+	}
+	for parent := path.Dir(name); ; parent = path.Dir(parent) {
+		if ov.covered[parent] != 0 {
+			return false
+		}
+		if parent == "/" {
+			break
+		}
+	}
+	if !dir {
+		ov.pending[name] = coverAll
+	}
+	return true
+}
+
+// whiteout records the whiteout file named base in dir.
+func (ov *overlay) whiteout(dir, base string) {
+	if base == whiteoutOpaque {
+		if ov.pending[dir] == 0 {
+			ov.pending[dir] = coverBelow
+		}
+		return
+	}
+	ov.pending[path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))] = coverAll
+}
+
+// commit applies the coverage of the layer that was read to the layers below.
+func (ov *overlay) commit() {
+	for name, c := range ov.pending {
+		if ov.covered[name] != coverAll {
+			ov.covered[name] = c
+		}
+		delete(ov.pending, name)
+	}
+}
+
+// An image is a container image in an image archive or layout.
+type image struct {
+	name   string
+	layers []imageLayer
+}
+
+// An imageLayer is a layer of an image, read from the blob at the path
+// blob of its archive or layout.
+type imageLayer struct {
+	digest string
+	blob   string
+}
+
+// A blobSource opens the files of an image archive or layout by their path.
+type blobSource interface {
+	open(name string) (io.ReadCloser, error)
+}
+
+// dirBlobs opens the files of an image layout directory.
+type dirBlobs string
+
+func (d dirBlobs) open(name string) (io.ReadCloser, error) {
+	if !fs.ValidPath(name) {
+		return nil, fmt.Errorf("invalid blob path %q", name)
+	}
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+// tarFile is the location of a file in a tar archive.
+type tarFile struct {
+	offset int64
+	size   int64
+}
+
+// tarBlobSource opens the files of an image archive.
+type tarBlobSource struct {
+	ra    io.ReaderAt
+	files map[string]tarFile
+}
+
+// tarBlobs indexes the files of the image archive f, so they can be opened
+// in any order.
+func tarBlobs(f *os.File) (*tarBlobSource, error) {
+	src := &tarBlobSource{ra: f, files: make(map[string]tarFile)}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return src, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		// The file is at the start of the contents, as tar.Reader doesn't
+		// read ahead, and seeks over the contents on the next call
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		src.files[name] = tarFile{offset: offset, size: hdr.Size}
+	}
+}
+
+func (src *tarBlobSource) open(name string) (io.ReadCloser, error) {
+	f, ok := src.files[path.Clean(name)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	return io.NopCloser(io.NewSectionReader(src.ra, f.offset, f.size)), nil
+}
+
+// Maximum size of the manifests and configurations of an image.
+const maxManifestSize = 4 << 20
+
+// readJSON decodes the JSON file at name of src into v.
+func readJSON(src blobSource, name string, v any) error {
+	rc, err := src.open(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(io.LimitReader(rc, maxManifestSize)).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// readImages returns the images of an archive written by docker save, from
+// its manifest.json, or of an OCI image layout, from its index.json. Nested
+// OCI image indexes are followed up to maxDepth levels deep, unless maxDepth
+// is negative.
+func readImages(src blobSource, maxDepth int) ([]image, error) {
+	var manifest []struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	err := readJSON(src, "manifest.json", &manifest)
+	if errors.Is(err, os.ErrNotExist) {
+		return readOCIImages(src, "index.json", "", maxDepth, make(map[string]bool))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var images []image
+	for _, m := range manifest {
+		img := image{name: m.Config}
+		if len(m.RepoTags) > 0 {
+			img.name = m.RepoTags[0]
+		}
+		// Layer files are named after their digest since Docker 25, and
+		// after the ID of the layer before. The digests of the latter are
+		// the diff IDs in the configuration, if it can be read
+		var config struct {
+			RootFS struct {
+				DiffIDs []string `json:"diff_ids"`
+			} `json:"rootfs"`
+		}
+		if err := readJSON(src, m.Config, &config); err != nil {
+			config.RootFS.DiffIDs = nil
+		}
+		for i, blob := range m.Layers {
+			layer := imageLayer{digest: blob, blob: blob}
+			if digest, ok := strings.CutPrefix(blob, "blobs/"); ok && strings.Count(digest, "/") == 1 {
+				layer.digest = strings.Replace(digest, "/", ":", 1)
+			} else if i < len(config.RootFS.DiffIDs) {
+				layer.digest = config.RootFS.DiffIDs[i]
+			}
+			img.layers = append(img.layers, layer)
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// An ociDescriptor references a blob of an OCI image layout.
+type ociDescriptor struct {
+	MediaType   string
+	Digest      string
+	Annotations map[string]string
+	Platform    *struct {
+		OS           string
+		Architecture string
+		Variant      string
+	}
+}
+
+// blob returns the path of the blob referenced by d.
+func (d ociDescriptor) blob() (string, error) {
+	alg, hex, ok := strings.Cut(d.Digest, ":")
+	if !ok || alg == "" || hex == "" || strings.ContainsAny(d.Digest, "/\\") {
+		return "", fmt.Errorf("invalid digest %q", d.Digest)
+	}
+	return "blobs/" + alg + "/" + hex, nil
+}
+
+// readOCIImages returns the images of the OCI image index at name, named
+// after their reference annotations or name if they have none. Nested indexes
+// are followed up to maxDepth levels deep, unless maxDepth is negative.
+// parents holds the blobs of the indexes index is nested in, which it must
+// not reference again.
+func readOCIImages(src blobSource, index, name string, maxDepth int, parents map[string]bool) ([]image, error) {
+	var idx struct {
+		Manifests []ociDescriptor
+	}
+	if err := readJSON(src, index, &idx); err != nil {
+		return nil, err
+	}
+
+	var images []image
+	for _, desc := range idx.Manifests {
+		// Skip attestations, such as the provenance added by buildx
+		if desc.Annotations["vnd.docker.reference.type"] != "" {
+			continue
+		}
+		blob, err := desc.blob()
+		if err != nil {
+			return nil, err
+		}
+		imgName := name
+		if ref := desc.Annotations["io.containerd.image.name"]; ref != "" {
+			imgName = ref
+		} else if ref := desc.Annotations["org.opencontainers.image.ref.name"]; ref != "" && imgName == "" {
+			imgName = ref
+		}
+		if imgName == "" {
+			imgName = desc.Digest
+		}
+		if p := desc.Platform; p != nil {
+			imgName += " " + strings.TrimSuffix(p.OS+"/"+p.Architecture+"/"+p.Variant, "/")
+		}
+
+		if strings.HasSuffix(desc.MediaType, "index.v1+json") || strings.HasSuffix(desc.MediaType, "manifest.list.v2+json") {
+			if parents[blob] {
+				return nil, nestedIndex(desc.Digest)
+			}
+			if maxDepth >= 0 && len(parents) >= maxDepth {
+				return nil, exceedsIndexDepth(maxDepth)
+			}
+			parents[blob] = true
+			nested, err := readOCIImages(src, blob, imgName, maxDepth, parents)
+			delete(parents, blob)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, nested...)
+			continue
+		}
+
+		var manifest struct {
+			Layers []ociDescriptor
+		}
+		if err := readJSON(src, blob, &manifest); err != nil {
+			return nil, err
+		}
+		img := image{name: imgName}
+		for _, layer := range manifest.Layers {
+			if !strings.Contains(layer.MediaType, "tar") {
+				continue
+			}
+			blob, err := layer.blob()
+			if err != nil {
+				return nil, err
+			}
+			img.layers = append(img.layers, imageLayer{digest: layer.Digest, blob: blob})
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
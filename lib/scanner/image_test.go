@@ -0,0 +1,62 @@
+package scanner
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestScanImage(t *testing.T) {
+	// The final filesystem of the images, see genImages
+	final := []string{
+		"opt/app/new clean",
+		"tmp/evil clean",
+		"etc/keep clean",
+		"srv/evil infected",
+	}
+	tests := []struct {
+		name    string
+		file    string
+		layers  bool
+		verdict Verdict
+		reason  string
+		want    []string
+	}{
+		{name: "docker", file: "testdata/docker.tar", verdict: Clean, want: final},
+		{name: "oci", file: "testdata/oci.tar", verdict: Clean, want: final},
+		{
+			name:    "docker layers",
+			file:    "testdata/docker.tar",
+			layers:  true,
+			verdict: Clean,
+			want: []string{
+				"bin/evil infected",
+				"etc/keep clean",
+				"opt/app/evil infected",
+				"tmp/evil infected",
+				"srv/evil infected",
+				"opt/app/new clean",
+				"tmp/evil clean",
+			},
+		},
+		{name: "self-referencing index", file: "testdata/oci-loop.tar", verdict: LimitsExceeded, reason: "image index sha256:loop nested in itself"},
+		{name: "deep index", file: "testdata/oci-deep.tar", verdict: LimitsExceeded, reason: "image index nested more than 16 levels deep"},
+		{name: "malformed index", file: "testdata/oci-badindex.tar", verdict: Error},
+		{name: "truncated", file: "testdata/oci-truncated.tar", verdict: Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScanner(t, Options{ScanImageLayers: tt.layers})
+			res := s.ScanImage(context.Background(), tt.file)
+			if res.Verdict != tt.verdict {
+				t.Fatalf("got verdict %v (%v), want %v", res.Verdict, res.Err, tt.verdict)
+			}
+			if tt.reason != "" && res.Reason != tt.reason {
+				t.Errorf("got reason %q, want %q", res.Reason, tt.reason)
+			}
+			if got := verdicts(res); !slices.Equal(got, tt.want) {
+				t.Errorf("got members %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
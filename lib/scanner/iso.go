@@ -0,0 +1,293 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"path"
+	"strings"
+	"unicode/utf16"
+)
+
+// The volume descriptors of ISO 9660 images start at sector 16, with the
+// magic CD001 after their type.
+const (
+	isoSectorSize  = 2048
+	isoMagicOffset = 16*isoSectorSize + 1
+)
+
+// An isoFile is a file or directory of an ISO 9660 image.
+type isoFile struct {
+	name string
+	dir  bool
+
+	// Files larger than 4 GiB are made of several extents
+	extents []extent
+}
+
+func (f *isoFile) size() int64 {
+	return extentsSize(f.extents)
+}
+
+// An isoImage reads the directory tree of an ISO 9660 image, with the long
+// names of the Rock Ridge or Joliet extensions if it has them.
+type isoImage struct {
+	ra        io.ReaderAt
+	size      int64
+	blockSize int64
+
+	// Directories are read into memory, and count towards the total size
+	// limit of the extraction
+	e extraction
+
+	joliet    bool
+	rockRidge bool
+
+	// Number of bytes to skip at the start of System Use areas
+	suspOffset int
+}
+
+// walkISO calls fn with the path and the contents of every regular file of
+// the ISO 9660 image in ra, of the given size, extracted by e.
+func walkISO(ra io.ReaderAt, size int64, e extraction, fn func(name string, r io.Reader, size int64) error) error {
+	img := &isoImage{ra: ra, size: size, e: e}
+
+	// Read the volume descriptors up to the terminator
+	var primary, joliet []byte
+	for sector := int64(16); sector <= 16+64; sector++ {
+		vd := make([]byte, isoSectorSize)
+		if _, err := ra.ReadAt(vd, sector*isoSectorSize); err != nil {
+			return err
+		}
+		if string(vd[1:6]) != "CD001" {
+			return errors.New("invalid ISO 9660 volume descriptor")
+		}
+		switch {
+		case vd[0] == 255:
+			sector = math.MaxInt64 - 1
+		case vd[0] == 1 && primary == nil:
+			primary = vd
+			img.blockSize = int64(binary.LittleEndian.Uint16(vd[128:]))
+		case vd[0] == 2 && vd[88] == '%' && vd[89] == '/' && bytes.IndexByte([]byte("@CE"), vd[90]) >= 0:
+			// Joliet is a supplementary descriptor with UCS-2 escape sequences
+			joliet = vd
+		}
+	}
+	if primary == nil {
+		return errors.New("no ISO 9660 primary volume descriptor")
+	}
+	if img.blockSize != 512 && img.blockSize != 1024 && img.blockSize != 2048 {
+		return errors.New("invalid ISO 9660 block size")
+	}
+
+	// Rock Ridge names are preferred over Joliet ones, which are truncated
+	root := img.parseRecord(primary[156 : 156+34])
+	img.rockRidge = img.hasRockRidge(root)
+	if !img.rockRidge && joliet != nil {
+		root = img.parseRecord(joliet[156 : 156+34])
+		img.joliet = true
+	}
+
+	visited := make(map[int64]bool)
+	var walk func(dir *isoFile, prefix string, depth int) error
+	walk = func(dir *isoFile, prefix string, depth int) error {
+		if visited[dir.extents[0].offset] || depth > 64 {
+			return nil
+		}
+		visited[dir.extents[0].offset] = true
+		entries, err := img.readDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, f := range entries {
+			name := path.Join(prefix, f.name)
+			if f.dir {
+				if err := walk(f, name, depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := fn(name, readExtents(ra, f.extents), f.size()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(root, "", 0)
+}
+
+// hasRockRidge reports whether the image uses Rock Ridge, announced by a
+// SUSP SP entry in the first record of root, and sets suspOffset.
+func (img *isoImage) hasRockRidge(root *isoFile) bool {
+	rec := make([]byte, 255)
+	if _, err := img.ra.ReadAt(rec, root.extents[0].offset); err != nil {
+		return false
+	}
+	n := int(rec[0])
+	if n < 34 || n > len(rec) {
+		return false
+	}
+	// The identifier of the first record is a single byte, followed by
+	// no padding byte as its length is odd
+	su := rec[34:n]
+	if len(su) >= 7 && string(su[:2]) == "SP" && su[4] == 0xbe && su[5] == 0xef {
+		img.suspOffset = int(su[6])
+		return true
+	}
+	return false
+}
+
+// parseRecord parses a directory record.
+func (img *isoImage) parseRecord(rec []byte) *isoFile {
+	offset := int64(binary.LittleEndian.Uint32(rec[2:])) * img.blockSize
+	size := int64(binary.LittleEndian.Uint32(rec[10:]))
+	return &isoFile{dir: rec[25]&0x02 != 0, extents: []extent{{offset, size}}}
+}
+
+// readDir returns the files of dir, without the entries for itself and its
+// parent.
+func (img *isoImage) readDir(dir *isoFile) ([]*isoFile, error) {
+	offset, size := dir.extents[0].offset, min(dir.size(), 64<<20)
+	if offset+size > img.size {
+		return nil, errors.New("ISO 9660 directory beyond the end of the image")
+	}
+	limits, budget := img.e.limits, img.e.budget
+	budget.extracted += size
+	if limits.MaxTotalSize >= 0 && budget.extracted > limits.MaxTotalSize {
+		return nil, exceedsTotalSize(limits.MaxTotalSize)
+	}
+	data := make([]byte, size)
+	if n, err := img.ra.ReadAt(data, offset); n < len(data) {
+		return nil, err
+	}
+
+	var files []*isoFile
+	var last *isoFile
+	for pos := 0; pos < len(data); {
+		n := int(data[pos])
+		if n == 0 {
+			// Records don't cross sectors, the rest is padding
+			pos = (pos/isoSectorSize + 1) * isoSectorSize
+			continue
+		}
+		if n < 34 || pos+n > len(data) {
+			break
+		}
+		rec := data[pos : pos+n]
+		pos += n
+
+		idLen := int(rec[32])
+		if 33+idLen > len(rec) {
+			continue
+		}
+		id := rec[33 : 33+idLen]
+		systemUse := rec[min(33+idLen+1-idLen%2, len(rec)):]
+
+		// The first two records are the directory itself and its parent
+		if idLen == 1 && (id[0] == 0 || id[0] == 1) {
+			continue
+		}
+
+		f := img.parseRecord(rec)
+		switch {
+		case img.rockRidge:
+			name, symlink := img.rockRidgeName(systemUse)
+			if symlink {
+				continue
+			}
+			if name == "" {
+				name = isoName(id)
+			}
+			f.name = name
+		case img.joliet:
+			u := make([]uint16, idLen/2)
+			for i := range u {
+				u[i] = binary.BigEndian.Uint16(id[2*i:])
+			}
+			f.name = strings.TrimSuffix(string(utf16.Decode(u)), ";1")
+		default:
+			f.name = isoName(id)
+		}
+
+		// The extents of a file but the last have the multi-extent flag
+		if last != nil && last.name == f.name && !f.dir {
+			last.extents = append(last.extents, f.extents...)
+		} else {
+			files = append(files, f)
+		}
+		last = nil
+		if rec[25]&0x80 != 0 {
+			last = files[len(files)-1]
+		}
+	}
+	return files, nil
+}
+
+// isoName returns the name of a file from its ISO 9660 identifier, without
+// the version number, e.g. SETUP.EXE for SETUP.EXE;1.
+func isoName(id []byte) string {
+	name, _, _ := strings.Cut(string(id), ";")
+	return strings.TrimSuffix(name, ".")
+}
+
+// rockRidgeName returns the Rock Ridge name in the System Use area of a
+// directory record, following continuation areas, and whether the file is a
+// symbolic link.
+func (img *isoImage) rockRidgeName(su []byte) (string, bool) {
+	var name strings.Builder
+	symlink := false
+	if len(su) >= img.suspOffset {
+		su = su[img.suspOffset:]
+	}
+	for continuations := 0; continuations < 8; continuations++ {
+		var next []byte
+		for len(su) >= 4 {
+			n := int(su[2])
+			if n < 4 || n > len(su) {
+				break
+			}
+			entry := su[:n]
+			su = su[n:]
+			switch string(entry[:2]) {
+			case "NM":
+				if n > 5 && entry[4]&0x06 == 0 {
+					name.Write(entry[5:])
+				}
+			case "SL":
+				symlink = true
+			case "CE":
+				if n >= 28 {
+					block := int64(binary.LittleEndian.Uint32(entry[4:]))
+					offset := int64(binary.LittleEndian.Uint32(entry[12:]))
+					length := int64(binary.LittleEndian.Uint32(entry[20:]))
+					if length <= isoSectorSize {
+						next = make([]byte, length)
+						if _, err := img.ra.ReadAt(next, block*img.blockSize+offset); err != nil {
+							next = nil
+						}
+					}
+				}
+			case "ST":
+				su = nil
+			}
+		}
+		if next == nil {
+			break
+		}
+		su = next
+	}
+	return name.String(), symlink
+}
+
+// scanISO scans the files of an ISO 9660 image. They become members of res,
+// as in disk.iso!setup/evil.exe.
+func (s *Scanner) scanISO(e extraction, ra io.ReaderAt, size int64, res *ScanResult) error {
+	return walkISO(ra, size, e, func(name string, r io.Reader, size int64) error {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+		return s.scanMember(e, r, name, size, nil, res)
+	})
+}
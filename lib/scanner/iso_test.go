@@ -0,0 +1,88 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// Offset of the size of the root directory record in the primary volume
+// descriptor of an ISO 9660 image, both little and big endian.
+const isoRootSizeOffset = 16*isoSectorSize + 156 + 10
+
+// setISORootSize sets the size of the root directory of the primary volume
+// descriptor of the ISO 9660 image data.
+func setISORootSize(data []byte, size uint32) []byte {
+	binary.LittleEndian.PutUint32(data[isoRootSizeOffset:], size)
+	binary.BigEndian.PutUint32(data[isoRootSizeOffset+4:], size)
+	return data
+}
+
+func TestWalkISO(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		change func([]byte) []byte
+		limits Limits
+		want   map[string]string
+		errIs  error
+		// Whether walking fails with a limitError
+		wantLimit bool
+	}{
+		{name: "ISO 9660", file: "plain.iso.gz", want: diskFiles("", true)},
+		{name: "Joliet", file: "joliet.iso.gz", want: diskFiles("", false)},
+		// Preferred over Joliet, without the symbolic link
+		{name: "Rock Ridge", file: "rockridge.iso.gz", want: diskFiles("", false)},
+		{name: "truncated", file: "iso-truncated.iso.gz", errIs: io.EOF},
+		{
+			name:   "directory beyond the image",
+			file:   "plain.iso.gz",
+			change: func(data []byte) []byte { return setISORootSize(data, uint32(len(data))) },
+		},
+		{
+			name: "directory cut off by the end of the image",
+			file: "plain.iso.gz",
+			// Ends 100 bytes into the root directory, whose sector precedes
+			// its size in the record
+			change: func(data []byte) []byte {
+				return data[:binary.LittleEndian.Uint32(data[isoRootSizeOffset-8:])*isoSectorSize+100]
+			},
+		},
+		{name: "directory over the total size", file: "plain.iso.gz", limits: Limits{MaxTotalSize: 1000}, wantLimit: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := readDisk(t, tt.file)
+			if tt.change != nil {
+				data = tt.change(data)
+			}
+			e := extraction{ctx: context.Background(), limits: tt.limits.withDefaults(), budget: &budget{}}
+			walk := func(ra io.ReaderAt, fn func(string, io.Reader, int64) error) error {
+				return walkISO(ra, int64(len(data)), e, fn)
+			}
+			got, err := walkDisk(t, walk, data)
+
+			var limitErr *limitError
+			switch {
+			case tt.wantLimit:
+				if !errors.As(err, &limitErr) {
+					t.Fatalf("got error %v, want a limit error", err)
+				}
+			case tt.errIs != nil:
+				if !errors.Is(err, tt.errIs) {
+					t.Fatalf("got error %v, want %v", err, tt.errIs)
+				}
+			case tt.want == nil:
+				if err == nil {
+					t.Fatal("got no error")
+				}
+			case err != nil:
+				t.Fatal(err)
+			default:
+				diffFiles(t, got, tt.want)
+			}
+		})
+	}
+}
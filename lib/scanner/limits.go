@@ -0,0 +1,169 @@
+package scanner
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Limits bound the recursive extraction of archives, as a defense against
+// archive bombs. Members and archives that exceed a limit get the
+// LimitsExceeded verdict.
+//
+// Fields that are 0 take their default. Negative fields disable the limit.
+type Limits struct {
+	// Maximum nesting depth of archives. An archive nested deeper is still
+	// scanned itself, but its members are not. Defaults to 16
+	MaxDepth int
+
+	// Maximum number of bytes extracted from a single file given to the
+	// scanner, counting the members of all nested archives. Extraction
+	// stops once it is reached. Defaults to 400 MiB
+	MaxTotalSize int64
+
+	// Maximum size of a single archive member. Larger members are not
+	// extracted. Defaults to 100 MiB
+	MaxMemberSize int64
+
+	// Maximum number of members extracted from a single file given to the
+	// scanner, counting the members of all nested archives. Extraction stops
+	// once it is reached. Defaults to 10000
+	MaxMembers int
+
+	// Maximum ratio of the extracted size of a compressed member or stream to
+	// its compressed size. Only checked once more than 1 MiB is extracted,
+	// as small files legitimately reach high ratios. Defaults to 250
+	MaxRatio int
+}
+
+// withDefaults returns l with its zero fields set to their defaults.
+func (l Limits) withDefaults() Limits {
+	if l.MaxDepth == 0 {
+		l.MaxDepth = 16
+	}
+	if l.MaxTotalSize == 0 {
+		l.MaxTotalSize = 400 << 20
+	}
+	if l.MaxMemberSize == 0 {
+		l.MaxMemberSize = 100 << 20
+	}
+	if l.MaxMembers == 0 {
+		l.MaxMembers = 10000
+	}
+	if l.MaxRatio == 0 {
+		l.MaxRatio = 250
+	}
+	return l
+}
+
+// Extracted size from which the compression ratio is checked.
+const minRatioSize = 1 << 20
+
+// A limitError reports that extraction exceeded a limit. If total is set, the
+// limit applies to the whole file given to the scanner, so extraction of all
+// the archives it is nested in stops as well.
+type limitError struct {
+	reason string
+	total  bool
+}
+
+func (e *limitError) Error() string {
+	return e.reason
+}
+
+func exceedsDepth(limit int) *limitError {
+	return &limitError{reason: fmt.Sprintf("archive nested more than %d levels deep", limit)}
+}
+
+func exceedsIndexDepth(limit int) *limitError {
+	return &limitError{reason: fmt.Sprintf("image index nested more than %d levels deep", limit)}
+}
+
+func nestedIndex(digest string) *limitError {
+	return &limitError{reason: fmt.Sprintf("image index %s nested in itself", digest)}
+}
+
+func exceedsMemberSize(limit int64) *limitError {
+	return &limitError{reason: fmt.Sprintf("archive member larger than %s", humanize.IBytes(uint64(limit)))}
+}
+
+func exceedsRatio(limit int) *limitError {
+	return &limitError{reason: fmt.Sprintf("compression ratio higher than %d", limit)}
+}
+
+func exceedsTotalSize(limit int64) *limitError {
+	return &limitError{reason: fmt.Sprintf("more than %s extracted", humanize.IBytes(uint64(limit))), total: true}
+}
+
+func exceedsMembers(limit int) *limitError {
+	return &limitError{reason: fmt.Sprintf("more than %d archive members", limit), total: true}
+}
+
+// A budget is what is left of the limits while extracting a single file
+// given to the scanner.
+type budget struct {
+	extracted int64
+	members   int
+}
+
+// memberReader reads an archive member, enforcing the member size and total
+// size limits. If compressed is set, it returns the compressed size of the
+// member, so the compression ratio is enforced as well.
+type memberReader struct {
+	r          io.Reader
+	e          extraction
+	n          int64
+	compressed func() int64
+}
+
+func (r *memberReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	r.e.budget.extracted += int64(n)
+	limits := r.e.limits
+	switch {
+	case limits.MaxMemberSize >= 0 && r.n > limits.MaxMemberSize:
+		return n, exceedsMemberSize(limits.MaxMemberSize)
+	case limits.MaxTotalSize >= 0 && r.e.budget.extracted > limits.MaxTotalSize:
+		return n, exceedsTotalSize(limits.MaxTotalSize)
+	case r.compressed != nil && exceedsRatioOf(limits.MaxRatio, r.n, r.compressed()):
+		return n, exceedsRatio(limits.MaxRatio)
+	}
+	return n, err
+}
+
+// exceedsRatioOf reports whether extracting n bytes out of compressed bytes
+// exceeds the maximum compression ratio.
+func exceedsRatioOf(limit int, n, compressed int64) bool {
+	return limit >= 0 && n > minRatioSize && n > int64(limit)*max(compressed, 1)
+}
+
+// ratioReader reads a decompressed stream, enforcing the compression ratio
+// against the number of compressed bytes read so far.
+type ratioReader struct {
+	r          io.Reader
+	max        int
+	n          int64
+	compressed *countingReader
+}
+
+func (r *ratioReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if exceedsRatioOf(r.max, r.n, r.compressed.n) {
+		return n, exceedsRatio(r.max)
+	}
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,151 @@
+package scanner
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// A zipMember is a member of an archive built by zipOf.
+type zipMember struct {
+	name string
+	data []byte
+}
+
+// zipOf returns a zip archive of the members, deflated.
+func zipOf(t *testing.T, members ...zipMember) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, m := range members {
+		w, err := zw.Create(m.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(m.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// gzipOf returns data compressed with gzip.
+func gzipOf(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// limitReasons returns the paths within res of the results that exceeded a
+// limit, including res itself as "", with their reasons.
+func limitReasons(res ScanResult) []string {
+	var found []string
+	res.Walk(func(member *ScanResult) {
+		if member.Verdict == LimitsExceeded {
+			name := strings.TrimPrefix(strings.TrimPrefix(member.Path, res.Path), "!")
+			found = append(found, fmt.Sprintf("%s: %s", name, member.Reason))
+		}
+	})
+	return found
+}
+
+func TestLimits(t *testing.T) {
+	member := func(name string, size int) zipMember {
+		return zipMember{name, []byte(pattern(size, name[0]))}
+	}
+	zeros := make([]byte, 2<<20)
+	tests := []struct {
+		name    string
+		data    []byte
+		limits  Limits
+		verdict Verdict
+		want    []string
+		reasons []string
+	}{
+		{
+			name:    "depth",
+			data:    zipOf(t, zipMember{"a.zip", zipOf(t, zipMember{"b.zip", zipOf(t, member("c", 10))})}),
+			limits:  Limits{MaxDepth: 1},
+			verdict: Clean,
+			want:    []string{"a.zip limits exceeded"},
+			reasons: []string{"a.zip: archive nested more than 1 levels deep"},
+		},
+		{
+			name:    "total size",
+			data:    zipOf(t, member("a", 1000), member("b", 1000), member("c", 1000)),
+			limits:  Limits{MaxTotalSize: 1500},
+			verdict: LimitsExceeded,
+			want:    []string{"a clean", "b limits exceeded"},
+			reasons: []string{": more than 1.5 KiB extracted", "b: more than 1.5 KiB extracted"},
+		},
+		{
+			name:    "member size",
+			data:    zipOf(t, member("a", 2000), member("b", 500)),
+			limits:  Limits{MaxMemberSize: 1000},
+			verdict: Clean,
+			want:    []string{"a limits exceeded", "b clean"},
+			reasons: []string{"a: archive member larger than 1000 B"},
+		},
+		{
+			name:    "member count",
+			data:    zipOf(t, member("a", 10), member("b", 10), member("c", 10), member("d", 10)),
+			limits:  Limits{MaxMembers: 2},
+			verdict: LimitsExceeded,
+			want:    []string{"a clean", "b clean"},
+			reasons: []string{": more than 2 archive members"},
+		},
+		{
+			name:    "zip member ratio",
+			data:    zipOf(t, zipMember{"zeros", zeros}),
+			verdict: Clean,
+			want:    []string{"zeros limits exceeded"},
+			reasons: []string{"zeros: compression ratio higher than 250"},
+		},
+		{
+			name:    "gzip ratio",
+			data:    gzipOf(t, zeros),
+			verdict: Clean,
+			want:    []string{"sample limits exceeded"},
+			reasons: []string{"sample: compression ratio higher than 250"},
+		},
+		{
+			name:    "limits disabled",
+			data:    zipOf(t, zipMember{"a.zip", zipOf(t, zipMember{"zeros", zeros})}),
+			limits:  Limits{MaxDepth: -1, MaxTotalSize: -1, MaxMemberSize: -1, MaxMembers: -1, MaxRatio: -1},
+			verdict: Clean,
+			want:    []string{"a.zip clean", "a.zip!zeros clean"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Members are read even if no signature has their size, so
+			// they count towards the total size
+			s := newTestScanner(t, Options{ScanArchives: true, SkipSize: true, Limits: tt.limits})
+			res := s.ScanReader(context.Background(), bytes.NewReader(tt.data), "sample")
+			if res.Verdict != tt.verdict {
+				t.Errorf("got verdict %v (%s), want %v", res.Verdict, res.Reason, tt.verdict)
+			}
+			if got := verdicts(res); !slices.Equal(got, tt.want) {
+				t.Errorf("got members %q, want %q", got, tt.want)
+			}
+			if got := limitReasons(res); !slices.Equal(got, tt.reasons) {
+				t.Errorf("got limits %q, want %q", got, tt.reasons)
+			}
+		})
+	}
+}
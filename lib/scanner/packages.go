@@ -0,0 +1,453 @@
+package scanner
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Maximum size of the package metadata read to attribute a package, such as
+// the control file of a .deb.
+const maxMetadataSize = 1 << 20
+
+// An arEntry is a member of an ar archive.
+type arEntry struct {
+	name   string
+	offset int64
+	size   int64
+}
+
+// readAr returns the members of the ar archive in ra, of the given size. Both
+// the GNU and the BSD variants of long names are supported. Symbol tables are
+// left out.
+func readAr(ra io.ReaderAt, size int64) ([]arEntry, error) {
+	var entries []arEntry
+	var longNames []byte
+	for offset := int64(8); offset < size; {
+		var hdr [60]byte
+		if _, err := ra.ReadAt(hdr[:], offset); err != nil {
+			return nil, fmt.Errorf("reading ar header: %w", err)
+		}
+		if string(hdr[58:60]) != "`\n" {
+			return nil, errors.New("invalid ar header")
+		}
+		entry := arEntry{offset: offset + 60}
+		var err error
+		entry.size, err = strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil || entry.size < 0 || entry.size > size-entry.offset {
+			return nil, errors.New("invalid ar member size")
+		}
+		offset = entry.offset + entry.size + entry.size%2
+
+		name := strings.TrimRight(string(hdr[0:16]), " ")
+		switch {
+		case name == "/" || name == "/SYM64/" || name == "__.SYMDEF" || name == "__.SYMDEF SORTED":
+			continue
+		case name == "//":
+			if entry.size > maxMetadataSize {
+				return nil, errors.New("ar long name table too large")
+			}
+			longNames = make([]byte, entry.size)
+			if _, err := ra.ReadAt(longNames, entry.offset); err != nil {
+				return nil, fmt.Errorf("reading ar long names: %w", err)
+			}
+			continue
+		case strings.HasPrefix(name, "#1/"):
+			// BSD: the name precedes the data
+			n, err := strconv.ParseInt(name[3:], 10, 64)
+			if err != nil || n < 0 || n > min(entry.size, 4096) {
+				return nil, errors.New("invalid ar member name")
+			}
+			buf := make([]byte, n)
+			if _, err := ra.ReadAt(buf, entry.offset); err != nil {
+				return nil, fmt.Errorf("reading ar member name: %w", err)
+			}
+			name = string(bytes.TrimRight(buf, "\x00"))
+			entry.offset += n
+			entry.size -= n
+		case len(name) > 1 && name[0] == '/':
+			// GNU: the name is in the long name table
+			i, err := strconv.Atoi(name[1:])
+			if err != nil || i < 0 || i >= len(longNames) {
+				return nil, errors.New("invalid ar member name")
+			}
+			name = string(longNames[i:])
+			if end := strings.Index(name, "/\n"); end >= 0 {
+				name = name[:end]
+			}
+		default:
+			name = strings.TrimSuffix(name, "/")
+		}
+		entry.name = name
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// scanAr scans the members of an ar archive. If it is a .deb package, the
+// files in its data archive become members of res, as in
+// hello.deb!usr/bin/hello, while its other members are scanned as they are.
+func (s *Scanner) scanAr(e extraction, ra io.ReaderAt, size int64, res *ScanResult) error {
+	entries, err := readAr(ra, size)
+	if err != nil {
+		return err
+	}
+	deb := len(entries) > 0 && entries[0].name == "debian-binary"
+	if deb {
+		res.Package = debPackage(ra, entries)
+	}
+	for _, entry := range entries {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+		r := io.NewSectionReader(ra, entry.offset, entry.size)
+		if deb && strings.HasPrefix(entry.name, "data.tar") {
+			err = s.scanPayload(e, r, res)
+		} else {
+			err = s.scanMember(e, r, entry.name, entry.size, nil, res)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// debPackage returns the package described by the control file of a .deb,
+// or nil if it can't be read.
+func debPackage(ra io.ReaderAt, entries []arEntry) *Package {
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.name, "control.tar") {
+			continue
+		}
+		control, err := readMetadata(io.NewSectionReader(ra, entry.offset, entry.size), "control")
+		if err != nil {
+			return nil
+		}
+		fields := parseFields(control, ":")
+		if fields["Package"] == "" {
+			return nil
+		}
+		return &Package{Format: "deb", Name: fields["Package"], Version: fields["Version"]}
+	}
+	return nil
+}
+
+// readMetadata returns the contents of the file named name in the tar
+// archive read from r, which may be compressed.
+func readMetadata(r io.Reader, name string) ([]byte, error) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, _ := br.Peek(sniffLen)
+	r = br
+	if format := detectFormat(head); format != formatTar {
+		dr, err := decompress(format, br)
+		if err != nil {
+			return nil, err
+		}
+		defer dr.Close()
+		r = dr
+	}
+
+	// Metadata archives are small, don't decompress large ones to the end
+	tr := tar.NewReader(io.LimitReader(r, 16*maxMetadataSize))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return nil, err
+		}
+		if path.Clean("/"+hdr.Name) == "/"+name && hdr.Size <= maxMetadataSize {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// parseFields parses the "key: value" lines of a control file or manifest,
+// up to the first blank line. Continuation lines, which start with a space,
+// are appended to the value of their field.
+func parseFields(data []byte, sep string) map[string]string {
+	fields := make(map[string]string)
+	var last string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case line == "":
+			return fields
+		case line[0] == ' ' || line[0] == '\t':
+			if last != "" {
+				fields[last] += strings.TrimPrefix(line, " ")
+			}
+		default:
+			key, value, ok := strings.Cut(line, sep)
+			if !ok {
+				continue
+			}
+			last = strings.TrimSpace(key)
+			fields[last] = strings.TrimSpace(value)
+		}
+	}
+	return fields
+}
+
+// parsePkgInfo returns the package described by the .PKGINFO file of an
+// Alpine or Arch Linux package, or nil if it names no package.
+func parsePkgInfo(data []byte) *Package {
+	pkg := &Package{Format: "apk"}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "#") && strings.Contains(line, "makepkg") {
+			pkg.Format = "pacman"
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "pkgname":
+			pkg.Name = strings.TrimSpace(value)
+		case "pkgver":
+			pkg.Version = strings.TrimSpace(value)
+		}
+	}
+	if pkg.Name == "" {
+		return nil
+	}
+	return pkg
+}
+
+// jarPackage returns the package described by the manifest or the Maven
+// properties of a .jar, .war or .ear, or nil if zr has none.
+func jarPackage(zr *zip.Reader) *Package {
+	readFile := func(f *zip.File) []byte {
+		if f.UncompressedSize64 > maxMetadataSize {
+			return nil
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil
+		}
+		defer rc.Close()
+		data, _ := io.ReadAll(io.LimitReader(rc, maxMetadataSize))
+		return data
+	}
+
+	for _, f := range zr.File {
+		if f.Name != "META-INF/MANIFEST.MF" {
+			continue
+		}
+		fields := parseFields(readFile(f), ":")
+		name, _, _ := strings.Cut(fields["Bundle-SymbolicName"], ";")
+		if name = strings.TrimSpace(name); name != "" {
+			return &Package{Format: "jar", Name: name, Version: fields["Bundle-Version"]}
+		}
+		if name := fields["Implementation-Title"]; name != "" {
+			return &Package{Format: "jar", Name: name, Version: fields["Implementation-Version"]}
+		}
+	}
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, "META-INF/maven/") || path.Base(f.Name) != "pom.properties" {
+			continue
+		}
+		fields := parseFields(readFile(f), "=")
+		if name := fields["artifactId"]; name != "" {
+			if group := fields["groupId"]; group != "" {
+				name = group + ":" + name
+			}
+			return &Package{Format: "jar", Name: name, Version: fields["version"]}
+		}
+	}
+	return nil
+}
+
+// RPM header tags and types used to attribute a package.
+const (
+	rpmTagName    = 1000
+	rpmTagVersion = 1001
+	rpmTagRelease = 1002
+
+	rpmTypeString = 6
+
+	// Limits of rpm itself on the size of a header
+	rpmMaxTags = 0xffff
+	rpmMaxData = 256 << 20
+)
+
+// An rpmHeader is a header structure of an .rpm, of which there are two: the
+// signature header and the main header.
+type rpmHeader struct {
+	ra     io.ReaderAt
+	index  []byte
+	data   int64 // offset of the data store
+	length int64 // size of the whole structure
+}
+
+// readRpmHeader reads the header structure at offset of ra.
+func readRpmHeader(ra io.ReaderAt, offset int64) (*rpmHeader, error) {
+	var intro [16]byte
+	if _, err := ra.ReadAt(intro[:], offset); err != nil {
+		return nil, fmt.Errorf("reading rpm header: %w", err)
+	}
+	if !bytes.Equal(intro[:4], []byte{0x8e, 0xad, 0xe8, 0x01}) {
+		return nil, errors.New("invalid rpm header")
+	}
+	tags := binary.BigEndian.Uint32(intro[8:])
+	data := binary.BigEndian.Uint32(intro[12:])
+	if tags > rpmMaxTags || data > rpmMaxData {
+		return nil, errors.New("rpm header too large")
+	}
+	hdr := &rpmHeader{
+		ra:     ra,
+		index:  make([]byte, 16*tags),
+		data:   offset + 16 + 16*int64(tags),
+		length: 16 + 16*int64(tags) + int64(data),
+	}
+	if _, err := ra.ReadAt(hdr.index, offset+16); err != nil {
+		return nil, fmt.Errorf("reading rpm header: %w", err)
+	}
+	return hdr, nil
+}
+
+// str returns the string value of tag, or "" if the header has none.
+func (h *rpmHeader) str(tag uint32) string {
+	for i := 0; i < len(h.index); i += 16 {
+		entry := h.index[i : i+16]
+		if binary.BigEndian.Uint32(entry) != tag || binary.BigEndian.Uint32(entry[4:]) != rpmTypeString {
+			continue
+		}
+		buf := make([]byte, 256)
+		n, _ := h.ra.ReadAt(buf, h.data+int64(binary.BigEndian.Uint32(entry[8:])))
+		value, _, _ := bytes.Cut(buf[:n], []byte{0})
+		return string(value)
+	}
+	return ""
+}
+
+// scanRpm scans the files in the payload of an .rpm package. They become
+// members of res, as in hello.rpm!usr/bin/hello.
+func (s *Scanner) scanRpm(e extraction, ra io.ReaderAt, size int64, res *ScanResult) error {
+	// The lead is followed by the signature header, padded to 8 bytes, and
+	// the main header
+	const leadSize = 96
+	sig, err := readRpmHeader(ra, leadSize)
+	if err != nil {
+		return err
+	}
+	offset := leadSize + (sig.length+7)/8*8
+	hdr, err := readRpmHeader(ra, offset)
+	if err != nil {
+		return err
+	}
+	offset += hdr.length
+	if offset > size {
+		return errors.New("truncated rpm header")
+	}
+
+	if name := hdr.str(rpmTagName); name != "" {
+		res.Package = &Package{Format: "rpm", Name: name, Version: hdr.str(rpmTagVersion)}
+		if release := hdr.str(rpmTagRelease); release != "" {
+			res.Package.Version += "-" + release
+		}
+	}
+	return s.scanPayload(e, io.NewSectionReader(ra, offset, size-offset), res)
+}
+
+// A cpioHeader is the header of a member of a cpio archive.
+type cpioHeader struct {
+	name  string
+	mode  int64
+	nlink int64
+	size  int64
+}
+
+// cpioReader reads the members of a cpio archive in the "newc" format, used
+// by rpm and initramfs. Like tar.Reader, next advances to the next member,
+// whose contents are then read from the cpioReader.
+type cpioReader struct {
+	r    io.Reader
+	data io.LimitedReader
+	pad  int64
+}
+
+func newCpioReader(r io.Reader) *cpioReader {
+	return &cpioReader{r: r, data: io.LimitedReader{R: r}}
+}
+
+func (cr *cpioReader) Read(p []byte) (int, error) {
+	return cr.data.Read(p)
+}
+
+func (cr *cpioReader) next() (*cpioHeader, error) {
+	if _, err := io.CopyN(io.Discard, cr.r, cr.data.N+cr.pad); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	cr.data.N, cr.pad = 0, 0
+
+	var buf [110]byte
+	if _, err := io.ReadFull(cr.r, buf[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if magic := string(buf[:6]); magic != "070701" && magic != "070702" {
+		return nil, errors.New("invalid cpio header")
+	}
+	field := func(i int) (int64, error) {
+		return strconv.ParseInt(string(buf[6+8*i:14+8*i]), 16, 64)
+	}
+	var hdr cpioHeader
+	var nameSize int64
+	for i, v := range map[int]*int64{1: &hdr.mode, 4: &hdr.nlink, 6: &hdr.size, 11: &nameSize} {
+		var err error
+		if *v, err = field(i); err != nil {
+			return nil, errors.New("invalid cpio header")
+		}
+	}
+	if nameSize < 1 || nameSize > 4096 {
+		return nil, errors.New("invalid cpio member name")
+	}
+
+	// The name and the contents are padded to 4 bytes
+	name := make([]byte, (110+nameSize+3)/4*4-110)
+	if _, err := io.ReadFull(cr.r, name); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	hdr.name = string(name[:nameSize-1])
+	if hdr.name == "TRAILER!!!" {
+		return nil, io.EOF
+	}
+	cr.data.N = hdr.size
+	cr.pad = (4 - hdr.size%4) % 4
+	return &hdr, nil
+}
+
+// unexpectedEOF returns err, or io.ErrUnexpectedEOF if it is io.EOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (s *Scanner) scanCpio(e extraction, r io.Reader, res *ScanResult) error {
+	cr := newCpioReader(r)
+	for {
+		hdr, err := cr.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// The contents of hard links are stored with the last link only
+		if hdr.mode&0o170000 != 0o100000 || hdr.size == 0 && hdr.nlink > 1 {
+			continue
+		}
+		if err := s.scanMember(e, cr, hdr.name, hdr.size, nil, res); err != nil {
+			return err
+		}
+	}
+}
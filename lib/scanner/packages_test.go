@@ -0,0 +1,248 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// arOf returns an ar archive of the members, with GNU style names.
+func arOf(members ...zipMember) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("!<arch>\n")
+	for _, m := range members {
+		fmt.Fprintf(&buf, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", m.name+"/", 0, 0, 0, "100644", len(m.data))
+		buf.Write(m.data)
+		if len(m.data)%2 != 0 {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// cpioOf returns a cpio archive of the members in the newc format.
+func cpioOf(members ...zipMember) []byte {
+	var buf bytes.Buffer
+	pad := func() {
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	add := func(name string, mode int, data []byte) {
+		fmt.Fprintf(&buf, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			0, mode, 0, 0, 1, 0, len(data), 0, 0, 0, 0, len(name)+1, 0)
+		buf.WriteString(name + "\x00")
+		pad()
+		buf.Write(data)
+		pad()
+	}
+	for _, m := range members {
+		add(m.name, 0o100644, m.data)
+	}
+	add("TRAILER!!!", 0, nil)
+	return buf.Bytes()
+}
+
+// rpmOf returns an .rpm of the package name, version and release with the
+// payload.
+func rpmOf(name, version, release string, payload []byte) []byte {
+	var buf bytes.Buffer
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb})
+	buf.Write(lead)
+	header := func(tags [][2]any) {
+		var index, data bytes.Buffer
+		for _, tag := range tags {
+			binary.Write(&index, binary.BigEndian, []uint32{tag[0].(uint32), rpmTypeString, uint32(data.Len()), 1})
+			data.WriteString(tag[1].(string) + "\x00")
+		}
+		buf.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+		binary.Write(&buf, binary.BigEndian, []uint32{uint32(len(tags)), uint32(data.Len())})
+		buf.Write(index.Bytes())
+		buf.Write(data.Bytes())
+	}
+	// An empty signature header, which needs no padding
+	header(nil)
+	header([][2]any{{uint32(rpmTagName), name}, {uint32(rpmTagVersion), version}, {uint32(rpmTagRelease), release}})
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+// memberPackages returns the paths within res of its members, recursively,
+// with the packages they are attributed to, e.g. "usr/bin/hello deb:hello 1.0".
+func memberPackages(res ScanResult) []string {
+	var found []string
+	res.Walk(func(member *ScanResult) {
+		name, ok := strings.CutPrefix(member.Path, res.Path+"!")
+		if !ok {
+			return
+		}
+		pkg := "none"
+		if member.Package != nil {
+			pkg = member.Package.Format + ":" + member.Package.String()
+		}
+		found = append(found, name+" "+pkg)
+	})
+	return found
+}
+
+// The files in packages are scanned and attributed to the package.
+func TestScanPackages(t *testing.T) {
+	evil := []byte(malware)
+	control := zipMember{"./control", []byte("Package: hello\nVersion: 2.10-3\nDescription: greets\n continued\n")}
+	deb := arOf(
+		zipMember{"debian-binary", []byte("2.0\n")},
+		zipMember{"control.tar.gz", gzipOf(t, tarOf(t, control))},
+		zipMember{"data.tar.gz", gzipOf(t, tarOf(t, zipMember{"./usr/bin/hello", evil}))},
+	)
+	jar := func(members ...zipMember) []byte {
+		return zipOf(t, append(members, zipMember{"com/Evil.class", evil})...)
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		data    []byte
+		pkg     string
+		want    []string
+		wantPkg []string
+	}{
+		{
+			name: "deb", file: "hello.deb", data: deb, pkg: "deb:hello 2.10-3",
+			want: []string{"debian-binary clean", "control.tar.gz clean", "control.tar.gz!control clean", "usr/bin/hello infected"},
+			wantPkg: []string{
+				"debian-binary deb:hello 2.10-3", "control.tar.gz deb:hello 2.10-3",
+				"control.tar.gz!control deb:hello 2.10-3", "usr/bin/hello deb:hello 2.10-3",
+			},
+		},
+		{
+			name: "deb without control", file: "hello.deb", pkg: "none",
+			data: arOf(zipMember{"debian-binary", []byte("2.0\n")}, zipMember{"data.tar", tarOf(t, zipMember{"evil", evil})}),
+			want: []string{"debian-binary clean", "evil infected"},
+		},
+		{
+			name: "static library", file: "libevil.a", pkg: "none",
+			data: arOf(zipMember{"a.o", []byte("object\n")}, zipMember{"evil.o", evil}),
+			want: []string{"a.o clean", "evil.o infected"},
+		},
+		{
+			name: "rpm", file: "hello.rpm", pkg: "rpm:hello 2.10-1.fc40",
+			data: rpmOf("hello", "2.10", "1.fc40", gzipOf(t, cpioOf(zipMember{"./usr/bin/hello", evil}, zipMember{"./usr/share/doc/README", []byte("hello\n")}))),
+			want: []string{"usr/bin/hello infected", "usr/share/doc/README clean"},
+		},
+		{
+			name: "apk", file: "hello.apk", pkg: "apk:hello 2.10-r0",
+			data: gzipOf(t, tarOf(t, zipMember{".PKGINFO", []byte("pkgname = hello\npkgver = 2.10-r0\n")}, zipMember{"usr/bin/hello", evil})),
+			want: []string{".PKGINFO clean", "usr/bin/hello infected"},
+		},
+		{
+			name: "pacman", file: "hello.pkg.tar.gz", pkg: "pacman:hello 2.10-1",
+			data: gzipOf(t, tarOf(t, zipMember{".PKGINFO", []byte("# Generated by makepkg 6.0.2\npkgname = hello\npkgver = 2.10-1\n")}, zipMember{"usr/bin/hello", evil})),
+			want: []string{".PKGINFO clean", "usr/bin/hello infected"},
+		},
+		{
+			name: "jar manifest", file: "hello.jar", pkg: "jar:hello 1.0",
+			data: jar(zipMember{"META-INF/MANIFEST.MF", []byte("Manifest-Version: 1.0\r\nImplementation-Title: hello\r\nImplementation-Version: 1.0\r\n")}),
+			want: []string{"META-INF/MANIFEST.MF clean", "com/Evil.class infected"},
+		},
+		{
+			name: "osgi bundle", file: "hello.jar", pkg: "jar:org.hello 2.0",
+			data: jar(zipMember{"META-INF/MANIFEST.MF", []byte("Bundle-SymbolicName: org.hello;singleton:=true\nBundle-Version: 2.0\nImplementation-Title: other\n")}),
+			want: []string{"META-INF/MANIFEST.MF clean", "com/Evil.class infected"},
+		},
+		{
+			name: "maven", file: "hello.jar", pkg: "jar:org.example:hello 3.0",
+			data: jar(zipMember{"META-INF/maven/org.example/hello/pom.properties", []byte("groupId=org.example\nartifactId=hello\nversion=3.0\n")}),
+			want: []string{"META-INF/maven/org.example/hello/pom.properties clean", "com/Evil.class infected"},
+		},
+		{
+			name: "plain zip", file: "hello.zip", pkg: "none",
+			data: jar(),
+			want: []string{"com/Evil.class infected"},
+		},
+		{
+			name: "jar in deb", file: "app.deb", pkg: "deb:app 1.0",
+			data: arOf(
+				zipMember{"debian-binary", []byte("2.0\n")},
+				zipMember{"control.tar", tarOf(t, zipMember{"control", []byte("Package: app\nVersion: 1.0\n")})},
+				zipMember{"data.tar", tarOf(t, zipMember{"usr/lib/lib.jar", jar(zipMember{"META-INF/MANIFEST.MF", []byte("Implementation-Title: lib\n")})})},
+			),
+			want: []string{
+				"debian-binary clean", "control.tar clean", "control.tar!control clean",
+				"usr/lib/lib.jar clean", "usr/lib/lib.jar!META-INF/MANIFEST.MF clean", "usr/lib/lib.jar!com/Evil.class infected",
+			},
+			wantPkg: []string{
+				"debian-binary deb:app 1.0", "control.tar deb:app 1.0", "control.tar!control deb:app 1.0",
+				"usr/lib/lib.jar jar:lib", "usr/lib/lib.jar!META-INF/MANIFEST.MF jar:lib", "usr/lib/lib.jar!com/Evil.class jar:lib",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			s := newTestScanner(t, Options{ScanArchives: true, SkipSize: true})
+			res := s.ScanFile(context.Background(), path)
+			if res.Verdict != Clean {
+				t.Fatalf("got verdict %v (%v), want %v", res.Verdict, res.Err, Clean)
+			}
+			pkg := "none"
+			if res.Package != nil {
+				pkg = res.Package.Format + ":" + res.Package.String()
+			}
+			if pkg != tt.pkg {
+				t.Errorf("got package %s, want %s", pkg, tt.pkg)
+			}
+			if got := verdicts(res); !slices.Equal(got, tt.want) {
+				t.Errorf("got members %q, want %q", got, tt.want)
+			}
+
+			// Unless given, every member belongs to the package
+			wantPkg := tt.wantPkg
+			if wantPkg == nil {
+				for _, member := range tt.want {
+					name, _, _ := strings.Cut(member, " ")
+					wantPkg = append(wantPkg, name+" "+tt.pkg)
+				}
+			}
+			if got := memberPackages(res); !slices.Equal(got, wantPkg) {
+				t.Errorf("got packages %q, want %q", got, wantPkg)
+			}
+		})
+	}
+}
+
+// Malformed packages are reported as errors reading the archive.
+func TestScanPackagesMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"ar header", append(arOf(zipMember{"a", []byte("a")}), "junk"...)},
+		{"ar member size", []byte("!<arch>\na/              0           0     0     100644  99999     `\n")},
+		{"rpm header", append([]byte{0xed, 0xab, 0xee, 0xdb}, make([]byte, 200)...)},
+		{"rpm payload", rpmOf("hello", "1", "1", []byte("not a payload"))},
+		{"cpio", gzipOf(t, cpioOf(zipMember{"a", []byte("a")})[:120])},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "package")
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			s := newTestScanner(t, Options{ScanArchives: true, SkipSize: true})
+			res := s.ScanFile(context.Background(), path)
+			if len(res.Members) == 0 || res.Members[len(res.Members)-1].Path != res.Path {
+				t.Errorf("got members %q, want an error reading the archive", verdicts(res))
+			}
+		})
+	}
+}
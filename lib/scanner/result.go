@@ -0,0 +1,190 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hexahigh/goava/lib/db"
+	"github.com/hexahigh/goava/lib/git"
+)
+
+// A Verdict is the outcome of scanning a file.
+type Verdict int
+
+const (
+	// No signature matched the file
+	Clean Verdict = iota
+
+	// The hash of the file matched a signature
+	Infected
+
+	// The file is in a known-good index, see db.ImportNSRL
+	KnownClean
+
+	// The file is similar to a fuzzy signature
+	Similar
+
+	// The file is an archive or archive member that exceeds the limits of
+	// extraction, such as an archive bomb. See Options.Limits and
+	// ScanResult.Reason
+	LimitsExceeded
+
+	// The file was not scanned, e.g. because it is a device. See
+	// ScanResult.Reason
+	Skipped
+
+	// The file could not be scanned. See ScanResult.Err
+	Error
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Clean:
+		return "clean"
+	case Infected:
+		return "infected"
+	case KnownClean:
+		return "known clean"
+	case Similar:
+		return "similar"
+	case LimitsExceeded:
+		return "limits exceeded"
+	case Skipped:
+		return "skipped"
+	case Error:
+		return "error"
+	}
+	return fmt.Sprintf("Verdict(%d)", int(v))
+}
+
+// MarshalText encodes the verdict as its name, e.g. in JSON output.
+func (v Verdict) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+// A ScanResult is the result of scanning a single file.
+type ScanResult struct {
+	Path string
+
+	// The size of the file, or -1 if it was not determined
+	Size int64
+
+	// The hashes of the file by hash type, such as md5, ssdeep and tlsh, or
+	// nil if the file was not hashed. Files are not hashed if no signature
+	// has their size
+	Hashes map[string]string `json:",omitempty"`
+
+	Verdict Verdict
+
+	// The signature the file matched if it is Infected, or the fuzzy
+	// signature it is most similar to if it is Similar
+	Matches []db.HDBItem `json:",omitempty"`
+
+	// The ssdeep score or TLSH distance of the fuzzy signature the file is
+	// similar to, see db.FuzzyMatch
+	Score int `json:",omitempty"`
+
+	// Why the file was skipped, e.g. "symlink", what failed if the verdict
+	// is Error, or which limit was exceeded
+	Reason string `json:",omitempty"`
+
+	// The error that prevented the file from being scanned
+	Err error `json:"-"`
+
+	// The package the file is, or is installed by if it is a member of a
+	// package, read from the package metadata
+	Package *Package `json:",omitempty"`
+
+	// The digest of the container image layer the file is in, see
+	// Scanner.ScanImage
+	Layer string `json:",omitempty"`
+
+	// For every path of the file in the history of a Git repository, the
+	// first commit it appears at there, see Scanner.ScanGit
+	Git []git.Occurrence `json:",omitempty"`
+
+	// The results of the members of the file if it is an archive and
+	// ScanArchives is enabled, in the order they are stored. Their paths are
+	// in nested path notation, e.g. bundle.tar.gz!inner/evil.zip!payload.exe
+	Members []ScanResult `json:",omitempty"`
+}
+
+// A Package is a software package, such as a .deb, .rpm, .apk or .jar.
+type Package struct {
+	// The package format: deb, rpm, apk, pacman or jar
+	Format string
+
+	Name    string
+	Version string `json:",omitempty"`
+}
+
+// String returns the name and version of the package, e.g. "hello 2.10-3".
+func (p *Package) String() string {
+	if p.Version == "" {
+		return p.Name
+	}
+	return p.Name + " " + p.Version
+}
+
+// Walk calls fn with r and then the results of its members, recursively.
+func (r *ScanResult) Walk(fn func(*ScanResult)) {
+	fn(r)
+	for i := range r.Members {
+		r.Members[i].Walk(fn)
+	}
+}
+
+// fail sets the verdict of r to Error and returns r. If err is a limitError,
+// the verdict is LimitsExceeded instead.
+func (r *ScanResult) fail(reason string, err error) ScanResult {
+	var limitErr *limitError
+	if errors.As(err, &limitErr) {
+		return r.exceed(limitErr)
+	}
+	r.Verdict = Error
+	r.Reason = reason
+	r.Err = err
+	return *r
+}
+
+// exceed sets the verdict of r to LimitsExceeded, unless it is Infected, and
+// returns r.
+func (r *ScanResult) exceed(err *limitError) ScanResult {
+	if r.Verdict != Infected {
+		r.Verdict = LimitsExceeded
+		r.Reason = err.Error()
+		r.Err = err
+	}
+	return *r
+}
+
+// skip sets the verdict of r to Skipped and returns r.
+func (r *ScanResult) skip(reason string) ScanResult {
+	r.Verdict = Skipped
+	r.Reason = reason
+	return *r
+}
+
+// Stats are the counters of the scans done by a Scanner.
+type Stats struct {
+	ScannedFiles   int64
+	ScannedFolders int64
+	InfectedFiles  int64
+	KnownClean     int64
+	SimilarFiles   int64
+
+	// Archives looked into, and members scanned inside them. Members are
+	// counted as infected, known clean or similar files as well, but not as
+	// scanned files
+	Archives       int64
+	ArchiveMembers int64
+
+	// Archives and members with the LimitsExceeded verdict. If
+	// Options.LimitsExceededInfected is set, they are counted as infected
+	// files as well
+	LimitsExceeded int64
+
+	// Bytes in the scanned files, and bytes actually read to hash them
+	DataScanned uint64
+	DataRead    uint64
+}
@@ -0,0 +1,443 @@
+// Package scanner scans files for the signatures of a signature database.
+package scanner
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/hexahigh/goava/lib/db"
+	"github.com/hexahigh/goava/lib/fuzzy"
+)
+
+// Options configure a Scanner.
+type Options struct {
+	// The signatures to scan for. The database must be initialized and
+	// loaded, and may be reloaded while scanning
+	DB *db.DB
+
+	// Skip the size check, so every file is read and hashed even if no
+	// signature has its size
+	SkipSize bool
+
+	// Resolve symbolic links. If disabled, ScanPath skips symbolic links
+	FollowSymlinks bool
+
+	// Scan directories recursively in ScanPath. If disabled, directories are
+	// skipped
+	Recursive bool
+
+	// Number of files scanned concurrently by ScanPath.
+	// Defaults to GOMAXPROCS if 0 or less
+	Jobs int
+
+	// Look into zip, tar, cpio and ar archives, gzip, bzip2, xz and zstd
+	// streams, .deb, .rpm, .apk and .jar packages and ISO 9660 and FAT disk
+	// images, detected by their content, and scan their members
+	// recursively. The members are scanned in addition to the archive
+	// itself, see ScanResult.Members. The files in packages are attributed
+	// to the package, see ScanResult.Package
+	ScanArchives bool
+
+	// Scan every layer of container images separately in ScanImage, rather
+	// than their final filesystem. Files deleted or replaced by a later layer
+	// are scanned as well
+	ScanImageLayers bool
+
+	// Limits of the recursive extraction of archives
+	Limits Limits
+
+	// Whether the LimitsExceeded verdict counts as infected, see Infected.
+	// Files that exceed the limits may be archive bombs, or may hide malware
+	// beyond them
+	LimitsExceededInfected bool
+
+	// Minimum ssdeep score, from 0 to 100, for a file to be reported as
+	// similar to a fuzzy signature. A score of 0 is never reported. Defaults
+	// to 80 if negative
+	SSDeepThreshold int
+
+	// Maximum TLSH distance for a file to be reported as similar to a fuzzy
+	// signature, 0 only reporting identical hashes. Defaults to 50 if
+	// negative
+	TLSHThreshold int
+}
+
+// A Scanner scans files for the signatures of a database. It is safe for
+// concurrent use.
+type Scanner struct {
+	opts  Options
+	stats stats
+}
+
+// New returns a Scanner configured by opts.
+func New(opts Options) (*Scanner, error) {
+	if opts.DB == nil {
+		return nil, errors.New("no signature database")
+	}
+	if opts.Jobs <= 0 {
+		opts.Jobs = runtime.GOMAXPROCS(0)
+	}
+	if opts.SSDeepThreshold < 0 {
+		opts.SSDeepThreshold = 80
+	}
+	if opts.TLSHThreshold < 0 {
+		opts.TLSHThreshold = 50
+	}
+	opts.Limits = opts.Limits.withDefaults()
+	return &Scanner{opts: opts}, nil
+}
+
+// ScanFile scans the file at path. Symbolic links are followed. If
+// FollowSymlinks is enabled, the path of the result is the resolved one.
+//
+// Devices, pipes and sockets are skipped, as are directories, which can be
+// scanned with ScanPath.
+func (s *Scanner) ScanFile(ctx context.Context, path string) ScanResult {
+	res := ScanResult{Path: path, Size: -1}
+	if err := ctx.Err(); err != nil {
+		return res.fail("Error scanning file", err)
+	}
+
+	//* Resolve symlinks if enabled
+	if s.opts.FollowSymlinks {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return res.fail("Error resolving symlink", err)
+		}
+		res.Path = resolved
+	}
+
+	file, err := os.Open(res.Path)
+	if err != nil {
+		return res.fail("Error opening file", err)
+	}
+	defer file.Close()
+
+	return s.scanOpenFile(ctx, file, res)
+}
+
+// scanOpenFile scans file, opened from res.Path. If file is an io.ReaderAt,
+// it is read in place, otherwise archives are spooled like by ScanReader.
+func (s *Scanner) scanOpenFile(ctx context.Context, file fs.File, res ScanResult) ScanResult {
+	stat, err := file.Stat()
+	if err != nil {
+		return res.fail("Error getting file stat", err)
+	}
+
+	if stat.IsDir() {
+		return res.skip("directory")
+	}
+
+	s.stats.scannedFiles.Add(1)
+
+	switch {
+	case stat.Mode()&fs.ModeDevice != 0:
+		return res.skip("device")
+	case stat.Mode()&fs.ModeNamedPipe != 0:
+		return res.skip("pipe")
+	case stat.Mode()&fs.ModeSocket != 0:
+		return res.skip("socket")
+	}
+
+	res.Size = stat.Size()
+	s.stats.dataScanned.Add(uint64(res.Size))
+	if ra, ok := file.(io.ReaderAt); ok {
+		s.scanContent(s.newExtraction(ctx), ra, res.Size, &res)
+	} else {
+		s.scanStream(s.newExtraction(ctx), file, &res)
+	}
+	s.count(&res)
+	return res
+}
+
+// ScanReader scans the contents of r as a single file named name. As the
+// size is only known once r is read to the end, r is always read completely.
+func (s *Scanner) ScanReader(ctx context.Context, r io.Reader, name string) ScanResult {
+	res := ScanResult{Path: name, Size: -1}
+	if err := ctx.Err(); err != nil {
+		return res.fail("Error scanning file", err)
+	}
+	s.stats.scannedFiles.Add(1)
+	s.scanStream(s.newExtraction(ctx), r, &res)
+	if res.Size > 0 {
+		s.stats.dataScanned.Add(uint64(res.Size))
+	}
+	s.count(&res)
+	return res
+}
+
+// ScanTar scans the members of the tar archive read from r, named name,
+// which may be compressed with gzip, bzip2, xz or zstd. Unlike ScanReader,
+// the archive is not spooled: its members are scanned as they are read, so
+// tar streams of any size can be scanned in a pipeline.
+//
+// Every member is extracted within its own limits, like a file on disk, and
+// becomes a member of the result, as in stdin!dir/evil.exe.
+func (s *Scanner) ScanTar(ctx context.Context, r io.Reader, name string) ScanResult {
+	res := ScanResult{Path: name, Size: -1}
+	if err := ctx.Err(); err != nil {
+		return res.fail("Error scanning archive", err)
+	}
+	s.stats.scannedFiles.Add(1)
+
+	br := bufio.NewReaderSize(&contextReader{ctx, r}, sniffLen)
+	head, _ := br.Peek(sniffLen)
+	var tr *tar.Reader
+	switch format := detectFormat(head); format {
+	case formatTar:
+		tr = tar.NewReader(br)
+	case formatGzip, formatBzip2, formatXz, formatZstd:
+		dr, err := decompress(format, br)
+		if err != nil {
+			return res.fail("Error reading archive", err)
+		}
+		defer dr.Close()
+		tr = tar.NewReader(dr)
+	default:
+		return res.fail("Error reading archive", errors.New("not a tar archive"))
+	}
+	s.stats.archives.Add(1)
+	res.Verdict = Clean
+
+	for ctx.Err() == nil {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			member := ScanResult{Path: res.Path, Size: -1}
+			res.Members = append(res.Members, member.fail("Error reading archive", err))
+			break
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		s.stats.dataScanned.Add(uint64(hdr.Size))
+		s.scanMember(s.newExtraction(ctx), tr, hdr.Name, hdr.Size, nil, &res)
+	}
+	return res
+}
+
+// Infected reports whether res counts as infected: if its verdict is
+// Infected, or LimitsExceeded and LimitsExceededInfected is set. The results
+// of archive members are not taken into account.
+func (s *Scanner) Infected(res *ScanResult) bool {
+	return res.Verdict == Infected || res.Verdict == LimitsExceeded && s.opts.LimitsExceededInfected
+}
+
+// count updates the stats with the final verdict of res. Verdicts other than
+// LimitsExceeded are counted when they are reached.
+func (s *Scanner) count(res *ScanResult) {
+	if res.Verdict != LimitsExceeded {
+		return
+	}
+	s.stats.limitsExceeded.Add(1)
+	if s.opts.LimitsExceededInfected {
+		s.stats.infectedFiles.Add(1)
+	}
+}
+
+// A fileHasher computes a hash of scanned files, of the type typ.
+type fileHasher struct {
+	typ string
+	h   hash.Hash
+}
+
+// scan hashes the contents of r and looks them up. If res.Size is -1, the
+// size is unknown and set once r has been read.
+func (s *Scanner) scan(ctx context.Context, r io.Reader, res *ScanResult) {
+	database := s.opts.DB
+
+	if res.Size == 0 {
+		res.Verdict = Clean
+		return
+	}
+
+	// Fuzzy signatures match files of any size, so the size check
+	// can only skip files if none are loaded
+	fuzzySigs := database.HasFuzzySigs()
+	checkSize := func() (bool, bool) {
+		if s.opts.SkipSize {
+			return true, true
+		}
+		sizeExists, err := database.HasSigWithSize(int(res.Size))
+		if err != nil {
+			res.fail("Error checking if size exists", err)
+			return false, false
+		}
+		return sizeExists, true
+	}
+
+	sizeExists := true
+	if res.Size > 0 {
+		var ok bool
+		if sizeExists, ok = checkSize(); !ok {
+			return
+		}
+		if !sizeExists && !fuzzySigs {
+			res.Verdict = Clean
+			return
+		}
+	}
+
+	// Hash file. MD5 is always computed, as the known-good indexes hold MD5
+	// hashes, SHA-1 and SHA-256 only if signatures of their type are loaded
+	hashers := []fileHasher{{"md5", md5.New()}}
+	if database.HasHashSigs("sha1") {
+		hashers = append(hashers, fileHasher{"sha1", sha1.New()})
+	}
+	if database.HasHashSigs("sha256") {
+		hashers = append(hashers, fileHasher{"sha256", sha256.New()})
+	}
+	writers := make([]io.Writer, 0, len(hashers)+1)
+	for _, h := range hashers {
+		writers = append(writers, h.h)
+	}
+	var fuzzyHasher *fuzzy.Hasher
+	if fuzzySigs {
+		fuzzyHasher = fuzzy.New()
+		writers = append(writers, fuzzyHasher)
+	}
+	written, err := io.Copy(io.MultiWriter(writers...), &contextReader{ctx, r})
+	s.stats.dataRead.Add(uint64(written))
+	if err != nil {
+		res.fail("Error hashing file", err)
+		return
+	}
+
+	if res.Size < 0 {
+		res.Size = written
+		if res.Size == 0 {
+			res.Verdict = Clean
+			return
+		}
+		var ok bool
+		if sizeExists, ok = checkSize(); !ok {
+			return
+		}
+	}
+
+	res.Hashes = make(map[string]string, len(hashers)+2)
+	for _, h := range hashers {
+		res.Hashes[h.typ] = hex.EncodeToString(h.h.Sum(nil))
+	}
+	if fuzzyHasher != nil {
+		res.Hashes[db.HashTypeSSDeep] = fuzzyHasher.SSDeep()
+		if tlsh := fuzzyHasher.TLSH(); tlsh != "" {
+			res.Hashes[db.HashTypeTLSH] = tlsh
+		}
+	}
+
+	if sizeExists {
+		for _, h := range hashers {
+			sum := res.Hashes[h.typ]
+			hashExists, err := database.HasSigWithHash(sum)
+			if err != nil {
+				res.fail("Error checking if hash exists", err)
+				return
+			}
+			if hashExists {
+				// The signatures may have been reloaded in the meantime
+				if item, err := database.GetItemByHash(sum); err == nil {
+					res.Matches = append(res.Matches, *item)
+				}
+				res.Verdict = Infected
+				s.stats.infectedFiles.Add(1)
+				return
+			}
+		}
+	}
+
+	knownGood, err := database.IsKnownGood(res.Hashes["md5"])
+	if err != nil {
+		res.fail("Error checking if hash is known-good", err)
+		return
+	}
+	if knownGood {
+		res.Verdict = KnownClean
+		s.stats.knownClean.Add(1)
+		return
+	}
+
+	if fuzzyHasher != nil {
+		if match := s.matchFuzzy(fuzzyHasher); match != nil {
+			res.Verdict = Similar
+			res.Matches = append(res.Matches, *match.Item)
+			res.Score = match.Score
+			s.stats.similarFiles.Add(1)
+			return
+		}
+	}
+
+	res.Verdict = Clean
+}
+
+// matchFuzzy returns the fuzzy signature most similar to the file hashed by
+// h, preferring ssdeep matches over TLSH ones, or nil if there is none.
+func (s *Scanner) matchFuzzy(h *fuzzy.Hasher) *db.FuzzyMatch {
+	match, err := s.opts.DB.MatchSSDeep(h.SSDeep(), s.opts.SSDeepThreshold)
+	if err != nil || match != nil {
+		return match
+	}
+	if hash := h.TLSH(); hash != "" {
+		match, _ = s.opts.DB.MatchTLSH(hash, s.opts.TLSHThreshold)
+	}
+	return match
+}
+
+// contextReader stops reading once its context is done, so that large files
+// don't delay cancellation.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// stats are the counters of a Scanner. They are updated by concurrent scans.
+type stats struct {
+	scannedFiles   atomic.Int64
+	scannedFolders atomic.Int64
+	infectedFiles  atomic.Int64
+	knownClean     atomic.Int64
+	similarFiles   atomic.Int64
+	archives       atomic.Int64
+	archiveMembers atomic.Int64
+	limitsExceeded atomic.Int64
+	dataScanned    atomic.Uint64
+	dataRead       atomic.Uint64
+}
+
+// Stats returns the counters of all scans done by s so far.
+func (s *Scanner) Stats() Stats {
+	return Stats{
+		ScannedFiles:   s.stats.scannedFiles.Load(),
+		ScannedFolders: s.stats.scannedFolders.Load(),
+		InfectedFiles:  s.stats.infectedFiles.Load(),
+		KnownClean:     s.stats.knownClean.Load(),
+		SimilarFiles:   s.stats.similarFiles.Load(),
+		Archives:       s.stats.archives.Load(),
+		ArchiveMembers: s.stats.archiveMembers.Load(),
+		LimitsExceeded: s.stats.limitsExceeded.Load(),
+		DataScanned:    s.stats.dataScanned.Load(),
+		DataRead:       s.stats.dataRead.Load(),
+	}
+}
@@ -0,0 +1,266 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/hexahigh/goava/lib/db"
+)
+
+// newTestScanner returns a scanner with the signature of testdata/db, which
+// matches the files generated as malware by testdata/gen.go, or with the
+// signatures of the database folder opts.DB.Path if opts.DB is set.
+func newTestScanner(t *testing.T, opts Options) *Scanner {
+	t.Helper()
+	if opts.DB == nil {
+		opts.DB = &db.DB{Path: "testdata/db"}
+	}
+	if err := opts.DB.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.DB.LoadSigs(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// verdicts returns the paths within res of its members, recursively, with
+// their verdicts, in the order they were scanned, e.g. "dir/evil infected".
+func verdicts(res ScanResult) []string {
+	var found []string
+	res.Walk(func(member *ScanResult) {
+		if name, ok := strings.CutPrefix(member.Path, res.Path+"!"); ok {
+			found = append(found, name+" "+member.Verdict.String())
+		}
+	})
+	return found
+}
+
+func TestScanTar(t *testing.T) {
+	// The members of the streams, see genStreams
+	members := []string{
+		"evil infected",
+		"dir/clean.txt clean",
+		"dir/evil.gz clean",
+		"dir/nested.tar clean",
+		"last.txt clean",
+	}
+	tests := []struct {
+		name     string
+		file     string
+		archives bool
+		verdict  Verdict
+		want     []string
+		// Whether the last member is an error reading the stream itself
+		readErr bool
+	}{
+		{name: "tar", file: "testdata/stream.tar", verdict: Clean, want: members},
+		{name: "gzip", file: "testdata/stream.tar.gz", verdict: Clean, want: members},
+		{
+			name:     "nested archives",
+			file:     "testdata/stream.tar",
+			archives: true,
+			verdict:  Clean,
+			want: []string{
+				"evil infected",
+				"dir/clean.txt clean",
+				"dir/evil.gz clean",
+				"dir/evil.gz!evil infected",
+				"dir/nested.tar clean",
+				"dir/nested.tar!evil infected",
+				"last.txt clean",
+			},
+		},
+		{name: "truncated", file: "testdata/stream-truncated.tar", verdict: Clean, want: []string{"evil error"}, readErr: true},
+		{name: "gzip without tar", file: "testdata/stream-notar.gz", verdict: Clean, readErr: true},
+		{name: "not an archive", file: "testdata/db/test.hdb", verdict: Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			s := newTestScanner(t, Options{ScanArchives: tt.archives})
+			res := s.ScanTar(context.Background(), f, "stdin")
+			if res.Verdict != tt.verdict {
+				t.Fatalf("got verdict %v (%v), want %v", res.Verdict, res.Err, tt.verdict)
+			}
+			if got := verdicts(res); !slices.Equal(got, tt.want) {
+				t.Errorf("got members %q, want %q", got, tt.want)
+			}
+			readErr := len(res.Members) > 0 && res.Members[len(res.Members)-1].Path == res.Path
+			if readErr != tt.readErr {
+				t.Fatalf("got read error %v, want %v", readErr, tt.readErr)
+			}
+			if readErr {
+				if last := res.Members[len(res.Members)-1]; last.Verdict != Error || last.Err == nil {
+					t.Errorf("got read error verdict %v (%v), want %v", last.Verdict, last.Err, Error)
+				}
+			}
+		})
+	}
+}
+
+// Files are hashed with the hash types signatures are loaded for.
+func TestScanHashTypes(t *testing.T) {
+	malware := []byte("goava test malware, not harmful\n")
+	md5Sum, sha1Sum, sha256Sum := md5.Sum(malware), sha1.Sum(malware), sha256.Sum256(malware)
+	tests := []struct {
+		name   string
+		file   string
+		hash   []byte
+		hashes []string
+	}{
+		{"md5", "test.hdb", md5Sum[:], []string{"md5"}},
+		{"sha1", "test.hsb", sha1Sum[:], []string{"md5", "sha1"}},
+		{"sha256", "test.hsb", sha256Sum[:], []string{"md5", "sha256"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sig := fmt.Sprintf("%x:%d:Goava.Test\n", tt.hash, len(malware))
+			if err := os.WriteFile(filepath.Join(dir, tt.file), []byte(sig), 0644); err != nil {
+				t.Fatal(err)
+			}
+			sample := filepath.Join(t.TempDir(), "sample")
+			if err := os.WriteFile(sample, malware, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			s := newTestScanner(t, Options{DB: &db.DB{Path: dir}})
+			res := s.ScanFile(context.Background(), sample)
+			if res.Verdict != Infected {
+				t.Fatalf("got verdict %v (%v), want %v", res.Verdict, res.Err, Infected)
+			}
+			var hashes []string
+			for typ := range res.Hashes {
+				hashes = append(hashes, typ)
+			}
+			slices.Sort(hashes)
+			if !slices.Equal(hashes, tt.hashes) {
+				t.Errorf("got hashes %q, want %q", hashes, tt.hashes)
+			}
+			if got, want := res.Hashes[tt.name], fmt.Sprintf("%x", tt.hash); got != want {
+				t.Errorf("got %s %s, want %s", tt.name, got, want)
+			}
+		})
+	}
+}
+
+// Only a negative fuzzy threshold is replaced by the default.
+func TestScanFuzzyThreshold(t *testing.T) {
+	// The ssdeep hash of the sample with "Ctph" replaced by "CTPH", whose
+	// score is 22
+	const sig = "ssdeep,3:AXGBicFlIHBGcL6wCrFQEv:AXGH6xLsr2C,Goava.Fuzzy\n"
+	tests := []struct {
+		threshold int
+		want      Verdict
+	}{
+		{-1, Clean},
+		{0, Similar},
+		{22, Similar},
+		{23, Clean},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.threshold), func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "test.fuzzy"), []byte(sig), 0644); err != nil {
+				t.Fatal(err)
+			}
+			sample := filepath.Join(t.TempDir(), "sample")
+			if err := os.WriteFile(sample, []byte("Also called fuzzy hashes, Ctph can match inputs that have homologies."), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			s := newTestScanner(t, Options{DB: &db.DB{Path: dir}, SSDeepThreshold: tt.threshold, TLSHThreshold: -1})
+			res := s.ScanFile(context.Background(), sample)
+			if res.Verdict != tt.want {
+				t.Fatalf("got verdict %v (%v), want %v", res.Verdict, res.Err, tt.want)
+			}
+			if tt.want == Similar && res.Score != 22 {
+				t.Errorf("got score %d, want 22", res.Score)
+			}
+		})
+	}
+}
+
+// ScanReader scans streams of unknown size, spooling archives.
+func TestScanReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		r        func() io.Reader
+		archives bool
+		canceled bool
+		verdict  Verdict
+		size     int64
+		members  []string
+	}{
+		{"malware", func() io.Reader { return strings.NewReader(malware) }, false, false, Infected, int64(len(malware)), nil},
+		{"clean", func() io.Reader { return strings.NewReader("clean\n") }, false, false, Clean, 6, nil},
+		{"empty", func() io.Reader { return strings.NewReader("") }, false, false, Clean, 0, nil},
+		{"read error", func() io.Reader {
+			return io.MultiReader(strings.NewReader(malware), iotest.ErrReader(errors.New("read failed")))
+		}, false, false, Error, -1, nil},
+		{"canceled", func() io.Reader { return strings.NewReader(malware) }, false, true, Error, -1, nil},
+		{
+			"archive", func() io.Reader {
+				return bytes.NewReader(zipOf(t, zipMember{"clean.txt", []byte("clean\n")}, zipMember{"dir/evil", []byte(malware)}))
+			},
+			true, false, Clean, -1, []string{"clean.txt clean", "dir/evil infected"},
+		},
+		{
+			"archive not scanned", func() io.Reader {
+				return bytes.NewReader(zipOf(t, zipMember{"dir/evil", []byte(malware)}))
+			},
+			false, false, Clean, -1, nil,
+		},
+		{
+			"nested gzip", func() io.Reader {
+				return bytes.NewReader(gzipOf(t, zipOf(t, zipMember{"evil", []byte(malware)})))
+			},
+			true, false, Clean, -1, []string{"stdin clean", "stdin!evil infected"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.canceled {
+				cancel()
+			}
+			s := newTestScanner(t, Options{ScanArchives: tt.archives, SkipSize: true})
+			res := s.ScanReader(ctx, tt.r(), "stdin")
+			if res.Verdict != tt.verdict {
+				t.Fatalf("got verdict %v (%v), want %v", res.Verdict, res.Err, tt.verdict)
+			}
+			if res.Path != "stdin" {
+				t.Errorf("got path %q, want stdin", res.Path)
+			}
+			if tt.size >= 0 && res.Size != tt.size {
+				t.Errorf("got size %d, want %d", res.Size, tt.size)
+			}
+			if got := verdicts(res); !slices.Equal(got, tt.members) {
+				t.Errorf("got members %q, want %q", got, tt.members)
+			}
+		})
+	}
+}
@@ -0,0 +1,830 @@
+//go:build ignore
+
+// This program generates the test fixtures of the scanner package. Run it
+// from the testdata folder with go run gen.go. The output is deterministic.
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// malware is the content the signature in db/test.hdb matches.
+var malware = []byte("goava test malware, not harmful\n")
+
+func main() {
+	sum := md5.Sum(malware)
+	write("db/test.hdb", fmt.Appendf(nil, "%x:%d:Goava.Test\n", sum, len(malware)))
+
+	genImages()
+	genDisks()
+	genStreams()
+}
+
+func write(name string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// A tarEntry is a file of a tar archive. Entries without content are
+// directories if their name ends with a slash.
+type tarEntry struct {
+	name    string
+	content []byte
+}
+
+func makeTar(entries ...tarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), ModTime: time.Unix(0, 0), Typeflag: tar.TypeReg}
+		if e.name[len(e.name)-1] == '/' {
+			hdr.Mode, hdr.Typeflag = 0755, tar.TypeDir
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			log.Fatal(err)
+		}
+		tw.Write(e.content)
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func gzipped(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func mustJSON(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return data
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// genImages writes container images of two layers, the second deleting,
+// hiding or replacing most of the infected files of the first:
+//
+//	/bin/evil         infected, deleted by a whiteout
+//	/etc/keep         clean
+//	/opt/app/evil     infected, hidden by an opaque whiteout of /opt/app
+//	/opt/app/new      clean, added next to the opaque whiteout
+//	/tmp/evil         infected, replaced by a clean file
+//	/srv/evil         infected, left in place
+func genImages() {
+	lower := makeTar(
+		tarEntry{name: "bin/"},
+		tarEntry{name: "bin/evil", content: malware},
+		tarEntry{name: "etc/"},
+		tarEntry{name: "etc/keep", content: []byte("keep\n")},
+		tarEntry{name: "opt/app/"},
+		tarEntry{name: "opt/app/evil", content: malware},
+		tarEntry{name: "tmp/"},
+		tarEntry{name: "tmp/evil", content: malware},
+		tarEntry{name: "srv/"},
+		tarEntry{name: "srv/evil", content: malware},
+	)
+	upper := makeTar(
+		tarEntry{name: "bin/.wh.evil"},
+		tarEntry{name: "opt/app/"},
+		tarEntry{name: "opt/app/.wh..wh..opq"},
+		tarEntry{name: "opt/app/new", content: []byte("new\n")},
+		tarEntry{name: "tmp/evil", content: []byte("replaced\n")},
+	)
+	// Layers may be compressed or not
+	upperBlob := gzipped(upper)
+
+	// docker save
+	config := mustJSON(map[string]any{
+		"rootfs": map[string]any{"type": "layers", "diff_ids": []string{digest(lower), digest(upper)}},
+	})
+	manifest := mustJSON([]map[string]any{{
+		"Config":   "config.json",
+		"RepoTags": []string{"test:latest"},
+		"Layers":   []string{"lower/layer.tar", "upper/layer.tar"},
+	}})
+	write("docker.tar", makeTar(
+		tarEntry{name: "manifest.json", content: manifest},
+		tarEntry{name: "config.json", content: config},
+		tarEntry{name: "lower/layer.tar", content: lower},
+		tarEntry{name: "upper/layer.tar", content: upperBlob},
+	))
+
+	// OCI image layout, with the image in a nested index
+	blobs := map[string][]byte{}
+	blob := func(data []byte) string {
+		d := digest(data)
+		blobs[d] = data
+		return d
+	}
+	ociManifest := mustJSON(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config":        map[string]any{"mediaType": "application/vnd.oci.image.config.v1+json", "digest": blob(config), "size": len(config)},
+		"layers": []map[string]any{
+			{"mediaType": "application/vnd.oci.image.layer.v1.tar", "digest": blob(lower), "size": len(lower)},
+			{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": blob(upperBlob), "size": len(upperBlob)},
+		},
+	})
+	nested := mustJSON(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.index.v1+json",
+		"manifests": []map[string]any{{
+			"mediaType": "application/vnd.oci.image.manifest.v1+json",
+			"digest":    blob(ociManifest),
+			"size":      len(ociManifest),
+			"platform":  map[string]any{"os": "linux", "architecture": "amd64"},
+		}},
+	})
+	index := mustJSON(map[string]any{
+		"schemaVersion": 2,
+		"manifests": []map[string]any{{
+			"mediaType":   "application/vnd.oci.image.index.v1+json",
+			"digest":      blob(nested),
+			"size":        len(nested),
+			"annotations": map[string]string{"io.containerd.image.name": "test:latest"},
+		}},
+	})
+	layout := ociLayout(index, blobs)
+	write("oci.tar", layout)
+	// Cut in the middle of the lower layer
+	write("oci-truncated.tar", layout[:bytes.Index(layout, []byte("etc/keep"))+100])
+
+	// A nested index referencing itself, which can't be content addressed
+	// but is just as easy to write
+	loop := mustJSON(map[string]any{
+		"schemaVersion": 2,
+		"manifests": []map[string]any{{
+			"mediaType": "application/vnd.oci.image.index.v1+json",
+			"digest":    "sha256:loop",
+		}},
+	})
+	write("oci-loop.tar", ociLayout(loop, map[string][]byte{"sha256:loop": loop}))
+
+	// More nested indexes than the default depth limit
+	deep := index
+	deepBlobs := maps.Clone(blobs)
+	for range 20 {
+		deepBlobs[digest(deep)] = deep
+		deep = mustJSON(map[string]any{
+			"schemaVersion": 2,
+			"manifests": []map[string]any{{
+				"mediaType": "application/vnd.oci.image.index.v1+json",
+				"digest":    digest(deep),
+			}},
+		})
+	}
+	write("oci-deep.tar", ociLayout(deep, deepBlobs))
+
+	write("oci-badindex.tar", ociLayout([]byte(`{"manifests": [`), nil))
+}
+
+// ociLayout returns a tar archive of an OCI image layout with the given
+// index and blobs.
+func ociLayout(index []byte, blobs map[string][]byte) []byte {
+	entries := []tarEntry{
+		{name: "oci-layout", content: []byte(`{"imageLayoutVersion":"1.0.0"}`)},
+		{name: "index.json", content: index},
+	}
+	var digests []string
+	for d := range blobs {
+		digests = append(digests, d)
+	}
+	slices.Sort(digests)
+	for _, d := range digests {
+		entries = append(entries, tarEntry{name: "blobs/sha256/" + d[len("sha256:"):], content: blobs[d]})
+	}
+	return makeTar(entries...)
+}
+
+// genStreams writes tar archives scanned as streams, with a nested gzip
+// file and a nested archive.
+func genStreams() {
+	stream := makeTar(
+		tarEntry{name: "evil", content: malware},
+		tarEntry{name: "dir/"},
+		tarEntry{name: "dir/clean.txt", content: []byte("clean\n")},
+		tarEntry{name: "dir/evil.gz", content: gzipped(malware)},
+		tarEntry{name: "dir/nested.tar", content: makeTar(tarEntry{name: "evil", content: malware})},
+		tarEntry{name: "last.txt", content: []byte("last\n")},
+	)
+	write("stream.tar", stream)
+	write("stream.tar.gz", gzipped(stream))
+	// Cut in the middle of evil, which is read as its size matches the
+	// signature
+	write("stream-truncated.tar", stream[:bytes.Index(stream, malware)+10])
+	write("stream-notar.gz", gzipped([]byte("not a tar archive\n")))
+}
+
+// A diskFile is a file of the FAT and ISO 9660 test images, with its long
+// path and its 8.3 one.
+type diskFile struct {
+	path    string
+	short   string
+	content []byte
+}
+
+// pattern returns n bytes that differ from one cluster or sector to the next.
+func pattern(n int, seed byte) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = seed + byte(i%251)
+	}
+	return data
+}
+
+var diskFiles = []diskFile{
+	{"README.TXT", "README.TXT", []byte("readme\n")},
+	{"notes.txt", "NOTES.TXT", []byte("lowercase short name\n")},
+	{"A long file name with spaces.exe", "ALONGF~1.EXE", malware},
+	{"dir1/sub/deep.bin", "DIR1/SUB/DEEP.BIN", pattern(3000, 1)},
+	{"dir1/Mixed Case Ünïcode.txt", "DIR1/MIXEDC~1.TXT", []byte("unicode\n")},
+	{"dir1/empty", "DIR1/EMPTY", nil},
+	// Allocated interleaved with the next file on FAT volumes, and as two
+	// extents on ISO 9660 images
+	{"frag.dat", "FRAG.DAT", pattern(9000, 2)},
+	{"after.dat", "AFTER.DAT", pattern(2000, 3)},
+}
+
+// A diskNode is a directory or a file of a diskFile tree.
+type diskNode struct {
+	name, short string
+	content     []byte
+	dir         bool
+	children    []*diskNode
+}
+
+func diskTree(files []diskFile) *diskNode {
+	root := &diskNode{dir: true}
+	for _, f := range files {
+		names, shorts := strings.Split(f.path, "/"), strings.Split(f.short, "/")
+		dir := root
+		for i, name := range names[:len(names)-1] {
+			var child *diskNode
+			for _, c := range dir.children {
+				if c.name == name {
+					child = c
+				}
+			}
+			if child == nil {
+				child = &diskNode{name: name, short: shorts[i], dir: true}
+				dir.children = append(dir.children, child)
+			}
+			dir = child
+		}
+		dir.children = append(dir.children, &diskNode{name: names[len(names)-1], short: shorts[len(shorts)-1], content: f.content})
+	}
+	return root
+}
+
+// genDisks writes FAT and ISO 9660 images of diskFiles, gzipped as they are
+// mostly empty, and malformed ones.
+func genDisks() {
+	fat12 := fatImage(12, 720, nil)
+	write("fat12.img.gz", gzipped(fat12))
+	write("fat16.img.gz", gzipped(fatImage(16, 8400, nil)))
+	fat32 := fatImage(32, 67000, nil)
+	write("fat32.img.gz", gzipped(fat32))
+
+	// A disk with a FAT12 partition, a Linux one and a FAT16 one
+	write("mbr.img.gz", gzipped(mbrImage(
+		mbrPartition{0x01, fat12},
+		mbrPartition{0x83, make([]byte, 64*512)},
+		mbrPartition{0x06, fatImage(16, 8400, nil)},
+	)))
+
+	// The chain of frag.dat leads to a cluster past the end of the volume
+	write("fat-badchain.img.gz", gzipped(fatImage(12, 720, func(name string, chain []uint32, fat []uint32) {
+		if name == "frag.dat" {
+			fat[chain[1]] = 0xff0
+		}
+	})))
+	// Cut in the reserved sectors, before the file allocation table
+	write("fat-truncated.img.gz", gzipped(fat32[:8192]))
+
+	write("plain.iso.gz", gzipped(isoImage(false, false)))
+	write("joliet.iso.gz", gzipped(isoImage(true, false)))
+	rockRidge := isoImage(true, true)
+	write("rockridge.iso.gz", gzipped(rockRidge))
+	// Cut after the primary volume descriptor
+	write("iso-truncated.iso.gz", gzipped(rockRidge[:17*2048]))
+}
+
+// fatImage returns a FAT volume of the given type and size in sectors
+// holding diskFiles, with one sector per cluster. Directories have a deleted
+// entry, and the root a volume label. If patch isn't nil, it is called with
+// the cluster chain of every file before the tables are written.
+func fatImage(bits, sectors int, patch func(name string, chain []uint32, fat []uint32)) []byte {
+	const bps = 512
+	reserved, rootEntries := 1, 224
+	if bits == 32 {
+		reserved, rootEntries = 32, 0
+	}
+	rootSectors := rootEntries * 32 / bps
+	// The tables hold an entry per data cluster plus the first two
+	fatSize := 1
+	for {
+		clusters := sectors - reserved - 2*fatSize - rootSectors
+		need := ((clusters+2)*bits/8 + bps) / bps
+		if need <= fatSize {
+			break
+		}
+		fatSize = need
+	}
+	clusters := sectors - reserved - 2*fatSize - rootSectors
+	switch {
+	case bits == 12 && clusters >= 4085,
+		bits == 16 && (clusters < 4085 || clusters >= 65525),
+		bits == 32 && clusters < 65525:
+		log.Fatalf("%d clusters for FAT%d", clusters, bits)
+	}
+
+	img := make([]byte, sectors*bps)
+	boot := img[:bps]
+	copy(boot, "\xeb\x3c\x90MSWIN4.1")
+	binary.LittleEndian.PutUint16(boot[11:], bps)
+	boot[13] = 1
+	binary.LittleEndian.PutUint16(boot[14:], uint16(reserved))
+	boot[16] = 2
+	binary.LittleEndian.PutUint16(boot[17:], uint16(rootEntries))
+	if sectors < 65536 {
+		binary.LittleEndian.PutUint16(boot[19:], uint16(sectors))
+	} else {
+		binary.LittleEndian.PutUint32(boot[32:], uint32(sectors))
+	}
+	boot[21] = 0xf8
+	if bits == 32 {
+		binary.LittleEndian.PutUint32(boot[36:], uint32(fatSize))
+		binary.LittleEndian.PutUint32(boot[44:], 2)
+		copy(boot[82:], "FAT32   ")
+	} else {
+		binary.LittleEndian.PutUint16(boot[22:], uint16(fatSize))
+		copy(boot[54:], fmt.Sprintf("FAT%d   ", bits))
+	}
+	boot[510], boot[511] = 0x55, 0xaa
+
+	eoc := uint32(1)<<bits - 1
+	fat := make([]uint32, clusters+2)
+	fat[0], fat[1] = eoc&^0xff|0xf8, eoc
+	dataOffset := (reserved + 2*fatSize + rootSectors) * bps
+	free := uint32(2)
+	alloc := func(n int) []uint32 {
+		chain := make([]uint32, n)
+		for i := range chain {
+			chain[i] = free
+			free++
+		}
+		return chain
+	}
+	writeChain := func(chain []uint32, data []byte) {
+		for i, c := range chain {
+			copy(img[dataOffset+int(c-2)*bps:], data[min(i*bps, len(data)):min((i+1)*bps, len(data))])
+			if i+1 < len(chain) {
+				fat[c] = chain[i+1]
+			} else {
+				fat[c] = eoc
+			}
+		}
+	}
+
+	var patches []func()
+	var build func(dir *diskNode, self, parent uint32) []byte
+	build = func(dir *diskNode, self, parent uint32) []byte {
+		var entries []byte
+		if dir.name == "" {
+			entries = append(entries, fatEntry("TESTVOL", "", 0x08, 0, 0)...)
+		} else {
+			entries = append(entries, fatEntry(".", "", 0x10, self, 0)...)
+			entries = append(entries, fatEntry("..", "", 0x10, parent, 0)...)
+		}
+		deleted := fatEntry("DELETED.TXT", "", 0x20, 0, 0)
+		deleted[0] = 0xe5
+		entries = append(entries, deleted...)
+
+		// frag.dat and after.dat take every other cluster
+		var fragChain []uint32
+		for _, n := range dir.children {
+			if n.dir {
+				chain := alloc(1)
+				writeChain(chain, build(n, chain[0], self))
+				entries = append(entries, fatEntry(n.short, n.name, 0x10, chain[0], 0)...)
+				continue
+			}
+			var chain []uint32
+			count := (len(n.content) + bps - 1) / bps
+			switch n.name {
+			case "frag.dat":
+				fragChain = alloc(2 * count)
+				chain = make([]uint32, count)
+				for i := range chain {
+					chain[i] = fragChain[2*i]
+				}
+			case "after.dat":
+				for i := range count {
+					if 2*i+1 < len(fragChain) {
+						chain = append(chain, fragChain[2*i+1])
+					} else {
+						chain = append(chain, alloc(1)...)
+					}
+				}
+			default:
+				chain = alloc(count)
+			}
+			writeChain(chain, n.content)
+			first := uint32(0)
+			if len(chain) > 0 {
+				first = chain[0]
+			}
+			if patch != nil {
+				patches = append(patches, func() { patch(n.name, chain, fat) })
+			}
+			entries = append(entries, fatEntry(n.short, n.name, 0x20, first, len(n.content))...)
+		}
+		return entries
+	}
+	root := diskTree(diskFiles)
+	if bits == 32 {
+		chain := alloc(1)
+		writeChain(chain, build(root, 0, 0))
+	} else {
+		copy(img[(reserved+2*fatSize)*bps:], build(root, 0, 0))
+	}
+	for _, p := range patches {
+		p()
+	}
+
+	table := make([]byte, fatSize*bps)
+	for c, v := range fat {
+		switch bits {
+		case 12:
+			i := c * 3 / 2
+			if c%2 == 0 {
+				table[i] = byte(v)
+				table[i+1] = table[i+1]&0xf0 | byte(v>>8)&0x0f
+			} else {
+				table[i] = table[i]&0x0f | byte(v<<4)
+				table[i+1] = byte(v >> 4)
+			}
+		case 16:
+			binary.LittleEndian.PutUint16(table[2*c:], uint16(v))
+		default:
+			binary.LittleEndian.PutUint32(table[4*c:], v)
+		}
+	}
+	for i := range 2 {
+		copy(img[(reserved+i*fatSize)*bps:], table)
+	}
+	return img
+}
+
+// fatEntry returns the directory entries of a file with the 8.3 name short,
+// preceded by long name entries if its name differs from short other than
+// by case.
+func fatEntry(short, name string, attr byte, cluster uint32, size int) []byte {
+	d := make([]byte, 32)
+	copy(d[:11], "           ")
+	if short == "." || short == ".." {
+		copy(d, short)
+	} else if attr&0x08 != 0 {
+		copy(d, short)
+	} else {
+		base, ext, _ := strings.Cut(short, ".")
+		copy(d[:8], base)
+		copy(d[8:11], ext)
+	}
+	d[11] = attr
+	binary.LittleEndian.PutUint16(d[20:], uint16(cluster>>16))
+	binary.LittleEndian.PutUint16(d[26:], uint16(cluster))
+	binary.LittleEndian.PutUint32(d[28:], uint32(size))
+	if name == "" || name == short {
+		return d
+	}
+	if strings.ToLower(short) == name {
+		d[12] = 0x18
+		return d
+	}
+
+	sum := byte(0)
+	for _, c := range d[:11] {
+		sum = (sum&1)<<7 + sum>>1 + c
+	}
+	u := utf16.Encode([]rune(name))
+	u = append(u, 0)
+	for len(u)%13 != 0 {
+		u = append(u, 0xffff)
+	}
+	var entries []byte
+	for ord := len(u) / 13; ord >= 1; ord-- {
+		l := make([]byte, 32)
+		l[0] = byte(ord)
+		if ord == len(u)/13 {
+			l[0] |= 0x40
+		}
+		l[11], l[13] = 0x0f, sum
+		for i, off := range [13]int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+			binary.LittleEndian.PutUint16(l[off:], u[13*(ord-1)+i])
+		}
+		entries = append(entries, l...)
+	}
+	return append(entries, d...)
+}
+
+// An mbrPartition is a partition of an MBR disk image.
+type mbrPartition struct {
+	typ  byte
+	data []byte
+}
+
+// mbrImage returns a disk image with the given partitions one after the
+// other, starting at sector 63.
+func mbrImage(parts ...mbrPartition) []byte {
+	img := make([]byte, 63*512)
+	for i, p := range parts {
+		e := img[446+16*i:]
+		e[4] = p.typ
+		binary.LittleEndian.PutUint32(e[8:], uint32(len(img)/512))
+		binary.LittleEndian.PutUint32(e[12:], uint32(len(p.data)/512))
+		img = append(img, p.data...)
+	}
+	img[510], img[511] = 0x55, 0xaa
+	return img
+}
+
+// isoImage returns an ISO 9660 image holding diskFiles, with frag.dat in two
+// extents, a Joliet tree if joliet is set and Rock Ridge names if rockRidge
+// is. Rock Ridge images have a symbolic link, and the name of one file in a
+// continuation area, split as mkisofs does when System Use areas are full.
+func isoImage(joliet, rockRidge bool) []byte {
+	const sector = 2048
+	img := make([]byte, 19*sector)
+	alloc := func(data []byte) uint32 {
+		lba := uint32(len(img) / sector)
+		img = append(img, data...)
+		img = append(img, make([]byte, (sector-len(data)%sector)%sector)...)
+		return lba
+	}
+
+	// Directories come first, a sector each, then the continuation area
+	// and the contents of the files, as with mkisofs
+	root := diskTree(diskFiles)
+	trees := []map[*diskNode]uint32{{}}
+	if joliet {
+		trees = append(trees, map[*diskNode]uint32{})
+	}
+	var reserve func(dir *diskNode, lbas map[*diskNode]uint32)
+	reserve = func(dir *diskNode, lbas map[*diskNode]uint32) {
+		lbas[dir] = alloc(make([]byte, sector))
+		for _, n := range dir.children {
+			if n.dir {
+				reserve(n, lbas)
+			}
+		}
+	}
+	for _, lbas := range trees {
+		reserve(root, lbas)
+	}
+	const continued, head, tail = "A long file name with spaces.exe", "A long file", " name with spaces.exe"
+	continuation := uint32(0)
+	if rockRidge {
+		continuation = alloc(nm(tail))
+	}
+	extents := map[*diskNode][][2]uint32{}
+	var allocFiles func(dir *diskNode)
+	allocFiles = func(dir *diskNode) {
+		for _, n := range dir.children {
+			switch {
+			case n.dir:
+				allocFiles(n)
+			case n.name == "frag.dat":
+				// Extents but the last are a whole number of blocks, and
+				// need not be contiguous
+				first := alloc(n.content[:2*sector])
+				alloc(make([]byte, sector))
+				second := alloc(n.content[2*sector:])
+				extents[n] = [][2]uint32{{first, 2 * sector}, {second, uint32(len(n.content) - 2*sector)}}
+			default:
+				extents[n] = [][2]uint32{{alloc(n.content), uint32(len(n.content))}}
+			}
+		}
+	}
+	allocFiles(root)
+
+	// Rock Ridge names are only in the primary tree, with the root
+	// announcing SUSP with SP and Rock Ridge with PX, as mkisofs does
+	var writeDir func(dir, parent *diskNode, lbas map[*diskNode]uint32, long bool)
+	writeDir = func(dir, parent *diskNode, lbas map[*diskNode]uint32, long bool) {
+		rr := rockRidge && !long
+		var self []byte
+		if rr && dir == root {
+			self = []byte("SP\x07\x01\xbe\xef\x00")
+			px := make([]byte, 36)
+			copy(px, "PX\x24\x01")
+			putBoth32(px[4:], 0o40555)
+			putBoth32(px[12:], 2)
+			self = append(self, px...)
+		}
+		data := isoRecord([]byte{0}, lbas[dir], sector, 0x02, self)
+		data = append(data, isoRecord([]byte{1}, lbas[parent], sector, 0x02, nil)...)
+		for _, n := range dir.children {
+			id := []byte(n.short)
+			if long {
+				id = ucs2(n.name)
+			}
+			var su []byte
+			if rr {
+				su = nm(n.name)
+				if n.name == continued {
+					su = nm(head)
+					su[4] = 0x01 // CONTINUE
+					su = append(su, ce(continuation, len(nm(tail)))...)
+				}
+			}
+			if n.dir {
+				writeDir(n, dir, lbas, long)
+				data = append(data, isoRecord(id, lbas[n], sector, 0x02, su)...)
+				continue
+			}
+			if long {
+				id = append(id, 0, ';', 0, '1')
+			} else {
+				id = append(id, ";1"...)
+			}
+			for i, e := range extents[n] {
+				flags := byte(0)
+				if i+1 < len(extents[n]) {
+					flags = 0x80
+				}
+				data = append(data, isoRecord(id, e[0], e[1], flags, su)...)
+			}
+		}
+		if rr && dir == root {
+			// A symbolic link to README.TXT
+			sl := append([]byte("SL\x00\x01\x00\x00\x0a"), "README.TXT"...)
+			sl[2] = byte(len(sl))
+			data = append(data, isoRecord([]byte("LINK;1"), 0, 0, 0, append(nm("link"), sl...))...)
+		}
+		if len(data) > sector {
+			log.Fatal("directory larger than a sector")
+		}
+		copy(img[lbas[dir]*sector:], data)
+	}
+	for i, lbas := range trees {
+		writeDir(root, root, lbas, i == 1)
+	}
+
+	// Path tables list the directories breadth first, with the number of
+	// their parent
+	type pathTables struct{ size, l, m uint32 }
+	tables := func(lbas map[*diskNode]uint32, long bool) pathTables {
+		type dir struct {
+			node   *diskNode
+			parent int
+		}
+		dirs := []dir{{root, 1}}
+		for i := 0; i < len(dirs); i++ {
+			for _, n := range dirs[i].node.children {
+				if n.dir {
+					dirs = append(dirs, dir{n, i + 1})
+				}
+			}
+		}
+		var l, m []byte
+		for _, d := range dirs {
+			id := []byte{0}
+			switch {
+			case d.node == root:
+			case long:
+				id = ucs2(d.node.name)
+			default:
+				id = []byte(d.node.short)
+			}
+			for _, order := range []binary.AppendByteOrder{binary.LittleEndian, binary.BigEndian} {
+				table := []byte{byte(len(id)), 0}
+				table = order.AppendUint32(table, lbas[d.node])
+				table = order.AppendUint16(table, uint16(d.parent))
+				table = append(table, id...)
+				if len(id)%2 == 1 {
+					table = append(table, 0)
+				}
+				if order == binary.LittleEndian {
+					l = append(l, table...)
+				} else {
+					m = append(m, table...)
+				}
+			}
+		}
+		return pathTables{uint32(len(l)), alloc(l), alloc(m)}
+	}
+
+	var descriptors [][]byte
+	var vdTables []pathTables
+	for i, lbas := range trees {
+		vdTables = append(vdTables, tables(lbas, i == 1))
+	}
+	size := uint32(len(img) / sector)
+	for i, lbas := range trees {
+		vd := make([]byte, sector)
+		vd[0] = byte(1 + i)
+		copy(vd[1:], "CD001\x01")
+		putBoth32(vd[80:], size)
+		if i == 1 {
+			copy(vd[88:], "%/E")
+		}
+		putBoth16(vd[120:], 1)
+		putBoth16(vd[124:], 1)
+		putBoth16(vd[128:], sector)
+		putBoth32(vd[132:], vdTables[i].size)
+		binary.LittleEndian.PutUint32(vd[140:], vdTables[i].l)
+		binary.BigEndian.PutUint32(vd[148:], vdTables[i].m)
+		copy(vd[156:], isoRecord([]byte{0}, lbas[root], sector, 0x02, nil))
+		vd[881] = 1
+		descriptors = append(descriptors, vd)
+	}
+	descriptors = append(descriptors, append([]byte{255}, "CD001\x01"...))
+	for i, vd := range descriptors {
+		copy(img[(16+i)*sector:], vd)
+	}
+	return img
+}
+
+// isoRecord returns a directory record.
+func isoRecord(id []byte, lba, size uint32, flags byte, su []byte) []byte {
+	rec := make([]byte, 33, 256)
+	putBoth32(rec[2:], lba)
+	putBoth32(rec[10:], size)
+	rec[25] = flags
+	putBoth16(rec[28:], 1)
+	rec[32] = byte(len(id))
+	rec = append(rec, id...)
+	if len(id)%2 == 0 {
+		rec = append(rec, 0)
+	}
+	rec = append(rec, su...)
+	if len(rec)%2 == 1 {
+		rec = append(rec, 0)
+	}
+	rec[0] = byte(len(rec))
+	return rec
+}
+
+// nm returns a Rock Ridge NM entry.
+func nm(name string) []byte {
+	return append([]byte{'N', 'M', byte(5 + len(name)), 1, 0}, name...)
+}
+
+// ce returns a SUSP CE entry pointing at n bytes at the start of the sector
+// lba.
+func ce(lba uint32, n int) []byte {
+	e := []byte{'C', 'E', 28, 1}
+	e = append(e, make([]byte, 24)...)
+	putBoth32(e[4:], lba)
+	putBoth32(e[20:], uint32(n))
+	return e
+}
+
+func ucs2(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.BigEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+// putBoth32 writes v both little and big endian, as ISO 9660 does.
+func putBoth32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+func putBoth16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
@@ -0,0 +1,179 @@
+package scanner
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ScanPath scans the file at path, or the files in the directory at path if
+// Recursive is enabled.
+//
+// The directory is walked while up to Jobs files are scanned concurrently.
+// fn is called with every result in the order of the walk, no matter in
+// which order the files finish, and never concurrently. Problems walking the
+// directory are reported as results with the Error verdict, and the walk
+// goes on.
+//
+// If ctx is canceled, the walk stops, the results not yet passed to fn are
+// dropped and the error of ctx is returned.
+func (s *Scanner) ScanPath(ctx context.Context, path string, fn func(ScanResult)) error {
+	p := newPool(ctx, s.opts.Jobs, fn)
+
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.IsDir() && !s.opts.Recursive:
+		res := ScanResult{Path: path, Size: -1}
+		p.submit(func() ScanResult { return res.skip("directory") })
+	case err == nil && info.IsDir():
+		s.walk(ctx, p, path)
+	default:
+		p.submit(func() ScanResult { return s.ScanFile(ctx, path) })
+	}
+
+	p.wait()
+	return ctx.Err()
+}
+
+// walk walks the directory at root and submits its files to p.
+func (s *Scanner) walk(ctx context.Context, p *pool, root string) {
+	filepath.Walk(root, func(path string, info fs.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return filepath.SkipAll
+		}
+		res := ScanResult{Path: path, Size: -1}
+		if err != nil {
+			p.submit(func() ScanResult { return res.fail("Error walking path", err) })
+			return nil
+		}
+
+		// Check if the path is a symlink
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !s.opts.FollowSymlinks {
+				p.submit(func() ScanResult { return res.skip("symlink") })
+				return nil
+			}
+			// If it's a symlink, resolve it
+			realPath, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				p.submit(func() ScanResult { return res.fail("Failed to resolve symlink", err) })
+				return nil
+			}
+
+			// Get the actual file info of the resolved path
+			stat, err := os.Stat(realPath)
+			if err != nil {
+				p.submit(func() ScanResult { return res.fail("Failed to stat resolved path", err) })
+				return nil
+			}
+
+			// Update the info variable with the resolved path's information
+			info = stat
+		}
+
+		if !info.IsDir() {
+			p.submit(func() ScanResult { return s.ScanFile(ctx, path) })
+		} else {
+			s.stats.scannedFolders.Add(1)
+		}
+
+		return nil
+	})
+}
+
+// A pool runs scans on a fixed number of workers, while the caller keeps
+// walking directories and submitting more, and passes their results to fn in
+// the order they were submitted.
+type pool struct {
+	ctx context.Context
+	fn  func(ScanResult)
+
+	next    int
+	jobs    chan *job
+	results chan *job
+
+	// Bounds the number of jobs submitted but not yet passed to fn, so a
+	// slow file doesn't make the results of all the files after it pile up
+	window chan struct{}
+
+	workers sync.WaitGroup
+	done    chan struct{}
+}
+
+type job struct {
+	seq    int
+	run    func() ScanResult
+	result ScanResult
+}
+
+func newPool(ctx context.Context, n int, fn func(ScanResult)) *pool {
+	n = max(n, 1)
+	p := &pool{
+		ctx:     ctx,
+		fn:      fn,
+		jobs:    make(chan *job, n),
+		results: make(chan *job, n),
+		window:  make(chan struct{}, n*16),
+		done:    make(chan struct{}),
+	}
+	for range n {
+		p.workers.Add(1)
+		go p.work()
+	}
+	go p.emit()
+	return p
+}
+
+// submit queues run to be called by a worker. It blocks while too many jobs
+// are waiting for their results to be passed on, unless ctx is canceled.
+func (p *pool) submit(run func() ScanResult) {
+	select {
+	case p.window <- struct{}{}:
+	case <-p.ctx.Done():
+		return
+	}
+	p.jobs <- &job{seq: p.next, run: run}
+	p.next++
+}
+
+// wait waits for all submitted jobs to finish and their results to be
+// passed on. No more jobs may be submitted afterwards.
+func (p *pool) wait() {
+	close(p.jobs)
+	p.workers.Wait()
+	close(p.results)
+	<-p.done
+}
+
+func (p *pool) work() {
+	defer p.workers.Done()
+	for job := range p.jobs {
+		job.result = job.run()
+		p.results <- job
+	}
+}
+
+// emit passes the results of finished jobs to fn in the order they were
+// submitted.
+func (p *pool) emit() {
+	defer close(p.done)
+	pending := make(map[int]*job)
+	next := 0
+	for job := range p.results {
+		pending[job.seq] = job
+		for {
+			job, ok := pending[next]
+			if !ok {
+				break
+			}
+			if p.ctx.Err() == nil {
+				p.fn(job.result)
+			}
+			delete(pending, next)
+			next++
+			<-p.window
+		}
+	}
+}
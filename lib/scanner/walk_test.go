@@ -0,0 +1,181 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The contents of the test malware, matched by testdata/db.
+const malware = "goava test malware, not harmful\n"
+
+// Results are passed on in the order they were submitted, by at most the
+// configured number of workers, no matter in which order they finish.
+func TestPoolOrder(t *testing.T) {
+	const jobs = 200
+	tests := []struct {
+		workers int
+		// Expected maximum number of concurrent jobs
+		want int
+	}{
+		{0, 1},
+		{-1, 1},
+		{1, 1},
+		{4, 4},
+		{32, 32},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.workers), func(t *testing.T) {
+			var running, maxRunning atomic.Int32
+			var got []string
+			p := newPool(context.Background(), tt.workers, func(res ScanResult) { got = append(got, res.Path) })
+			for i := range jobs {
+				p.submit(func() ScanResult {
+					n := running.Add(1)
+					for {
+						old := maxRunning.Load()
+						if n <= old || maxRunning.CompareAndSwap(old, n) {
+							break
+						}
+					}
+					// Later jobs of each batch finish first
+					time.Sleep(time.Duration(7-i%7) * 100 * time.Microsecond)
+					running.Add(-1)
+					return ScanResult{Path: strconv.Itoa(i)}
+				})
+			}
+			p.wait()
+
+			if len(got) != jobs {
+				t.Fatalf("got %d results, want %d", len(got), jobs)
+			}
+			for i, path := range got {
+				if path != strconv.Itoa(i) {
+					t.Fatalf("result %d is %s, want results in submission order", i, path)
+				}
+			}
+			if n := int(maxRunning.Load()); n > tt.want || tt.want > 1 && n < 2 {
+				t.Errorf("up to %d jobs ran concurrently, want up to %d", n, tt.want)
+			}
+		})
+	}
+}
+
+// Once ctx is canceled, no more results are passed on and submit does not
+// block.
+func TestPoolCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var got []string
+	p := newPool(ctx, 4, func(res ScanResult) {
+		got = append(got, res.Path)
+		if len(got) == 10 {
+			cancel()
+		}
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 10000 {
+			p.submit(func() ScanResult { return ScanResult{Path: fmt.Sprint(i)} })
+		}
+		p.wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("submit blocked after cancel")
+	}
+	if len(got) != 10 {
+		t.Errorf("got %d results, want 10 before cancel", len(got))
+	}
+}
+
+func TestScanPath(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a/evil": malware, "a/clean.txt": "clean\n", "b.txt": "clean\n"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join("a", "evil"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing", filepath.Join(dir, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		opts Options
+		// Paths relative to dir of the results, in order, with their verdicts
+		want []string
+	}{
+		{"file", "a/evil", Options{}, []string{"a/evil infected"}},
+		{"missing", "missing", Options{}, []string{"missing error"}},
+		{"directory", ".", Options{}, []string{". skipped"}},
+		{
+			"recursive", ".", Options{Recursive: true},
+			[]string{"a/clean.txt clean", "a/evil infected", "b.txt clean", "dangling skipped", "link skipped"},
+		},
+		{
+			"follow symlinks", ".", Options{Recursive: true, FollowSymlinks: true},
+			[]string{"a/clean.txt clean", "a/evil infected", "b.txt clean", "dangling error", "a/evil infected"},
+		},
+		{
+			"one job", ".", Options{Recursive: true, Jobs: 1},
+			[]string{"a/clean.txt clean", "a/evil infected", "b.txt clean", "dangling skipped", "link skipped"},
+		},
+		{
+			"many jobs", ".", Options{Recursive: true, Jobs: 64},
+			[]string{"a/clean.txt clean", "a/evil infected", "b.txt clean", "dangling skipped", "link skipped"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScanner(t, tt.opts)
+			var got []string
+			err := s.ScanPath(context.Background(), filepath.Join(dir, tt.path), func(res ScanResult) {
+				// Called by the pool, not the test goroutine
+				rel, err := filepath.Rel(dir, res.Path)
+				if err != nil {
+					t.Error(err)
+				}
+				got = append(got, filepath.ToSlash(rel)+" "+res.Verdict.String())
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// A canceled scan returns the error of its context.
+func TestScanPathCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := newTestScanner(t, Options{Recursive: true})
+	calls := 0
+	if err := s.ScanPath(ctx, "testdata", func(ScanResult) { calls++ }); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if calls != 0 {
+		t.Errorf("got %d results after cancel, want none", calls)
+	}
+}
@@ -0,0 +1,524 @@
+// Package update downloads signature databases from HTTP(S) mirrors.
+package update
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hexahigh/goava/lib/db"
+)
+
+// StateFile is the name of the file, inside the database directory, that
+// stores the validators used for conditional requests.
+const StateFile = ".goava-update.json"
+
+type Updater struct {
+	// Base URLs of the mirrors to download from, in order of preference.
+	// A file is requested as <mirror>/<file>.
+	Mirrors []string
+
+	// Names of the files to download
+	Files []string
+
+	// Path to the database folder. Updated files are installed into it as a
+	// new generation
+	Path string
+
+	// Number of database generations to keep. If 0, every generation is kept
+	Generations int
+
+	// Convert Path to use generations if it is a plain database folder with
+	// files in it, see db.MigrateToGenerations. Otherwise Update fails with
+	// db.ErrPlainFolder for such a folder
+	Migrate bool
+
+	// If not nil, a mirror must publish a manifest signed with the matching
+	// private key, and the downloaded files must match it
+	TrustedKey ed25519.PublicKey
+
+	// The HTTP client used for requests. http.DefaultClient is used if nil
+	Client *http.Client
+
+	// How many times a request to a single mirror is retried before moving
+	// on to the next mirror
+	Retries int
+
+	// The delay before the first retry. It is doubled after every attempt.
+	Backoff time.Duration
+
+	// If enabled, will print log messages
+	Log bool
+
+	// The logger
+	Logger log.Logger
+
+	state state
+
+	// The staging folder files are written to during an update
+	dir string
+
+	// The mirrors the files updated so far were downloaded from, in order
+	// of preference
+	sources []string
+}
+
+// Result describes the outcome of updating a single file.
+type Result struct {
+	File string
+
+	// The mirror the file was fetched from
+	Mirror string
+
+	// True if a new version of the file was downloaded
+	Updated bool
+
+	// Number of bytes downloaded
+	Size int64
+
+	// Non-nil if the file could not be updated from any mirror
+	Err error
+}
+
+type state struct {
+	Files map[string]fileState `json:"files"`
+}
+
+type fileState struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	SHA256       string `json:"sha256,omitempty"`
+}
+
+// errNotFound is returned by fetch when a mirror does not have a file.
+var errNotFound = errors.New("not found on mirror")
+
+// errPermanent wraps errors that should not be retried on the same mirror.
+type errPermanent struct{ err error }
+
+func (e errPermanent) Error() string { return e.err.Error() }
+func (e errPermanent) Unwrap() error { return e.err }
+
+// It's recommended to instantiate your own Updater instance
+func New() *Updater {
+	return &Updater{}
+}
+
+// Update downloads every file in Files from the first mirror that can serve
+// it. Files that have not changed since the last update are not downloaded
+// again.
+//
+// Every downloaded file is written to a temporary file, checked against the
+// mirror's <file>.sha256 checksum if one is published and verified with
+// db.VerifySigFile. If any file changed, the files are installed as a new
+// database generation, so a scan never sees a partially updated database.
+// The manifest of the new generation is taken from the mirrors the files
+// were downloaded from, see install.
+//
+// A Result is returned for every file, along with the number of the
+// installed generation, which is 0 if nothing changed. The returned error is
+// non-nil if at least one file could not be updated. Files that were updated
+// successfully are installed regardless.
+func (u *Updater) Update(ctx context.Context) ([]Result, int, error) {
+	if len(u.Mirrors) == 0 {
+		return nil, 0, errors.New("no mirrors configured")
+	}
+	if u.Path == "" {
+		return nil, 0, errors.New("no database path configured")
+	}
+
+	if err := db.PrepareGenerations(u.Path, u.Migrate); err != nil {
+		return nil, 0, err
+	}
+	staging, err := db.StageGeneration(u.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.RemoveAll(staging)
+	u.dir = staging
+	u.sources = nil
+
+	if err := u.loadState(); err != nil {
+		u.nl(func() { u.Logger.Printf("Could not read update state, downloading everything. Cause: %v", err) })
+		u.state = state{}
+	}
+	if u.state.Files == nil {
+		u.state.Files = make(map[string]fileState)
+	}
+
+	var results []Result
+	var failed, updated int
+	for _, file := range u.Files {
+		res := u.updateFile(ctx, file)
+		if res.Err != nil {
+			failed++
+		}
+		if res.Updated {
+			updated++
+		}
+		results = append(results, res)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	var gen int
+	if updated > 0 {
+		if gen, err = u.install(ctx); err != nil {
+			return results, 0, err
+		}
+		u.nl(func() { u.Logger.Printf("Installed database generation %d", gen) })
+	}
+	if err := ctx.Err(); err != nil {
+		return results, gen, err
+	}
+	if failed > 0 {
+		return results, gen, fmt.Errorf("%d of %d files could not be updated", failed, len(u.Files))
+	}
+	return results, gen, nil
+}
+
+// install writes the update state and a manifest into the staging folder
+// and installs it as a new generation.
+//
+// The manifest is fetched from the mirrors the updated files were downloaded
+// from, never from another mirror, which may publish a different version of
+// the database. The first of their manifests that the files match is used as
+// is. If none of them publishes a manifest, a new, unsigned manifest is
+// created.
+func (u *Updater) install(ctx context.Context) (int, error) {
+	if err := u.saveState(); err != nil {
+		return 0, fmt.Errorf("could not save update state: %w", err)
+	}
+
+	var mismatch error
+	for _, mirror := range u.sources {
+		data, sig, err := u.fetchManifest(ctx, mirror)
+		if err != nil {
+			return 0, err
+		}
+		if data == nil {
+			continue
+		}
+		if err := u.writeManifest(data, sig); err != nil {
+			return 0, err
+		}
+		if _, err := db.VerifyManifest(u.dir, u.TrustedKey); err != nil {
+			mismatch = fmt.Errorf("downloaded files do not match the manifest of %s: %w", mirror, err)
+			continue
+		}
+		return db.InstallGeneration(u.Path, u.dir, u.Generations)
+	}
+	if mismatch != nil {
+		return 0, mismatch
+	}
+
+	if u.TrustedKey != nil {
+		return 0, fmt.Errorf("no mirror the files were downloaded from publishes a signed manifest")
+	}
+	manifest, err := db.BuildManifest(u.dir, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	if err := manifest.Write(u.dir); err != nil {
+		return 0, err
+	}
+	// A signature copied from the previous generation is no longer valid
+	if err := os.Remove(filepath.Join(u.dir, db.ManifestSigFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	return db.InstallGeneration(u.Path, u.dir, u.Generations)
+}
+
+// writeManifest writes the manifest and signature fetched from a mirror into
+// the staging folder.
+func (u *Updater) writeManifest(data, sig []byte) error {
+	files := map[string][]byte{db.ManifestFile: data}
+	if sig != nil {
+		files[db.ManifestSigFile] = sig
+	} else if err := os.Remove(filepath.Join(u.dir, db.ManifestSigFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for name, content := range files {
+		if err := writeFileAtomic(u.dir, name, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateFile tries every mirror in order until one of them serves file.
+func (u *Updater) updateFile(ctx context.Context, file string) Result {
+	res := Result{File: file}
+	if file == "" || file != filepath.Base(file) || strings.HasPrefix(file, ".") {
+		res.Err = fmt.Errorf("invalid file name %q", file)
+		return res
+	}
+
+	var errs []error
+	for _, mirror := range u.Mirrors {
+		res.Mirror = mirror
+		updated, size, err := u.fetchWithRetry(ctx, mirror, file)
+		if err == nil {
+			res.Updated, res.Size = updated, size
+			if updated && !slices.Contains(u.sources, mirror) {
+				u.sources = append(u.sources, mirror)
+			}
+			return res
+		}
+		u.nl(func() { u.Logger.Printf("Could not fetch %s from %s: %v", file, mirror, err) })
+		errs = append(errs, fmt.Errorf("%s: %w", mirror, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	res.Mirror = ""
+	res.Err = errors.Join(errs...)
+	return res
+}
+
+// fetchWithRetry calls fetch until it succeeds, returns a permanent error or
+// runs out of retries.
+func (u *Updater) fetchWithRetry(ctx context.Context, mirror, file string) (bool, int64, error) {
+	delay := u.Backoff
+	for attempt := 0; ; attempt++ {
+		updated, size, err := u.fetch(ctx, mirror, file)
+		if err == nil {
+			return updated, size, nil
+		}
+		var perm errPermanent
+		if errors.As(err, &perm) || errors.Is(err, errNotFound) || attempt >= u.Retries {
+			return false, 0, err
+		}
+		u.nl(func() { u.Logger.Printf("Fetching %s from %s failed, retrying in %s: %v", file, mirror, delay, err) })
+		select {
+		case <-ctx.Done():
+			return false, 0, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// fetch downloads file from mirror and installs it. It returns false if the
+// mirror reported that the file is unchanged.
+func (u *Updater) fetch(ctx context.Context, mirror, file string) (bool, int64, error) {
+	fileURL, err := url.JoinPath(mirror, file)
+	if err != nil {
+		return false, 0, errPermanent{err}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return false, 0, errPermanent{err}
+	}
+
+	dst := filepath.Join(u.dir, file)
+	prev, hasPrev := u.state.Files[file]
+	if _, err := os.Stat(dst); err == nil && hasPrev {
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
+
+	resp, err := u.client().Do(req)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		u.nl(func() { u.Logger.Printf("%s is up to date", file) })
+		return false, 0, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return false, 0, errNotFound
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+		return false, 0, fmt.Errorf("unexpected status %s", resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return false, 0, errPermanent{fmt.Errorf("unexpected status %s", resp.Status)}
+	}
+
+	expected, err := u.fetchChecksum(ctx, fileURL)
+	if err != nil {
+		return false, 0, err
+	}
+
+	tmp, err := os.CreateTemp(u.dir, "."+file+"-*.tmp")
+	if err != nil {
+		return false, 0, errPermanent{err}
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), resp.Body)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, 0, err
+	}
+
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	if expected != "" && !strings.EqualFold(expected, sum) {
+		return false, 0, fmt.Errorf("checksum mismatch: expected %s, got %s", expected, sum)
+	}
+	if err := db.VerifySigFile(tmp.Name(), file); err != nil {
+		return false, 0, errPermanent{fmt.Errorf("verification failed: %w", err)}
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return false, 0, errPermanent{err}
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return false, 0, errPermanent{err}
+	}
+
+	u.state.Files[file] = fileState{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		SHA256:       sum,
+	}
+	u.nl(func() { u.Logger.Printf("Updated %s from %s", file, mirror) })
+	return true, size, nil
+}
+
+// fetchChecksum returns the hex encoded SHA-256 checksum published next to
+// fileURL, or an empty string if the mirror does not publish one.
+func (u *Updater) fetchChecksum(ctx context.Context, fileURL string) (string, error) {
+	// Accept both a bare checksum and the "<checksum>  <name>" format of sha256sum
+	body, err := u.get(ctx, fileURL+".sha256", 1024)
+	if errors.Is(err, errNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("fetching checksum: %w", err)
+	}
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", errPermanent{fmt.Errorf("malformed checksum file")}
+	}
+	return fields[0], nil
+}
+
+// fetchManifest returns the manifest and manifest signature published by
+// mirror. The signature is nil if the mirror does not publish one, and both
+// are nil if it publishes no manifest.
+func (u *Updater) fetchManifest(ctx context.Context, mirror string) ([]byte, []byte, error) {
+	manifestURL, err := url.JoinPath(mirror, db.ManifestFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := u.get(ctx, manifestURL, 64<<20)
+	if errors.Is(err, errNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetching manifest from %s: %w", mirror, err)
+	}
+	sigURL, err := url.JoinPath(mirror, db.ManifestSigFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, err := u.get(ctx, sigURL, 1024)
+	if errors.Is(err, errNotFound) {
+		return data, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetching manifest signature from %s: %w", mirror, err)
+	}
+	return data, sig, nil
+}
+
+// get returns the body of the resource at rawURL, reading at most limit
+// bytes. errNotFound is returned if the resource does not exist.
+func (u *Updater) get(ctx context.Context, rawURL string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, errPermanent{err}
+	}
+	resp, err := u.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, limit))
+}
+
+func (u *Updater) client() *http.Client {
+	if u.Client != nil {
+		return u.Client
+	}
+	return http.DefaultClient
+}
+
+func (u *Updater) loadState() error {
+	data, err := os.ReadFile(filepath.Join(u.dir, StateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &u.state)
+}
+
+func (u *Updater) saveState() error {
+	data, err := json.MarshalIndent(u.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(u.dir, StateFile, data)
+}
+
+// writeFileAtomic writes data to a temporary file in dir and renames it to
+// name.
+func writeFileAtomic(dir, name string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, "."+name+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// Runs the specified function if Log is true
+func (u *Updater) nl(f func()) {
+	if u.Log {
+		f()
+	}
+}
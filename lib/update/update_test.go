@@ -0,0 +1,336 @@
+package update
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hexahigh/goava/lib/db"
+)
+
+const (
+	mainHDB  = "44d88612fea8a8f36de82e1278abb02f:68:Eicar-Test-Signature\n"
+	extraHDB = "b026324c6904b2a9cb4b88d6d61c81d1:2:Test.Extra-1-0\n"
+)
+
+// A mirror serves files from memory with an ETag and a Last-Modified date,
+// answering conditional requests with 304, and records the requests it got.
+type mirror struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	files    map[string]string
+	requests []*http.Request
+
+	// If set, every request fails with this status
+	status int
+}
+
+func newMirror(t *testing.T, files map[string]string) *mirror {
+	t.Helper()
+	m := &mirror{files: files}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serve))
+	t.Cleanup(m.Close)
+	return m
+}
+
+var lastModified = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat)
+
+func (m *mirror) serve(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, r)
+	if m.status != 0 {
+		w.WriteHeader(m.status)
+		return
+	}
+	content, ok := m.files[strings.TrimPrefix(r.URL.Path, "/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	sum := sha256.Sum256([]byte(content))
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write([]byte(content))
+}
+
+// requestsFor returns the requests the mirror got for file.
+func (m *mirror) requestsFor(file string) []*http.Request {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var reqs []*http.Request
+	for _, r := range m.requests {
+		if r.URL.Path == "/"+file {
+			reqs = append(reqs, r)
+		}
+	}
+	return reqs
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUpdateNotModified(t *testing.T) {
+	m := newMirror(t, map[string]string{"main.hdb": mainHDB})
+	u := &Updater{Mirrors: []string{m.URL}, Files: []string{"main.hdb"}, Path: t.TempDir()}
+
+	results, gen, err := u.Update(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gen != 1 || !results[0].Updated {
+		t.Fatalf("first update: generation %d, updated %v, want 1, true", gen, results[0].Updated)
+	}
+
+	results, gen, err = u.Update(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gen != 0 || results[0].Updated {
+		t.Fatalf("second update: generation %d, updated %v, want 0, false", gen, results[0].Updated)
+	}
+	reqs := m.requestsFor("main.hdb")
+	if len(reqs) != 2 {
+		t.Fatalf("got %d requests for main.hdb, want 2", len(reqs))
+	}
+	if reqs[1].Header.Get("If-None-Match") == "" {
+		t.Error("second request has no If-None-Match header")
+	}
+	if got := reqs[1].Header.Get("If-Modified-Since"); got != lastModified {
+		t.Errorf("second request has If-Modified-Since %q, want %q", got, lastModified)
+	}
+}
+
+func TestUpdateRetryAndFailover(t *testing.T) {
+	broken := newMirror(t, nil)
+	broken.status = http.StatusServiceUnavailable
+	good := newMirror(t, map[string]string{"main.hdb": mainHDB})
+
+	const backoff = 10 * time.Millisecond
+	u := &Updater{
+		Mirrors: []string{broken.URL, good.URL},
+		Files:   []string{"main.hdb"},
+		Path:    t.TempDir(),
+		Retries: 2,
+		Backoff: backoff,
+	}
+	start := time.Now()
+	results, gen, err := u.Update(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(broken.requestsFor("main.hdb")); n != 3 {
+		t.Errorf("broken mirror got %d requests, want 1 and 2 retries", n)
+	}
+	// The delay doubles after every attempt
+	if elapsed := time.Since(start); elapsed < backoff+2*backoff {
+		t.Errorf("update took %s, less than the backoff", elapsed)
+	}
+	if results[0].Mirror != good.URL || !results[0].Updated || gen != 1 {
+		t.Errorf("got mirror %s, updated %v, generation %d, want %s, true, 1", results[0].Mirror, results[0].Updated, gen, good.URL)
+	}
+}
+
+func TestUpdateChecksum(t *testing.T) {
+	tests := []struct {
+		name     string
+		checksum string
+		wantErr  string
+	}{
+		{"match", sha256Hex(mainHDB) + "  main.hdb\n", ""},
+		{"mismatch", sha256Hex("something else") + "  main.hdb\n", "checksum mismatch"},
+		{"malformed", "not a checksum\n", "malformed checksum file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMirror(t, map[string]string{"main.hdb": mainHDB, "main.hdb.sha256": tt.checksum})
+			root := t.TempDir()
+			u := &Updater{Mirrors: []string{m.URL}, Files: []string{"main.hdb"}, Path: root}
+
+			results, gen, err := u.Update(context.Background())
+			if tt.wantErr == "" {
+				if err != nil || gen != 1 {
+					t.Fatalf("got generation %d, error %v, want 1, nil", gen, err)
+				}
+				return
+			}
+			if err == nil || results[0].Err == nil || !strings.Contains(results[0].Err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", results[0].Err, tt.wantErr)
+			}
+			if gen != 0 {
+				t.Errorf("installed generation %d from a rejected download", gen)
+			}
+			if gens, _ := db.Generations(root); len(gens) != 0 {
+				t.Errorf("got %d generations, want none", len(gens))
+			}
+		})
+	}
+}
+
+func TestUpdateStagesGeneration(t *testing.T) {
+	root := t.TempDir()
+	// A plain database folder, whose files are kept by the update
+	if err := os.WriteFile(filepath.Join(root, "local.hdb"), []byte(extraHDB), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMirror(t, map[string]string{"main.hdb": mainHDB})
+	u := &Updater{Mirrors: []string{m.URL}, Files: []string{"main.hdb"}, Path: root, Migrate: true}
+	_, gen, err := u.Update(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The plain folder became generation 1 and the update generation 2
+	if gen != 2 {
+		t.Fatalf("installed generation %d, want 2", gen)
+	}
+	if active, err := db.ActiveGeneration(root); err != nil || active != gen {
+		t.Fatalf("active generation is %d (%v), want %d", active, err, gen)
+	}
+	active := db.ActivePath(root)
+	for name, want := range map[string]string{"main.hdb": mainHDB, "local.hdb": extraHDB} {
+		data, err := os.ReadFile(filepath.Join(active, name))
+		if err != nil || string(data) != want {
+			t.Errorf("%s in the active generation: %q, %v", name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(active, StateFile)); err != nil {
+		t.Errorf("no update state in the active generation: %v", err)
+	}
+	if _, err := db.VerifyManifest(active, nil); err != nil {
+		t.Errorf("manifest of the active generation: %v", err)
+	}
+
+	// Nothing is left behind in the database folder
+	entries, _ := os.ReadDir(root)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".staging-") {
+			t.Errorf("staging folder %s left behind", e.Name())
+		}
+	}
+}
+
+// A plain database folder with files in it is left alone unless Migrate is
+// set, and nothing is downloaded.
+func TestUpdatePlainFolder(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "main.cvd"), []byte("freshclam\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMirror(t, map[string]string{"main.hdb": mainHDB})
+	u := &Updater{Mirrors: []string{m.URL}, Files: []string{"main.hdb"}, Path: root}
+	_, gen, err := u.Update(context.Background())
+	if !errors.Is(err, db.ErrPlainFolder) || gen != 0 {
+		t.Fatalf("got generation %d, error %v, want %v", gen, err, db.ErrPlainFolder)
+	}
+	if len(m.requests) != 0 {
+		t.Errorf("got %d requests, want none", len(m.requests))
+	}
+	entries, _ := os.ReadDir(root)
+	if len(entries) != 1 {
+		t.Errorf("got %d entries in the database folder, want only main.cvd", len(entries))
+	}
+}
+
+// manifestOf returns the manifest of version listing the files with their
+// contents.
+func manifestOf(t *testing.T, version int64, files map[string]string) string {
+	t.Helper()
+	m := &db.Manifest{Version: version, Created: time.Unix(version, 0).UTC(), Files: make(map[string]string)}
+	for name, content := range files {
+		m.Files[name] = sha256Hex(content)
+	}
+	data, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// The manifest is taken from the mirrors the files were downloaded from, not
+// from the first mirror that publishes one.
+func TestUpdateManifestFromSourceMirror(t *testing.T) {
+	tests := []struct {
+		name string
+		// Files of the first and second mirror
+		first, second map[string]string
+		// Expected version of the installed manifest, 0 for a built one
+		want    int64
+		wantErr bool
+	}{
+		{
+			name: "file from second mirror",
+			// The first mirror publishes another version without main.hdb
+			first:  map[string]string{"daily.hdb": extraHDB, db.ManifestFile: manifestOf(t, 1, map[string]string{"main.hdb": extraHDB, "daily.hdb": extraHDB})},
+			second: map[string]string{"main.hdb": mainHDB, "daily.hdb": extraHDB, db.ManifestFile: manifestOf(t, 2, map[string]string{"main.hdb": mainHDB, "daily.hdb": extraHDB})},
+			want:   2,
+		},
+		{
+			name:   "second mirror without manifest",
+			first:  map[string]string{"daily.hdb": extraHDB, db.ManifestFile: manifestOf(t, 1, map[string]string{"main.hdb": extraHDB, "daily.hdb": extraHDB})},
+			second: map[string]string{"main.hdb": mainHDB},
+			// daily.hdb comes from the first mirror, whose manifest does
+			// not match main.hdb
+			wantErr: true,
+		},
+		{
+			name:   "files from both mirrors",
+			first:  map[string]string{"daily.hdb": extraHDB, db.ManifestFile: manifestOf(t, 3, map[string]string{"main.hdb": mainHDB, "daily.hdb": extraHDB})},
+			second: map[string]string{"main.hdb": mainHDB, db.ManifestFile: manifestOf(t, 4, map[string]string{"main.hdb": mainHDB})},
+			want:   3,
+		},
+		{
+			name:   "no manifest from source mirrors",
+			first:  map[string]string{db.ManifestFile: manifestOf(t, 5, map[string]string{"main.hdb": extraHDB})},
+			second: map[string]string{"main.hdb": mainHDB, "daily.hdb": extraHDB},
+			want:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, second := newMirror(t, tt.first), newMirror(t, tt.second)
+			root := t.TempDir()
+			u := &Updater{Mirrors: []string{first.URL, second.URL}, Files: []string{"main.hdb", "daily.hdb"}, Path: root}
+			_, gen, err := u.Update(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("installed generation %d, want an error", gen)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			manifest, err := db.VerifyManifest(db.ActivePath(root), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want != 0 && manifest.Version != tt.want {
+				t.Errorf("installed manifest version %d, want %d", manifest.Version, tt.want)
+			}
+			if tt.want == 0 && manifest.Version < 100 {
+				t.Errorf("installed manifest version %d, want a built manifest", manifest.Version)
+			}
+		})
+	}
+}